@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+// Audit event types.
+const (
+	auditLoginSucceeded     = "login.succeeded"
+	auditLoginFailed        = "login.failed"
+	auditLoginLocked        = "login.locked"
+	auditLockoutCleared     = "login.lockout_cleared"
+	auditIdentityLinked     = "login.identity_linked"
+	auditReauthenticated    = "login.reauthenticated"
+	auditTokenRefreshed     = "token.refreshed"
+	auditTokenReused        = "token.reuse_detected"
+	auditTokenRevoked       = "token.revoked"
+	auditSessionRevoked     = "session.revoked"
+	auditSessionsRevoked    = "session.revoked_all"
+	auditPasswordChanged    = "password.changed"
+	auditPasswordReset      = "password.reset"
+	auditEmailChangeStarted = "email.change_requested"
+	auditEmailVerified      = "email.verified"
+	auditMFAEnabled         = "mfa.enabled"
+	auditMFADisabled        = "mfa.disabled"
+	auditRecoveryCodesReset = "mfa.recovery_codes_regenerated"
+	auditPasskeyAdded       = "passkey.added"
+	auditPasskeyRemoved     = "passkey.removed"
+	auditPasskeyCloned      = "passkey.clone_detected"
+	auditAPIKeyCreated      = "api_key.created"
+	auditAPIKeyRevoked      = "api_key.revoked"
+	auditChirpDeleted       = "chirp.deleted"
+	auditExportRequested    = "export.requested"
+	auditExportDownloaded   = "export.downloaded"
+	auditImportRequested    = "import.requested"
+	auditImportCompleted    = "import.completed"
+	auditRoleChanged        = "user.role_changed"
+	auditUserSuspended      = "user.suspended"
+	auditUserReinstated     = "user.unsuspended"
+	auditPasswordForced     = "user.password_reset_forced"
+	auditUserDeleted        = "user.deleted"
+	auditDeletionScheduled  = "user.deletion_scheduled"
+	auditDeletionCancelled  = "user.deletion_cancelled"
+	auditUsersSearched      = "admin.users_searched"
+	auditUserViewed         = "admin.user_viewed"
+	auditDatabaseReset      = "admin.database_reset"
+	auditReportClaimed      = "report.claimed"
+	auditReportResolved     = "report.resolved"
+)
+
+// securityEventTypes are the events users see about their own account. The
+// rest concern staff, or the work they do, and stay in the admin log.
+var securityEventTypes = []string{
+	auditLoginSucceeded,
+	auditLoginFailed,
+	auditLoginLocked,
+	auditIdentityLinked,
+	auditReauthenticated,
+	auditTokenReused,
+	auditTokenRevoked,
+	auditSessionRevoked,
+	auditSessionsRevoked,
+	auditPasswordChanged,
+	auditPasswordReset,
+	auditEmailChangeStarted,
+	auditEmailVerified,
+	auditMFAEnabled,
+	auditMFADisabled,
+	auditRecoveryCodesReset,
+	auditPasskeyAdded,
+	auditPasskeyRemoved,
+	auditPasskeyCloned,
+	auditAPIKeyCreated,
+	auditAPIKeyRevoked,
+	auditRoleChanged,
+	auditUserSuspended,
+	auditUserReinstated,
+	auditPasswordForced,
+	auditDeletionScheduled,
+	auditDeletionCancelled,
+	auditExportRequested,
+	auditExportDownloaded,
+}
+
+// audit appends an event to the audit log. The actor is whoever caused the
+// event and the target whoever it happened to; either may be uuid.Nil. req
+// is the request behind the event, or nil for background work. A failed
+// write is logged but never fails the request it describes.
+func (cfg *apiConfig) audit(ctx context.Context, req *http.Request, eventType string, actorID, targetID uuid.UUID, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s audit event: %s", eventType, err)
+		return
+	}
+	var ipAddress, userAgent string
+	if req != nil {
+		ipAddress = clientIP(req)
+		userAgent = req.UserAgent()
+	}
+	err = cfg.dbQueries.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		EventType: eventType,
+		ActorID:   uuid.NullUUID{UUID: actorID, Valid: actorID != uuid.Nil},
+		TargetID:  uuid.NullUUID{UUID: targetID, Valid: targetID != uuid.Nil},
+		IpAddress: ipAddress,
+		UserAgent: userAgent,
+		Payload:   data,
+	})
+	if err != nil {
+		log.Printf("Error writing %s audit event: %s", eventType, err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/chirpimport"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+const cliUsage = `usage: chirpy [command]
+
+Without a command, chirpy serves the API. Commands:
+  bootstrap-admin <email>       make the user with this email the first admin
+  import-chirps <email> <file>  import a .jsonl or .csv archive of chirps for
+                                the user with this email`
+
+// runCommand runs a maintenance command given on the command line instead
+// of serving.
+func runCommand(ctx context.Context, db *sql.DB, args []string) error {
+	q := database.New(db)
+	switch args[0] {
+	case "bootstrap-admin":
+		if len(args) != 2 {
+			return errors.New(cliUsage)
+		}
+		return bootstrapAdmin(ctx, q, args[1])
+	case "import-chirps":
+		if len(args) != 3 {
+			return errors.New(cliUsage)
+		}
+		cfg := &apiConfig{db: db, dbQueries: q}
+		return cfg.importChirps(ctx, args[1], args[2])
+	case "help", "-h", "-help", "--help":
+		fmt.Println(cliUsage)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q\n%s", args[0], cliUsage)
+}
+
+// bootstrapAdmin promotes a user to admin, but only while there is no admin
+// yet. From then on, admins manage roles themselves. Like any role change,
+// it bumps the user's token version, so tokens with the old role stop
+// working.
+func bootstrapAdmin(ctx context.Context, q *database.Queries, email string) error {
+	user, err := q.GetUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("finding %s: %w", email, err)
+	}
+	promoted, err := q.BootstrapAdmin(ctx, email)
+	if err != nil {
+		return err
+	}
+	if promoted == 0 {
+		return errors.New("there already is an admin; have them change roles instead")
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"role":     "admin",
+		"previous": user.Role,
+		"source":   "bootstrap-admin",
+	})
+	if err != nil {
+		return err
+	}
+	err = q.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		EventType: auditRoleChanged,
+		TargetID:  uuid.NullUUID{UUID: user.ID, Valid: true},
+		Payload:   payload,
+	})
+	if err != nil {
+		log.Printf("Error writing %s audit event: %s", auditRoleChanged, err)
+	}
+	fmt.Printf("%s is now an admin; they need to log in again to use it\n", email)
+	return nil
+}
+
+// importChirps imports an archive of chirps for a user, the same way the
+// import endpoint does, but right away rather than through the queue. If
+// it's cut off, the server carries the import on.
+func (cfg *apiConfig) importChirps(ctx context.Context, email, path string) error {
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if err != nil {
+		return fmt.Errorf("finding %s: %w", email, err)
+	}
+	format := chirpimport.FormatFor("", path)
+	if format == "" {
+		return fmt.Errorf("%s: %w", path, chirpimport.ErrUnknownFormat)
+	}
+	archive, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	reader, err := chirpimport.NewReader(format, bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	total, err := reader.Count()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	claimID := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	job, err := cfg.dbQueries.CreateImportJob(ctx, database.CreateImportJobParams{
+		UserID:    user.ID,
+		Status:    "running",
+		Format:    format,
+		Archive:   archive,
+		TotalRows: int32(total),
+		ClaimID:   claimID,
+	})
+	if err != nil {
+		return err
+	}
+	cfg.audit(ctx, nil, auditImportRequested, uuid.Nil, user.ID, map[string]any{
+		"import_id": job.ID,
+		"format":    format,
+		"rows":      total,
+		"source":    "import-chirps",
+	})
+	fmt.Printf("Importing %d rows for %s as import %s\n", total, email, job.ID)
+
+	cfg.runImport(ctx, database.ClaimImportJobRow{
+		ID:      job.ID,
+		UserID:  user.ID,
+		ClaimID: claimID,
+		Format:  format,
+		Archive: archive,
+	}, func(processed int32) {
+		fmt.Printf("%d/%d rows\n", processed, total)
+	})
+
+	result, err := cfg.dbQueries.GetImportJob(ctx, database.GetImportJobParams{ID: job.ID, UserID: user.ID})
+	if err != nil {
+		return err
+	}
+	switch result.Status {
+	case "failed":
+		return fmt.Errorf("import failed: %s", result.Error)
+	case "running":
+		return fmt.Errorf("import %s was cut off at row %d; the server will carry it on", job.ID, result.ProcessedRows)
+	}
+	fmt.Printf("Imported %d, skipped %d already imported, %d failed\n", result.ImportedRows, result.SkippedRows, result.FailedRows)
+	rowErrors := []importRowError{}
+	err = json.Unmarshal(result.RowErrors, &rowErrors)
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range rowErrors {
+		fmt.Printf("  line %d: %s\n", rowErr.Line, rowErr.Error)
+	}
+	if int(result.FailedRows) > len(rowErrors) {
+		fmt.Printf("  and %d more\n", int(result.FailedRows)-len(rowErrors))
+	}
+	return nil
+}
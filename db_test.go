@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+)
+
+// newTestConfig connects to the database in TEST_DB_URL, which has to be
+// migrated already, and skips the test when it isn't set. Tests create their
+// own users with random emails, so they can share a database.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dbURL := os.Getenv("TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("TEST_DB_URL isn't set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &apiConfig{
+		db:        db,
+		dbQueries: database.New(db),
+		platform:  "dev",
+		jwt: auth.JWTConfig{
+			Keys:     auth.NewHMACKeyring("test-secret"),
+			Issuer:   "chirpy",
+			Audience: "chirpy",
+		},
+		accessTokenTTL:  time.Hour,
+		refreshTokenTTL: time.Hour,
+		mailer:          &recordingMailer{},
+		accountLockout:  auth.LockoutPolicy{FreeAttempts: 5, BaseDelay: time.Second, MaxDelay: time.Minute},
+		ipLockout:       auth.LockoutPolicy{FreeAttempts: 50, BaseDelay: time.Second, MaxDelay: time.Minute},
+
+		loginFailureWindow: time.Hour,
+		passwordPolicy:     auth.PasswordPolicy{MinLength: 8, MaxLength: 256},
+		argon2: auth.Argon2Params{
+			Memory:      1024,
+			Iterations:  1,
+			Parallelism: 1,
+			SaltLength:  auth.DefaultArgon2Params.SaltLength,
+			KeyLength:   auth.DefaultArgon2Params.KeyLength,
+		},
+	}
+}
+
+// createTestUser adds a user with a random email and the given password.
+func createTestUser(t *testing.T, cfg *apiConfig, password string) database.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password, cfg.argon2)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	user, err := cfg.dbQueries.CreateUser(t.Context(), database.CreateUserParams{
+		Email:          uuid.NewString() + "@example.com",
+		HashedPassword: hash,
+	})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return user
+}
+
+// createTestSession gives the user a refresh token in a new session.
+func createTestSession(t *testing.T, cfg *apiConfig, userID uuid.UUID, expiresAt time.Time) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = cfg.dbQueries.CreateRefreshToken(t.Context(), database.CreateRefreshTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		FamilyID:  uuid.New(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return token
+}
+
+// createTestAccessToken signs an access token for the user.
+func createTestAccessToken(t *testing.T, cfg *apiConfig, user database.User) string {
+	t.Helper()
+	token, err := auth.MakeAccessToken(user.ID, user.TokenVersion, user.Role, cfg.jwt, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return token
+}
+
+// serve calls a handler with a bearer token, and a JSON body unless body is
+// empty.
+func serve(handler http.HandlerFunc, method, target, token, body string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// decodeUser reads the user a handler responded with.
+func decodeUser(t *testing.T, rec *httptest.ResponseRecorder) User {
+	t.Helper()
+	user := User{}
+	err := json.Unmarshal(rec.Body.Bytes(), &user)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return user
+}
+
+// recordingMailer keeps the messages it's given instead of sending them.
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
@@ -1,27 +1,47 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/wjseele/chirpy/internal/auth"
 	"github.com/wjseele/chirpy/internal/database"
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	Role          string    `json:"role"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+}
+
+func newUserResponse(user database.User) User {
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		PendingEmail:  user.PendingEmail.String,
+		Role:          user.Role,
+	}
 }
 
 type chirpResponse struct {
@@ -43,67 +63,193 @@ func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	})
 }
 
-func respondWithError(w http.ResponseWriter, code int, msg string) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(code)
-	_, err := io.WriteString(w, msg)
+func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error in the responder: %s", err)
+		respondWithInternalError(w, err)
 		return
 	}
-}
-
-func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
-	dat, err := json.Marshal(payload)
+	w.Write(dat)
+}
+
+// isUniqueViolation reports whether err is Postgres refusing a duplicate in
+// a unique column.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// checkPassword adds an error for the password field, listing every rule
+// the password breaks, when it isn't acceptable. userInputs are the
+// account's details, which the password must not be built from.
+func (cfg *apiConfig) checkPassword(errs *fieldErrors, password string, userInputs ...string) error {
+	violations, err := cfg.passwordPolicy.Check(password, userInputs...)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
-		return
+		return err
 	}
-	w.Write(dat)
+	if len(violations) > 0 {
+		*errs = append(*errs, fieldError{
+			Field:      "password",
+			Message:    "Password doesn't meet the password policy",
+			Violations: violations,
+		})
+	}
+	return nil
 }
 
-func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, req *http.Request) {
-	type chirpPost struct {
-		Body   string    `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
+// checkPasswordPolicy responds with a 422 and returns false when the
+// password is the only field to check and it isn't acceptable.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, password string, userInputs ...string) bool {
+	errs := fieldErrors{}
+	err := cfg.checkPassword(&errs, password, userInputs...)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
 	}
+	return errs.check(w)
+}
 
+// authenticate validates the access token in the Authorization header and
+// returns the ID of the user it was issued to. API keys are refused, so
+// managing the account always takes a real login.
+func (cfg *apiConfig) authenticate(req *http.Request) (uuid.UUID, error) {
 	token, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
-		return
+		return uuid.Nil, err
+	}
+	if auth.IsAPIKey(token) {
+		return uuid.Nil, auth.ErrAPIKeyNotAllowed
+	}
+	return cfg.validateAccessToken(req, token)
+}
+
+// authenticateScoped is authenticate for endpoints that bots and third-party
+// apps may call too: it also accepts an API key or an OAuth access token, as
+// long as it was granted scope.
+func (cfg *apiConfig) authenticateScoped(req *http.Request, scope string) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(req.Header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !auth.IsAPIKey(token) {
+		userID, err := cfg.validateAccessToken(req, token)
+		if !errors.Is(err, auth.ErrTokenClaims) {
+			return userID, err
+		}
+		// Not a login token, but it may be one issued to an OAuth client,
+		// which has an audience of its own.
+		claims, err := cfg.oauth.ValidateAccessToken(req.Context(), token)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !slices.Contains(claims.Scopes(), scope) {
+			return uuid.Nil, auth.ErrInsufficientScope
+		}
+		return uuid.Parse(claims.Subject)
+	}
+
+	key, err := cfg.dbQueries.UseAPIKey(req.Context(), auth.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, auth.ErrInvalidAPIKey
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !slices.Contains(key.Scopes, scope) {
+		return uuid.Nil, auth.ErrInsufficientScope
+	}
+	return key.UserID, nil
+}
+
+func (cfg *apiConfig) validateAccessToken(req *http.Request, token string) (uuid.UUID, error) {
+	claims, err := cfg.accessTokenClaims(req, token)
+	if err != nil {
+		return uuid.Nil, err
 	}
+	return uuid.Parse(claims.Subject)
+}
+
+func (cfg *apiConfig) accessTokenClaims(req *http.Request, token string) (auth.Claims, error) {
+	return auth.ValidateAccessToken(token, cfg.jwt, func(userID uuid.UUID) (int32, error) {
+		return cfg.dbQueries.GetTokenVersion(req.Context(), userID)
+	})
+}
+
+// respondWithAuthError turns an error from authenticate into a 401 that tells
+// the client what was wrong with its credential, or a 403 when the
+// credential is fine but not allowed here. Failures that aren't the
+// credential's fault, such as the database being down, are a 500.
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	var msg, code string
+	switch {
+	case errors.Is(err, auth.ErrNoBearerToken):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy"`)
+		respondWithCode(w, 401, "missing_token", "Missing bearer token")
+		return
+	case errors.Is(err, auth.ErrTokenExpired):
+		msg, code = "Access token has expired", "token_expired"
+	case errors.Is(err, auth.ErrTokenNotYetValid):
+		msg, code = "Access token is not valid yet", "token_not_yet_valid"
+	case errors.Is(err, auth.ErrTokenMalformed):
+		msg, code = "Access token is malformed", "token_malformed"
+	case errors.Is(err, auth.ErrTokenSignature):
+		msg, code = "Access token signature is invalid", "token_signature_invalid"
+	case errors.Is(err, auth.ErrTokenClaims):
+		msg, code = "Access token was not issued for this service", "token_wrong_audience"
+	case errors.Is(err, auth.ErrTokenRevoked), errors.Is(err, sql.ErrNoRows):
+		msg, code = "Access token has been revoked", "token_revoked"
+	case errors.Is(err, auth.ErrInvalidAPIKey):
+		msg, code = "API key is invalid, revoked or expired", "api_key_invalid"
+	case errors.Is(err, auth.ErrInsufficientScope):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chirpy", error="insufficient_scope"`)
+		respondWithCode(w, 403, "insufficient_scope", "Credential doesn't have the scope for this")
+		return
+	case errors.Is(err, auth.ErrAPIKeyNotAllowed):
+		respondWithCode(w, 403, "api_key_not_allowed", "API keys can't be used for this, log in instead")
+		return
+	default:
+		respondWithInternalError(w, fmt.Errorf("authenticating request: %w", err))
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="invalid_token", error_description=%q`, msg))
+	respondWithCode(w, 401, code, msg)
+}
 
-	userID, err := auth.ValidateJWT(token, cfg.tokenSecret)
+func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, req *http.Request) {
+	type chirpPost struct {
+		Body string `json:"body"`
+	}
 
+	userID, err := cfg.authenticateScoped(req, auth.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithAuthError(w, err)
+		return
+	}
+	if !cfg.checkEmailVerified(w, req, userID, restrictPostChirps) {
 		return
 	}
 
-	decoder := json.NewDecoder(req.Body)
 	post := chirpPost{}
-	err = decoder.Decode(&post)
-	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("%s", err))
+	if !decodeJSON(w, req, &post) {
 		return
 	}
-	post.UserID = userID
 
-	if len(post.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	cleanedBody, err := cleanChirp(post.Body)
+	if err != nil {
+		errs := fieldErrors{}
+		errs.add("body", fmt.Sprintf("%s", err))
+		errs.check(w)
 		return
 	}
-	cleanedBody := badWordFilter(post.Body)
 	newChirp := database.CreateChirpParams{
 		Body:   cleanedBody,
-		UserID: post.UserID,
+		UserID: userID,
 	}
 	response, err := cfg.dbQueries.CreateChirp(req.Context(), newChirp)
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
@@ -117,6 +263,23 @@ func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, req *http.Reques
 	respondWithJSON(w, 201, resp)
 }
 
+var (
+	errChirpEmpty   = errors.New("Chirp is empty")
+	errChirpTooLong = errors.New("Chirp is too long")
+)
+
+// cleanChirp applies the rules every chirp has to follow, however it's
+// posted, and returns the body as it's stored.
+func cleanChirp(body string) (string, error) {
+	if strings.TrimSpace(body) == "" {
+		return "", errChirpEmpty
+	}
+	if len(body) > 140 {
+		return "", errChirpTooLong
+	}
+	return badWordFilter(body), nil
+}
+
 func badWordFilter(s string) string {
 	bodyWords := strings.Split(s, " ")
 	for i := range bodyWords {
@@ -129,10 +292,29 @@ func badWordFilter(s string) string {
 	return cleanedBody
 }
 
+// checkChirpsReadScope lets anonymous requests through, since chirps are
+// public, but holds a request that does carry credentials to them: an API key
+// or OAuth token needs the chirps:read scope. It responds and returns false
+// when the credentials don't do.
+func (cfg *apiConfig) checkChirpsReadScope(w http.ResponseWriter, req *http.Request) bool {
+	if req.Header.Get("Authorization") == "" {
+		return true
+	}
+	_, err := cfg.authenticateScoped(req, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return false
+	}
+	return true
+}
+
 func (cfg *apiConfig) handlerGetAllChirps(w http.ResponseWriter, req *http.Request) {
+	if !cfg.checkChirpsReadScope(w, req) {
+		return
+	}
 	response, err := cfg.dbQueries.GetAllChirps(req.Context())
 	if err != nil {
-		respondWithError(w, 500, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
@@ -152,14 +334,26 @@ func (cfg *apiConfig) handlerGetAllChirps(w http.ResponseWriter, req *http.Reque
 }
 
 func (cfg *apiConfig) handlerGetSpecificChirp(w http.ResponseWriter, req *http.Request) {
+	if !cfg.checkChirpsReadScope(w, req) {
+		return
+	}
 	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, 404, fmt.Sprintf("%s", err))
+		respondWithError(w, 404, "Chirp not found")
 		return
 	}
 	response, err := cfg.dbQueries.GetSpecificChirp(req.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
 	if err != nil {
-		respondWithError(w, 404, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
+		return
+	}
+	// Chirps hidden by a moderator stay around for their author to delete.
+	if response.HiddenAt.Valid {
+		respondWithError(w, 404, "Chirp not found")
 		return
 	}
 
@@ -183,6 +377,13 @@ func handlerHealthz(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// handlerJWKS publishes the public signing keys so other services can verify
+// access tokens without sharing a secret with chirpy.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.jwt.Keys.JWKS())
+}
+
 func (cfg *apiConfig) handlerCounter(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	content := fmt.Sprintf(`
@@ -201,15 +402,21 @@ func (cfg *apiConfig) handlerCounter(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// handlerReset wipes the database. Beyond the permission, it stays limited
+// to dev platforms, so no token can wipe production.
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, req *http.Request) {
 	if cfg.platform != "dev" {
 		respondWithError(w, 403, "This only works on dev platforms")
+		return
 	}
 	cfg.fileserverHits.Store(0)
 	err := cfg.dbQueries.ResetDB(req.Context())
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
+		return
 	}
+	// The reset empties the audit log too, so it starts with who did it.
+	cfg.audit(req.Context(), req, auditDatabaseReset, actorID(req), uuid.Nil, map[string]any{})
 }
 
 func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, req *http.Request) {
@@ -218,22 +425,25 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, req *http.Request
 		Email    string `json:"email"`
 	}
 
-	decoder := json.NewDecoder(req.Body)
 	post := emailPost{}
-	err := decoder.Decode(&post)
-	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+	if !decodeJSON(w, req, &post) {
 		return
 	}
 
-	//Just in case, for passing a teaching test
-	if len(post.Password) == 0 {
-		post.Password = "omgsecure"
+	errs := fieldErrors{}
+	checkEmail(&errs, post.Email)
+	err := cfg.checkPassword(&errs, post.Password, post.Email)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !errs.check(w) {
+		return
 	}
 
-	hashedPassword, err := auth.HashPassword(post.Password)
+	hashedPassword, err := auth.HashPassword(post.Password, cfg.argon2)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
@@ -242,215 +452,507 @@ func (cfg *apiConfig) handlerCreateUser(w http.ResponseWriter, req *http.Request
 		HashedPassword: hashedPassword,
 	}
 	response, err := cfg.dbQueries.CreateUser(req.Context(), newUser)
-	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+	if isUniqueViolation(err) {
+		respondWithCode(w, 409, "email_taken", "Email address is already in use")
 		return
 	}
-	jsonResponse := User{
-		ID:        response.ID,
-		CreatedAt: response.CreatedAt,
-		UpdatedAt: response.UpdatedAt,
-		Email:     response.Email,
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
 	}
+
+	go cfg.sendEmailVerification(response.ID, response.Email)
+
+	jsonResponse := newUserResponse(response)
 	respondWithJSON(w, 201, jsonResponse)
 }
 
 func (cfg *apiConfig) handlerLoginUser(w http.ResponseWriter, req *http.Request) {
 	type userLogin struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Password   string `json:"password"`
+		Email      string `json:"email"`
+		DeviceName string `json:"device_name"`
 	}
 
-	decoder := json.NewDecoder(req.Body)
 	user := userLogin{}
-	err := decoder.Decode(&user)
-	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+	if !decodeJSON(w, req, &user) {
+		return
+	}
+	errs := fieldErrors{}
+	if user.Email == "" {
+		errs.add("email", "Email address is required")
+	}
+	if user.Password == "" {
+		errs.add("password", "Password is required")
+	}
+	if !errs.check(w) {
+		return
+	}
+
+	if !cfg.checkLoginLockout(w, req, user.Email) {
 		return
 	}
 
 	dbUser, err := cfg.dbQueries.GetUserByEmail(req.Context(), user.Email)
 	if err != nil {
+		auth.CheckDummyPassword(user.Password, cfg.argon2)
+		cfg.recordLoginFailure(req, user.Email, uuid.Nil)
 		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
 	err = auth.CheckPasswordHash(user.Password, dbUser.HashedPassword)
 	if err != nil {
+		cfg.recordLoginFailure(req, user.Email, dbUser.ID)
 		respondWithError(w, 401, "Incorrect email or password")
 		return
 	}
-
-	token, err := auth.MakeJWT(dbUser.ID, cfg.tokenSecret, time.Duration(time.Hour))
-	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+	cfg.clearLoginFailures(req, user.Email)
+	cfg.rehashPassword(req.Context(), dbUser, user.Password)
+	if !checkNotSuspended(w, dbUser) {
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	// With two-factor authentication on, or any passkey registered, the
+	// password alone only earns a challenge token that POST /api/login/mfa,
+	// or the passkey MFA endpoints, exchange for real tokens.
+	mfa, err := cfg.requiresMFA(req.Context(), dbUser)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
+		return
+	}
+	if mfa {
+		cfg.respondWithMFAChallenge(w, dbUser)
 		return
 	}
 
-	newRefreshToken := database.CreateRefreshTokenParams{
-		Token:  refreshToken,
-		UserID: dbUser.ID,
+	cfg.startSession(w, req, dbUser, user.DeviceName, "password")
+}
+
+// checkNotSuspended responds with a 403 and returns false when the account
+// is suspended.
+func checkNotSuspended(w http.ResponseWriter, dbUser database.User) bool {
+	if dbUser.SuspendedAt.Valid {
+		respondWithCode(w, 403, "account_suspended", "This account is suspended")
+		return false
 	}
+	return true
+}
 
-	freshToken, err := cfg.dbQueries.CreateRefreshToken(req.Context(), newRefreshToken)
+// rehashPassword upgrades a password hash made with bcrypt or outdated
+// Argon2id parameters, which can only happen while the plain password is at
+// hand. The update only applies if the hash hasn't changed since it was read,
+// so it can't undo a password change that raced the login. Failing to rehash
+// doesn't fail the login.
+func (cfg *apiConfig) rehashPassword(ctx context.Context, dbUser database.User, password string) {
+	if !auth.NeedsRehash(dbUser.HashedPassword, cfg.argon2) {
+		return
+	}
+	hash, err := auth.HashPassword(password, cfg.argon2)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		log.Printf("Error rehashing password: %s", err)
 		return
 	}
+	err = cfg.dbQueries.RehashPassword(ctx, database.RehashPasswordParams{
+		ID:      dbUser.ID,
+		OldHash: dbUser.HashedPassword,
+		NewHash: hash,
+	})
+	if err != nil {
+		log.Printf("Error rehashing password: %s", err)
+	}
+}
 
-	resp := User{
-		ID:           dbUser.ID,
-		CreatedAt:    dbUser.CreatedAt,
-		UpdatedAt:    dbUser.UpdatedAt,
-		Email:        dbUser.Email,
-		Token:        token,
-		RefreshToken: freshToken,
+// startSession logs the user in on a new device: it mints an access token,
+// opens a new refresh token family and responds with both. Every way of
+// logging in ends here, so this is where suspended users are turned away,
+// pending account deletions are cancelled and logins are audited, with
+// method naming the step that completed it.
+func (cfg *apiConfig) startSession(w http.ResponseWriter, req *http.Request, dbUser database.User, deviceName, method string) {
+	if !checkNotSuspended(w, dbUser) {
+		return
+	}
+	resp, sessionID, err := cfg.createSession(req, dbUser, deviceName)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
 	}
+	cfg.cancelAccountDeletion(req, dbUser)
+	cfg.audit(req.Context(), req, auditLoginSucceeded, dbUser.ID, dbUser.ID, map[string]any{
+		"method":      method,
+		"session_id":  sessionID,
+		"device_name": deviceName,
+	})
 	respondWithJSON(w, 200, resp)
 }
 
+// createSession mints an access token and opens a new refresh token family
+// for the user, returning them in a user response along with the session
+// ID.
+func (cfg *apiConfig) createSession(req *http.Request, dbUser database.User, deviceName string) (User, uuid.UUID, error) {
+	token, err := auth.MakeAccessToken(dbUser.ID, dbUser.TokenVersion, dbUser.Role, cfg.jwt, cfg.accessTokenTTL)
+	if err != nil {
+		return User{}, uuid.Nil, err
+	}
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return User{}, uuid.Nil, err
+	}
+
+	newRefreshToken := database.CreateRefreshTokenParams{
+		TokenHash:  auth.HashToken(refreshToken),
+		UserID:     dbUser.ID,
+		FamilyID:   uuid.New(),
+		ExpiresAt:  time.Now().UTC().Add(cfg.refreshTokenTTL),
+		UserAgent:  req.UserAgent(),
+		IpAddress:  clientIP(req),
+		DeviceName: deviceName,
+	}
+	err = cfg.dbQueries.CreateRefreshToken(req.Context(), newRefreshToken)
+	if err != nil {
+		return User{}, uuid.Nil, err
+	}
+
+	resp := newUserResponse(dbUser)
+	resp.Token = token
+	resp.RefreshToken = refreshToken
+	return resp, newRefreshToken.FamilyID, nil
+}
+
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, req *http.Request) {
 	refreshToken, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithAuthError(w, err)
+		return
+	}
+
+	newToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// Rotation only succeeds for a token that is neither revoked nor expired.
+	oldToken, err := qtx.RotateRefreshToken(req.Context(), database.RotateRefreshTokenParams{
+		TokenHash:      auth.HashToken(refreshToken),
+		ReplacedByHash: sql.NullString{String: auth.HashToken(newToken), Valid: true},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		tx.Rollback()
+		cfg.rejectRefreshToken(w, req, refreshToken)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = qtx.CreateRefreshToken(req.Context(), database.CreateRefreshTokenParams{
+		TokenHash:  auth.HashToken(newToken),
+		UserID:     oldToken.UserID,
+		FamilyID:   oldToken.FamilyID,
+		ExpiresAt:  time.Now().UTC().Add(cfg.refreshTokenTTL),
+		UserAgent:  req.UserAgent(),
+		IpAddress:  clientIP(req),
+		DeviceName: oldToken.DeviceName,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
 		return
 	}
 
-	userID, err := cfg.dbQueries.GetUserFromRefreshToken(req.Context(), refreshToken)
+	err = tx.Commit()
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), oldToken.UserID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !checkNotSuspended(w, dbUser) {
 		return
 	}
 
-	resp, err := auth.MakeJWT(userID, cfg.tokenSecret, time.Hour)
+	accessToken, err := auth.MakeAccessToken(dbUser.ID, dbUser.TokenVersion, dbUser.Role, cfg.jwt, cfg.accessTokenTTL)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
+	cfg.audit(req.Context(), req, auditTokenRefreshed, dbUser.ID, dbUser.ID, map[string]any{
+		"session_id": oldToken.FamilyID,
+	})
 	response := User{
-		Token: resp,
+		Token:        accessToken,
+		RefreshToken: newToken,
 	}
 
 	respondWithJSON(w, 200, response)
 }
 
+// rejectRefreshToken answers a refresh request whose token could not be
+// rotated. A revoked token coming back means someone kept a copy of it after
+// it was rotated, so nothing issued from the same login can be trusted.
+func (cfg *apiConfig) rejectRefreshToken(w http.ResponseWriter, req *http.Request, refreshToken string) {
+	token, err := cfg.dbQueries.GetRefreshTokenByHash(req.Context(), auth.HashToken(refreshToken))
+	if err != nil || !token.RevokedAt.Valid {
+		respondWithError(w, 401, "Invalid or expired refresh token")
+		return
+	}
+
+	err = cfg.dbQueries.RevokeRefreshTokenFamily(req.Context(), token.FamilyID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	log.Printf("Refresh token reuse detected, revoked token family %s", token.FamilyID)
+	cfg.audit(req.Context(), req, auditTokenReused, uuid.Nil, token.UserID, map[string]any{
+		"session_id": token.FamilyID,
+	})
+	respondWithError(w, 401, "Refresh token has already been used")
+}
+
 func (cfg *apiConfig) handlerRevoke(w http.ResponseWriter, req *http.Request) {
 	refreshToken, err := auth.GetBearerToken(req.Header)
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithAuthError(w, err)
 		return
 	}
 
-	err = cfg.dbQueries.RevokeRefreshToken(req.Context(), refreshToken)
+	// Revoking a token that is unknown or already revoked is a no-op.
+	revoked, err := cfg.dbQueries.RevokeRefreshToken(req.Context(), auth.HashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(204)
+		return
+	}
 	if err != nil {
-		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
+	cfg.audit(req.Context(), req, auditTokenRevoked, revoked.UserID, revoked.UserID, map[string]any{
+		"session_id": revoked.FamilyID,
+	})
 
 	w.WriteHeader(204)
 }
 
+// putUserDeprecatedAt is when PUT /api/users was deprecated, as the
+// Deprecation header (RFC 9745) gives it.
+const putUserDeprecatedAt = "@1792368000"
+
+// handlerPutUser keeps PUT /api/users, which PATCH /api/users/me replaced,
+// answering for clients that haven't moved yet. It takes the same body as
+// PATCH, and its headers say it's deprecated and where to go instead.
+func (cfg *apiConfig) handlerPutUser(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Deprecation", putUserDeprecatedAt)
+	w.Header().Set("Link", `</api/users/me>; rel="successor-version"`)
+	cfg.handlerUpdateUser(w, req)
+}
+
+// handlerUpdateUser changes any of the user's email address and password.
+// Fields that are left out stay as they are, and either change needs the
+// current password, or a reauth token from users who don't have one. A new
+// address only replaces the old one once it has been verified. A new
+// password logs the user out everywhere else: the response carries a fresh
+// session for the client that made the change.
 func (cfg *apiConfig) handlerUpdateUser(w http.ResponseWriter, req *http.Request) {
-	token, err := auth.GetBearerToken(req.Header)
+	type userPatch struct {
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+		ReauthToken     string  `json:"reauth_token"`
+		DeviceName      string  `json:"device_name"`
+	}
+
+	userID, err := cfg.authenticate(req)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithAuthError(w, err)
+		return
+	}
+
+	patch := userPatch{}
+	if !decodeJSON(w, req, &patch) {
 		return
 	}
 
-	userID, err := auth.ValidateJWT(token, cfg.tokenSecret)
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
+		return
+	}
+	emailChanging := patch.Email != nil && *patch.Email != user.Email
+	passwordChanging := patch.Password != nil && *patch.Password != patch.CurrentPassword
+	if !emailChanging && !passwordChanging {
+		respondWithJSON(w, 200, newUserResponse(user))
 		return
 	}
 
-	type passWordChange struct {
-		Password string `json:"password"`
-		Email    string `json:"email"`
+	errs := fieldErrors{}
+	if emailChanging {
+		checkEmail(&errs, *patch.Email)
+	}
+	if passwordChanging {
+		userInputs := []string{user.Email}
+		if emailChanging {
+			userInputs = append(userInputs, *patch.Email)
+		}
+		err = cfg.checkPassword(&errs, *patch.Password, userInputs...)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+	}
+	if !errs.check(w) {
+		return
 	}
 
-	decoder := json.NewDecoder(req.Body)
-	newPass := passWordChange{}
-	err = decoder.Decode(&newPass)
-	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+	// Users who signed up with an identity provider have no password, so
+	// they confirm it's them with a reauth token instead.
+	if !cfg.checkReauth(w, req, user, patch.CurrentPassword, patch.ReauthToken) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(newPass.Password)
+	var hashedPassword string
+	if passwordChanging {
+		hashedPassword, err = auth.HashPassword(*patch.Password, cfg.argon2)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+	}
+
+	// Both changes are made in one transaction, so a taken address leaves
+	// the password unchanged too.
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
 
-	passChange := database.UpdateUserParams{
-		Email:          newPass.Email,
-		HashedPassword: hashedPassword,
-		ID:             userID,
+	if emailChanging {
+		err = requestEmailChange(req.Context(), qtx, userID, *patch.Email)
+		if errors.Is(err, errEmailTaken) {
+			respondWithCode(w, 409, "email_taken", "Email address is already in use")
+			return
+		}
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+	}
+	if passwordChanging {
+		err = changePassword(req.Context(), qtx, userID, hashedPassword)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
 	}
 
-	err = cfg.dbQueries.UpdateUser(req.Context(), passChange)
+	err = tx.Commit()
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
-	newData, err := cfg.dbQueries.GetUserByEmail(req.Context(), newPass.Email)
+	if emailChanging {
+		go cfg.sendEmailVerification(userID, *patch.Email)
+		cfg.audit(req.Context(), req, auditEmailChangeStarted, userID, userID, map[string]any{
+			"from": user.Email,
+			"to":   *patch.Email,
+		})
+	}
+
+	user, err = cfg.dbQueries.GetUserByID(req.Context(), userID)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
-
-	resp := User{
-		Email:     newData.Email,
-		ID:        newData.ID,
-		CreatedAt: newData.CreatedAt,
-		UpdatedAt: newData.UpdatedAt,
+	if !passwordChanging {
+		respondWithJSON(w, 200, newUserResponse(user))
+		return
 	}
 
+	resp, sessionID, err := cfg.createSession(req, user, patch.DeviceName)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	cfg.audit(req.Context(), req, auditPasswordChanged, userID, userID, map[string]any{
+		"session_id": sessionID,
+	})
 	respondWithJSON(w, 200, resp)
 }
 
+// changePassword sets a new password hash and ends every session, along with
+// the access tokens still outstanding.
+func changePassword(ctx context.Context, qtx *database.Queries, userID uuid.UUID, hashedPassword string) error {
+	err := qtx.UpdatePassword(ctx, database.UpdatePasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             userID,
+	})
+	if err != nil {
+		return err
+	}
+	err = qtx.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = qtx.IncrementTokenVersion(ctx, userID)
+	return err
+}
+
 func (cfg *apiConfig) handlerDeleteChirp(w http.ResponseWriter, req *http.Request) {
 	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
 	if err != nil {
-		w.WriteHeader(404)
+		respondWithError(w, 404, "Chirp not found")
 		return
 	}
 
-	token, err := auth.GetBearerToken(req.Header)
+	userID, err := cfg.authenticateScoped(req, auth.ScopeChirpsWrite)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithAuthError(w, err)
 		return
 	}
-
-	userID, err := auth.ValidateJWT(token, cfg.tokenSecret)
-	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+	if !cfg.checkEmailVerified(w, req, userID, restrictDeleteChirps) {
 		return
 	}
 
 	response, err := cfg.dbQueries.GetSpecificChirp(req.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
 	if err != nil {
-		respondWithError(w, 404, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
 
 	if userID != response.UserID {
-		w.WriteHeader(403)
+		respondWithError(w, 403, "You can only delete your own chirps")
 		return
 	}
 
 	err = cfg.dbQueries.DeleteChirp(req.Context(), response.ID)
 	if err != nil {
-		respondWithError(w, 401, fmt.Sprintf("%s", err))
+		respondWithInternalError(w, err)
 		return
 	}
+	cfg.audit(req.Context(), req, auditChirpDeleted, userID, userID, map[string]any{
+		"chirp_id": response.ID,
+		"body":     response.Body,
+	})
 
 	w.WriteHeader(204)
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+)
+
+// handlerDeleteAccount schedules the user's account for deletion once the
+// grace period is over. The password, or a reauth token for users who
+// don't have one, is asked for again, so a stolen access token can't do
+// it. The user is logged out everywhere, and logging back in before the
+// deletion is due cancels it.
+func (cfg *apiConfig) handlerDeleteAccount(w http.ResponseWriter, req *http.Request) {
+	type deletePost struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := deletePost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !cfg.checkReauth(w, req, dbUser, post.Password, post.ReauthToken) {
+		return
+	}
+
+	// An admin scheduled for deletion no longer counts as one, so the
+	// guard keeps the last admin from scheduling their own.
+	dueAt := time.Now().UTC().Add(cfg.accountDeletionGrace)
+	err = cfg.withAdminGuard(req.Context(), dbUser, func(qtx *database.Queries) error {
+		err := qtx.ScheduleAccountDeletion(req.Context(), database.ScheduleAccountDeletionParams{
+			ID:            userID,
+			DeletionDueAt: sql.NullTime{Time: dueAt, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+		return revokeCredentials(req.Context(), qtx, userID)
+	})
+	if errors.Is(err, errLastAdmin) {
+		respondWithError(w, 409, "The last admin can't delete their account")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	go cfg.sendDeletionScheduled(dbUser.Email, dueAt)
+
+	cfg.audit(req.Context(), req, auditDeletionScheduled, userID, userID, map[string]any{
+		"deletion_due_at": dueAt,
+	})
+	respondWithJSON(w, 202, map[string]time.Time{"deletion_due_at": dueAt})
+}
+
+// cancelAccountDeletion keeps the account of a user who logs in while it's
+// scheduled for deletion. Failing to cancel doesn't fail the login.
+func (cfg *apiConfig) cancelAccountDeletion(req *http.Request, dbUser database.User) {
+	if !dbUser.DeletionDueAt.Valid {
+		return
+	}
+	cancelled, err := cfg.dbQueries.CancelAccountDeletion(req.Context(), dbUser.ID)
+	if err != nil {
+		log.Printf("Error cancelling account deletion: %s", err)
+		return
+	}
+	if cancelled > 0 {
+		cfg.audit(req.Context(), req, auditDeletionCancelled, dbUser.ID, dbUser.ID, map[string]any{})
+	}
+}
+
+func (cfg *apiConfig) sendDeletionScheduled(email string, dueAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	err := cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf("Your Chirpy account and everything in it will be deleted on %s.\n\n"+
+			"If you change your mind, log in before then and the deletion will be cancelled.\n",
+			dueAt.Format("2 January 2006 at 15:04 MST")),
+	})
+	if err != nil {
+		log.Printf("Error sending account deletion mail: %s", err)
+	}
+}
+
+// purgeAccounts deletes the accounts whose grace period is over, every
+// interval until ctx is done. Everything the accounts own goes with them
+// through the database's cascades; the audit log keeps its records.
+func (cfg *apiConfig) purgeAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := cfg.dbQueries.PurgeDueAccounts(ctx)
+		if err != nil {
+			log.Printf("Error purging deleted accounts: %s", err)
+		}
+		for _, user := range purged {
+			_, err = cfg.dbQueries.ClearLoginFailures(ctx, accountSubject(user.Email))
+			if err != nil {
+				log.Printf("Error clearing failed logins: %s", err)
+			}
+			cfg.audit(ctx, nil, auditUserDeleted, user.ID, user.ID, map[string]any{
+				"email":  user.Email,
+				"reason": "self_service",
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+var errLastAdmin = errors.New("the last admin can't be removed")
+
+type adminUserResponse struct {
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Email            string     `json:"email"`
+	EmailVerified    bool       `json:"email_verified"`
+	Role             string     `json:"role"`
+	MFAEnabled       bool       `json:"mfa_enabled"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+	SuspensionReason string     `json:"suspension_reason,omitempty"`
+	DeletionDueAt    *time.Time `json:"deletion_due_at,omitempty"`
+}
+
+func newAdminUserResponse(user database.User) adminUserResponse {
+	return adminUserResponse{
+		ID:               user.ID,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerifiedAt.Valid,
+		Role:             user.Role,
+		MFAEnabled:       user.TotpEnabledAt.Valid,
+		SuspendedAt:      nullTime(user.SuspendedAt),
+		SuspensionReason: user.SuspensionReason,
+		DeletionDueAt:    nullTime(user.DeletionDueAt),
+	}
+}
+
+type adminUserDetailResponse struct {
+	adminUserResponse
+	Chirps   int64 `json:"chirps"`
+	Sessions int64 `json:"sessions"`
+	Passkeys int64 `json:"passkeys"`
+	APIKeys  int64 `json:"api_keys"`
+}
+
+// handlerListUsers lists users, newest first. The optional q, role and
+// suspended query parameters narrow the list down, where q matches part of
+// the email address; limit and offset page through it.
+func (cfg *apiConfig) handlerListUsers(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit, offset, ok := parsePage(w, query)
+	if !ok {
+		return
+	}
+	params := database.SearchUsersParams{MaxResults: limit, Skip: offset}
+
+	if q := query.Get("q"); q != "" {
+		params.Email = sql.NullString{String: "%" + likeEscaper.Replace(q) + "%", Valid: true}
+	}
+	if role := query.Get("role"); role != "" {
+		if !auth.ValidRole(role) {
+			respondWithError(w, 400, "Unknown role")
+			return
+		}
+		params.Role = sql.NullString{String: role, Valid: true}
+	}
+	if suspended := query.Get("suspended"); suspended != "" {
+		value, err := strconv.ParseBool(suspended)
+		if err != nil {
+			respondWithError(w, 400, "suspended must be true or false")
+			return
+		}
+		params.Suspended = sql.NullBool{Bool: value, Valid: true}
+	}
+
+	users, err := cfg.dbQueries.SearchUsers(req.Context(), params)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditUsersSearched, actorID(req), uuid.Nil, map[string]any{
+		"query": req.URL.RawQuery,
+	})
+	resp := []adminUserResponse{}
+	for _, user := range users {
+		resp = append(resp, newAdminUserResponse(user))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// parsePage reads the limit and offset query parameters of a list endpoint,
+// responding with a 400 and returning false if either is out of range.
+func parsePage(w http.ResponseWriter, query url.Values) (limit, offset int32, ok bool) {
+	limit = defaultPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxPageSize {
+			respondWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return 0, 0, false
+		}
+		limit = int32(n)
+	}
+	if value := query.Get("offset"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || n < 0 {
+			respondWithError(w, 400, "offset must be zero or more")
+			return 0, 0, false
+		}
+		offset = int32(n)
+	}
+	return limit, offset, true
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so a search for
+// "a_b" doesn't also match "axb".
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (cfg *apiConfig) handlerGetUser(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok {
+		return
+	}
+	activity, err := cfg.dbQueries.GetUserActivity(req.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditUserViewed, actorID(req), user.ID, map[string]any{
+		"view": "details",
+	})
+	respondWithJSON(w, 200, adminUserDetailResponse{
+		adminUserResponse: newAdminUserResponse(user),
+		Chirps:            activity.Chirps,
+		Sessions:          activity.Sessions,
+		Passkeys:          activity.Passkeys,
+		APIKeys:           activity.ApiKeys,
+	})
+}
+
+func (cfg *apiConfig) handlerListUserSessions(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok {
+		return
+	}
+	sessions, err := cfg.dbQueries.ListSessions(req.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditUserViewed, actorID(req), user.ID, map[string]any{
+		"view": "sessions",
+	})
+	resp := []sessionResponse{}
+	for _, session := range sessions {
+		resp = append(resp, newSessionResponse(session))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerSuspendUser locks a user out until they're unsuspended. Every
+// credential they hold is revoked on the spot, and logins are refused while
+// the suspension lasts. Moderators can only suspend ordinary users.
+func (cfg *apiConfig) handlerSuspendUser(w http.ResponseWriter, req *http.Request) {
+	type suspendPost struct {
+		Reason string `json:"reason"`
+	}
+
+	post := suspendPost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	post.Reason = strings.TrimSpace(post.Reason)
+	if post.Reason == "" {
+		respondWithError(w, 400, "Give a reason for the suspension")
+		return
+	}
+
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok || !checkCanModerate(w, req, user) {
+		return
+	}
+	if user.ID == actorID(req) {
+		respondWithError(w, 400, "You can't suspend your own account")
+		return
+	}
+
+	err := cfg.withAdminGuard(req.Context(), user, func(qtx *database.Queries) error {
+		return suspendUser(req.Context(), qtx, user.ID, post.Reason)
+	})
+	if errors.Is(err, errLastAdmin) {
+		respondWithError(w, 409, "The last admin can't be suspended")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditUserSuspended, actorID(req), user.ID, map[string]any{
+		"reason": post.Reason,
+	})
+	w.WriteHeader(204)
+}
+
+// suspendUser marks the user as suspended and revokes every credential they
+// hold.
+func suspendUser(ctx context.Context, q *database.Queries, userID uuid.UUID, reason string) error {
+	_, err := q.SuspendUser(ctx, database.SuspendUserParams{
+		ID:               userID,
+		SuspensionReason: reason,
+	})
+	if err != nil {
+		return err
+	}
+	return revokeCredentials(ctx, q, userID)
+}
+
+func (cfg *apiConfig) handlerUnsuspendUser(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok || !checkCanModerate(w, req, user) {
+		return
+	}
+
+	unsuspended, err := cfg.dbQueries.UnsuspendUser(req.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if unsuspended == 0 {
+		respondWithError(w, 409, "User isn't suspended")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditUserReinstated, actorID(req), user.ID, map[string]any{
+		"reason": user.SuspensionReason,
+	})
+	w.WriteHeader(204)
+}
+
+// handlerForcePasswordReset throws away the user's password and signs them
+// out everywhere, then mails them a reset link. Until they choose a new
+// password, it can't be used to log in.
+func (cfg *apiConfig) handlerForcePasswordReset(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok {
+		return
+	}
+
+	err := cfg.withAdminGuard(req.Context(), database.User{}, func(qtx *database.Queries) error {
+		// No hash matches the empty string, the same as for users who only
+		// ever signed in with a provider.
+		err := qtx.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+			ID:             user.ID,
+			HashedPassword: "",
+		})
+		if err != nil {
+			return err
+		}
+		return revokeCredentials(req.Context(), qtx, user.ID)
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	go cfg.sendPasswordReset(user.Email)
+
+	cfg.audit(req.Context(), req, auditPasswordForced, actorID(req), user.ID, map[string]any{})
+	w.WriteHeader(204)
+}
+
+// handlerSetUserRole changes a user's role. Their tokens carry the old role,
+// so they're revoked and the user has to log in again.
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, req *http.Request) {
+	type rolePut struct {
+		Role string `json:"role"`
+	}
+
+	post := rolePut{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if !auth.ValidRole(post.Role) {
+		respondWithError(w, 400, "Unknown role")
+		return
+	}
+
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok {
+		return
+	}
+	if user.Role == post.Role {
+		respondWithJSON(w, 200, newAdminUserResponse(user))
+		return
+	}
+
+	guarded := user
+	if post.Role == auth.RoleAdmin {
+		guarded = database.User{}
+	}
+	err := cfg.withAdminGuard(req.Context(), guarded, func(qtx *database.Queries) error {
+		err := qtx.SetUserRole(req.Context(), database.SetUserRoleParams{
+			ID:   user.ID,
+			Role: post.Role,
+		})
+		if err != nil {
+			return err
+		}
+		return revokeCredentials(req.Context(), qtx, user.ID)
+	})
+	if errors.Is(err, errLastAdmin) {
+		respondWithError(w, 409, "The last admin can't be demoted")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditRoleChanged, actorID(req), user.ID, map[string]any{
+		"from": user.Role,
+		"to":   post.Role,
+	})
+	user.Role = post.Role
+	respondWithJSON(w, 200, newAdminUserResponse(user))
+}
+
+// handlerDeleteUser deletes an account along with everything it owns.
+func (cfg *apiConfig) handlerDeleteUser(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.adminTargetUser(w, req)
+	if !ok {
+		return
+	}
+	if user.ID == actorID(req) {
+		respondWithError(w, 400, "You can't delete your own account from here")
+		return
+	}
+
+	err := cfg.withAdminGuard(req.Context(), user, func(qtx *database.Queries) error {
+		_, err := qtx.DeleteUser(req.Context(), user.ID)
+		return err
+	})
+	if errors.Is(err, errLastAdmin) {
+		respondWithError(w, 409, "The last admin can't be deleted")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	// The audit log has no foreign keys, so the record of the account
+	// outlives it.
+	cfg.audit(req.Context(), req, auditUserDeleted, actorID(req), user.ID, map[string]any{
+		"email": user.Email,
+		"role":  user.Role,
+	})
+	w.WriteHeader(204)
+}
+
+// adminTargetUser loads the user named in the path, responding with a 404
+// and returning false if there's no such user.
+func (cfg *apiConfig) adminTargetUser(w http.ResponseWriter, req *http.Request) (database.User, bool) {
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return database.User{}, false
+	}
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "User not found")
+		return database.User{}, false
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return database.User{}, false
+	}
+	return user, true
+}
+
+// checkCanModerate responds with a 403 and returns false when the user is
+// staff and the actor may only moderate ordinary users.
+func checkCanModerate(w http.ResponseWriter, req *http.Request, user database.User) bool {
+	if user.Role == auth.RoleUser || actorHasPermission(req, auth.PermissionManageUsers) {
+		return true
+	}
+	respondWithCode(w, 403, "permission_denied", "You don't have permission to do this")
+	return false
+}
+
+// withAdminGuard runs change in a transaction that only commits if an
+// active admin is left afterwards, should change take removed's admin
+// rights away. Pass a zero User when the change can't remove an admin.
+// Guarded changes take a lock first, so two admins removing each other at
+// once can't both succeed.
+func (cfg *apiConfig) withAdminGuard(ctx context.Context, removed database.User, change func(qtx *database.Queries) error) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	guarded := removed.Role == auth.RoleAdmin
+	if guarded {
+		err = qtx.LockAdmins(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	err = change(qtx)
+	if err != nil {
+		return err
+	}
+	if guarded {
+		admins, err := qtx.CountActiveAdmins(ctx)
+		if err != nil {
+			return err
+		}
+		if admins == 0 {
+			return errLastAdmin
+		}
+	}
+	return tx.Commit()
+}
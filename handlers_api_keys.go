@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+// apiKeyResponse describes a key without revealing it. Key is only set in the
+// response to creating it, since only its hash is stored.
+type apiKeyResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key database.ApiKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  nullTime(key.ExpiresAt),
+		LastUsedAt: nullTime(key.LastUsedAt),
+	}
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (cfg *apiConfig) handlerCreateAPIKey(w http.ResponseWriter, req *http.Request) {
+	type apiKeyPost struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := apiKeyPost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if post.Name == "" {
+		respondWithError(w, 400, "Name can't be empty")
+		return
+	}
+	if len(post.Scopes) == 0 {
+		respondWithError(w, 400, "A key needs at least one scope")
+		return
+	}
+	for _, scope := range post.Scopes {
+		if !auth.ValidScope(scope) {
+			respondWithError(w, 400, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+	expiresAt := sql.NullTime{}
+	if post.ExpiresAt != nil {
+		if !post.ExpiresAt.After(time.Now()) {
+			respondWithError(w, 400, "Expiry must be in the future")
+			return
+		}
+		expiresAt = sql.NullTime{Time: post.ExpiresAt.UTC(), Valid: true}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	dbKey, err := cfg.dbQueries.CreateAPIKey(req.Context(), database.CreateAPIKeyParams{
+		UserID:    userID,
+		Name:      post.Name,
+		Prefix:    prefix,
+		KeyHash:   auth.HashToken(key),
+		Scopes:    post.Scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditAPIKeyCreated, userID, userID, map[string]any{
+		"api_key_id": dbKey.ID,
+		"name":       dbKey.Name,
+		"scopes":     dbKey.Scopes,
+	})
+	resp := newAPIKeyResponse(dbKey)
+	resp.Key = key
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) handlerListAPIKeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	keys, err := cfg.dbQueries.ListAPIKeys(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []apiKeyResponse{}
+	for _, key := range keys {
+		resp = append(resp, newAPIKeyResponse(key))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerRevokeAPIKey(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	keyID, err := uuid.Parse(req.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, 404, "API key not found")
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokeAPIKey(req.Context(), database.RevokeAPIKeyParams{
+		UserID: userID,
+		ID:     keyID,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "API key not found")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditAPIKeyRevoked, userID, userID, map[string]any{
+		"api_key_id": keyID,
+	})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+type auditEventResponse struct {
+	ID        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	EventType string          `json:"event_type"`
+	ActorID   *uuid.UUID      `json:"actor_id,omitempty"`
+	TargetID  *uuid.UUID      `json:"target_id,omitempty"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+func newAuditEventResponse(event database.AuditEvent) auditEventResponse {
+	return auditEventResponse{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		EventType: event.EventType,
+		ActorID:   nullUUID(event.ActorID),
+		TargetID:  nullUUID(event.TargetID),
+		IPAddress: event.IpAddress,
+		UserAgent: event.UserAgent,
+		Payload:   event.Payload,
+	}
+}
+
+// handlerListAuditEvents searches the audit log, newest first. It can be
+// narrowed down by event_type, a comma separated list, by actor_id and
+// target_id, and by a since and until time in RFC 3339 format.
+func (cfg *apiConfig) handlerListAuditEvents(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit, offset, ok := parsePage(w, query)
+	if !ok {
+		return
+	}
+	params := database.ListAuditEventsParams{MaxResults: limit, Skip: offset}
+
+	if eventTypes := query.Get("event_type"); eventTypes != "" {
+		params.EventTypes = strings.Split(eventTypes, ",")
+	}
+	var err error
+	params.ActorID, err = uuidParam(query, "actor_id")
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		return
+	}
+	params.TargetID, err = uuidParam(query, "target_id")
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		return
+	}
+	params.Since, err = timeParam(query, "since")
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		return
+	}
+	params.Until, err = timeParam(query, "until")
+	if err != nil {
+		respondWithError(w, 400, fmt.Sprintf("%s", err))
+		return
+	}
+
+	events, err := cfg.dbQueries.ListAuditEvents(req.Context(), params)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []auditEventResponse{}
+	for _, event := range events {
+		resp = append(resp, newAuditEventResponse(event))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerListSecurityEvents shows users what happened to their account,
+// newest first. Who did it is left out, as that may be a member of staff.
+func (cfg *apiConfig) handlerListSecurityEvents(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	limit, offset, ok := parsePage(w, req.URL.Query())
+	if !ok {
+		return
+	}
+
+	events, err := cfg.dbQueries.ListSecurityEvents(req.Context(), database.ListSecurityEventsParams{
+		TargetID:   uuid.NullUUID{UUID: userID, Valid: true},
+		EventTypes: securityEventTypes,
+		MaxResults: limit,
+		Skip:       offset,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []auditEventResponse{}
+	for _, event := range events {
+		e := newAuditEventResponse(event)
+		e.ActorID = nil
+		e.TargetID = nil
+		resp = append(resp, e)
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func uuidParam(query url.Values, name string) (uuid.NullUUID, error) {
+	value := query.Get(name)
+	if value == "" {
+		return uuid.NullUUID{}, nil
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.NullUUID{}, fmt.Errorf("%s must be a UUID", name)
+	}
+	return uuid.NullUUID{UUID: id, Valid: true}, nil
+}
+
+// timeParam reads an RFC 3339 time. Audit events are stored in UTC, so it's
+// converted to match.
+func timeParam(query url.Values, name string) (sql.NullTime, error) {
+	value := query.Get(name)
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("%s must be an RFC 3339 time", name)
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
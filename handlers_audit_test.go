@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestUUIDParam(t *testing.T) {
+	id := uuid.New()
+	cases := []struct {
+		value   string
+		want    uuid.NullUUID
+		wantErr bool
+	}{
+		{"", uuid.NullUUID{}, false},
+		{id.String(), uuid.NullUUID{UUID: id, Valid: true}, false},
+		{"not-a-uuid", uuid.NullUUID{}, true},
+		{id.String() + "0", uuid.NullUUID{}, true},
+	}
+	for _, c := range cases {
+		got, err := uuidParam(url.Values{"actor_id": {c.value}}, "actor_id")
+		if c.wantErr {
+			if err == nil || err.Error() != "actor_id must be a UUID" {
+				t.Errorf("uuidParam(%q): Got %v, expected an error", c.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error generated for %q: Got %v, expected nil", c.value, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("uuidParam(%q): Got %v, expected %v", c.value, got, c.want)
+		}
+	}
+}
+
+func TestTimeParam(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"2026-10-19T12:00:00Z", time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), false},
+		{"2026-10-19T14:00:00+02:00", time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), false},
+		{"2026-10-19", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, c := range cases {
+		got, err := timeParam(url.Values{"since": {c.value}}, "since")
+		if c.wantErr {
+			if err == nil || err.Error() != "since must be an RFC 3339 time" {
+				t.Errorf("timeParam(%q): Got %v, expected an error", c.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error generated for %q: Got %v, expected nil", c.value, err)
+			continue
+		}
+		if got.Valid != (c.value != "") || !got.Time.Equal(c.want) || got.Time.Location() != time.UTC {
+			t.Errorf("timeParam(%q): Got %v, expected %v in UTC", c.value, got, c.want)
+		}
+	}
+}
+
+// TestSecurityEventTypes keeps staff work out of what users see about
+// their own account.
+func TestSecurityEventTypes(t *testing.T) {
+	staffOnly := []string{
+		auditTokenRefreshed,
+		auditLockoutCleared,
+		auditChirpDeleted,
+		auditUsersSearched,
+		auditUserViewed,
+		auditDatabaseReset,
+		auditReportClaimed,
+		auditReportResolved,
+	}
+	for _, eventType := range staffOnly {
+		if slices.Contains(securityEventTypes, eventType) {
+			t.Errorf("Showed %s to users", eventType)
+		}
+	}
+	seen := map[string]bool{}
+	for _, eventType := range securityEventTypes {
+		if seen[eventType] {
+			t.Errorf("Listed %s twice", eventType)
+		}
+		seen[eventType] = true
+	}
+}
+
+func TestListSecurityEvents(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	staff := uuid.New()
+	cfg.audit(t.Context(), nil, auditUserViewed, staff, user.ID, map[string]any{})
+	cfg.audit(t.Context(), nil, auditUserSuspended, staff, user.ID, map[string]any{"reason": "spam"})
+
+	rec := serve(cfg.handlerListSecurityEvents, "GET", "/api/users/me/security-events", createTestAccessToken(t, cfg, user), "")
+	if rec.Code != 200 {
+		t.Fatalf("Didn't list security events: Got %d %s", rec.Code, rec.Body)
+	}
+	events := []auditEventResponse{}
+	err := json.Unmarshal(rec.Body.Bytes(), &events)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if len(events) != 1 || events[0].EventType != auditUserSuspended {
+		t.Fatalf("Didn't filter to security events: Got %+v", events)
+	}
+	if events[0].ActorID != nil || events[0].TargetID != nil {
+		t.Errorf("Showed who did it: Got %+v", events[0])
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+)
+
+// Actions that UNVERIFIED_RESTRICTIONS can withhold from accounts whose email
+// address hasn't been verified yet.
+const (
+	restrictPostChirps   = "post_chirps"
+	restrictDeleteChirps = "delete_chirps"
+)
+
+var errEmailTaken = errors.New("email address is already in use")
+
+// isValidEmail accepts a bare address such as walt@example.com, without a
+// display name or angle brackets.
+func isValidEmail(email string) bool {
+	if len(email) > 254 {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	return strings.Contains(domain, ".")
+}
+
+// checkEmail adds an error for the email field when it isn't a valid
+// address.
+func checkEmail(errs *fieldErrors, email string) {
+	if email == "" {
+		errs.add("email", "Email address is required")
+		return
+	}
+	if !isValidEmail(email) {
+		errs.add("email", "Email address is invalid")
+	}
+}
+
+// parseRestrictions reads a comma separated list of restricted actions.
+func parseRestrictions(value string) (map[string]bool, error) {
+	restrictions := map[string]bool{}
+	for _, action := range strings.Split(value, ",") {
+		action = strings.TrimSpace(action)
+		switch action {
+		case "":
+		case restrictPostChirps, restrictDeleteChirps:
+			restrictions[action] = true
+		default:
+			return nil, fmt.Errorf("unknown restriction %q", action)
+		}
+	}
+	return restrictions, nil
+}
+
+// checkEmailVerified responds with a 403 and returns false when the action is
+// restricted to verified accounts and the user hasn't verified their address.
+func (cfg *apiConfig) checkEmailVerified(w http.ResponseWriter, req *http.Request, userID uuid.UUID, action string) bool {
+	if !cfg.unverifiedRestrictions[action] {
+		return true
+	}
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	if !user.EmailVerifiedAt.Valid {
+		respondWithCode(w, 403, "email_not_verified", "Verify your email address first")
+		return false
+	}
+	return true
+}
+
+// requestEmailChange parks a new address as pending, in the caller's
+// transaction. The caller mails the verification link with
+// sendEmailVerification once it's committed; the address in use doesn't
+// change until the link is opened.
+func requestEmailChange(ctx context.Context, qtx *database.Queries, userID uuid.UUID, email string) error {
+	other, err := qtx.GetUserByEmail(ctx, email)
+	if err == nil && other.ID != userID {
+		return errEmailTaken
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return qtx.SetPendingEmail(ctx, database.SetPendingEmailParams{
+		ID:           userID,
+		PendingEmail: sql.NullString{String: email, Valid: true},
+	})
+}
+
+func (cfg *apiConfig) sendEmailVerification(userID uuid.UUID, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error creating email verification token: %s", err)
+		return
+	}
+	err = cfg.dbQueries.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().UTC().Add(cfg.emailVerificationTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving email verification token: %s", err)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address for Chirpy",
+		Body: fmt.Sprintf("To confirm that this address belongs to your Chirpy account, open this link within %s:\n\n"+
+			"%s/verify-email?token=%s\n\n"+
+			"If you didn't sign up for Chirpy, you can ignore this email.\n",
+			cfg.emailVerificationTTL, cfg.baseURL, token),
+	})
+	if err != nil {
+		log.Printf("Error sending email verification mail: %s", err)
+	}
+}
+
+// handlerConfirmEmail verifies the address a token was mailed to. That is
+// either the account's current address or a pending new one, which then
+// replaces it.
+func (cfg *apiConfig) handlerConfirmEmail(w http.ResponseWriter, req *http.Request) {
+	type verifyConfirm struct {
+		Token string `json:"token"`
+	}
+
+	post := verifyConfirm{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	token, err := qtx.UseEmailVerificationToken(req.Context(), auth.HashToken(post.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	verified, err := qtx.MarkEmailVerified(req.Context(), database.MarkEmailVerifiedParams{
+		ID:    token.UserID,
+		Email: token.Email,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if verified == 0 {
+		verified, err = qtx.ConfirmPendingEmail(req.Context(), database.ConfirmPendingEmailParams{
+			ID:           token.UserID,
+			PendingEmail: sql.NullString{String: token.Email, Valid: true},
+		})
+		if isUniqueViolation(err) {
+			respondWithCode(w, 409, "email_taken", "Email address is already in use")
+			return
+		}
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+	}
+	// The account has moved on to another address since the link was sent.
+	if verified == 0 {
+		respondWithError(w, 400, "Invalid or expired verification token")
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditEmailVerified, token.UserID, token.UserID, map[string]any{
+		"email": token.Email,
+	})
+	w.WriteHeader(204)
+}
+
+// handlerResendEmailVerification mails a fresh link for the pending address,
+// or for the current one if it hasn't been verified yet.
+func (cfg *apiConfig) handlerResendEmailVerification(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	switch {
+	case user.PendingEmail.Valid:
+		go cfg.sendEmailVerification(user.ID, user.PendingEmail.String)
+	case !user.EmailVerifiedAt.Valid:
+		go cfg.sendEmailVerification(user.ID, user.Email)
+	default:
+		respondWithError(w, 400, "Email address is already verified")
+		return
+	}
+
+	w.WriteHeader(202)
+}
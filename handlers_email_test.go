@@ -0,0 +1,91 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestIsValidEmail(t *testing.T) {
+	cases := []struct {
+		email string
+		want  bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@mail.example.co.uk", true},
+		{"", false},
+		{"user", false},
+		{"user@", false},
+		{"@example.com", false},
+		{"user@localhost", false},
+		{"user@@example.com", false},
+		{"User <user@example.com>", false},
+		{" user@example.com", false},
+		{"user@example.com ", false},
+		{"a@b.c, d@e.f", false},
+		{strings.Repeat("a", 64) + "@" + strings.Repeat("b", 185) + ".com", true},
+		{strings.Repeat("a", 64) + "@" + strings.Repeat("b", 186) + ".com", false},
+	}
+	for _, c := range cases {
+		got := isValidEmail(c.email)
+		if got != c.want {
+			t.Errorf("isValidEmail(%q): Got %v, expected %v", c.email, got, c.want)
+		}
+	}
+}
+
+func TestCheckEmail(t *testing.T) {
+	cases := []struct {
+		email   string
+		message string
+	}{
+		{"user@example.com", ""},
+		{"", "Email address is required"},
+		{"user", "Email address is invalid"},
+	}
+	for _, c := range cases {
+		errs := fieldErrors{}
+		checkEmail(&errs, c.email)
+		if c.message == "" {
+			if len(errs) != 0 {
+				t.Errorf("checkEmail(%q): Got %+v, expected no errors", c.email, errs)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Field != "email" || errs[0].Message != c.message {
+			t.Errorf("checkEmail(%q): Got %+v, expected %q on email", c.email, errs, c.message)
+		}
+	}
+}
+
+func TestParseRestrictions(t *testing.T) {
+	cases := []struct {
+		value   string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{"", map[string]bool{}, false},
+		{" , ", map[string]bool{}, false},
+		{"post_chirps", map[string]bool{restrictPostChirps: true}, false},
+		{"post_chirps, delete_chirps", map[string]bool{restrictPostChirps: true, restrictDeleteChirps: true}, false},
+		{"delete_chirps,delete_chirps,", map[string]bool{restrictDeleteChirps: true}, false},
+		{"post_chirps,tweet", nil, true},
+		{"Post_Chirps", nil, true},
+	}
+	for _, c := range cases {
+		got, err := parseRestrictions(c.value)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseRestrictions(%q): Got %v, expected an error", c.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Error generated for %q: Got %v, expected nil", c.value, err)
+			continue
+		}
+		if !maps.Equal(got, c.want) {
+			t.Errorf("parseRestrictions(%q): Got %v, expected %v", c.value, got, c.want)
+		}
+	}
+}
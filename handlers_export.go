@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+	"github.com/wjseele/chirpy/internal/takeout"
+)
+
+// exportStaleAfter is how long a running export may take before another
+// worker assumes it died and starts it over.
+const exportStaleAfter = 15 * time.Minute
+
+type exportResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	Error       string     `json:"error,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+func newExportResponse(job database.GetExportJobRow) exportResponse {
+	return exportResponse{
+		ID:          job.ID,
+		Status:      job.Status,
+		CreatedAt:   job.CreatedAt,
+		CompletedAt: nullTime(job.CompletedAt),
+		ExpiresAt:   nullTime(job.ExpiresAt),
+		Error:       job.Error,
+	}
+}
+
+// profileExport is the account as it appears in an export.
+type profileExport struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"`
+	Role          string    `json:"role"`
+	MFAEnabled    bool      `json:"mfa_enabled"`
+}
+
+// handlerRequestExport queues an export of everything the user has stored
+// with us. While one is being built, asking again returns that one.
+func (cfg *apiConfig) handlerRequestExport(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	active, err := cfg.dbQueries.GetActiveExportJob(req.Context(), userID)
+	if err == nil {
+		w.Header().Set("Location", "/api/users/me/export/"+active.ID.String())
+		respondWithJSON(w, 202, newExportResponse(database.GetExportJobRow(active)))
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	job, err := cfg.dbQueries.CreateExportJob(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	// The worker also polls, so a wake-up that's dropped only delays it.
+	select {
+	case cfg.exportWake <- struct{}{}:
+	default:
+	}
+
+	cfg.audit(req.Context(), req, auditExportRequested, userID, userID, map[string]any{
+		"export_id": job.ID,
+	})
+	w.Header().Set("Location", "/api/users/me/export/"+job.ID.String())
+	respondWithJSON(w, 202, newExportResponse(database.GetExportJobRow(job)))
+}
+
+// handlerGetExport reports how an export is getting on. Once it's ready,
+// the response carries a signed link to download it, which works without
+// logging in until it expires.
+func (cfg *apiConfig) handlerGetExport(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	exportID, err := uuid.Parse(req.PathValue("exportID"))
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+
+	job, err := cfg.dbQueries.GetExportJob(req.Context(), database.GetExportJobParams{
+		ID:     exportID,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := newExportResponse(job)
+	if job.Status == "ready" && time.Now().Before(job.ExpiresAt.Time) {
+		expires := time.Now().Add(cfg.exportLinkTTL)
+		if job.ExpiresAt.Time.Before(expires) {
+			expires = job.ExpiresAt.Time
+		}
+		link := takeout.SignLink(cfg.exportLinkSecret, job.ID.String(), expires)
+		resp.DownloadURL = fmt.Sprintf("%s/api/exports/%s/download?%s", cfg.baseURL, job.ID, link.Encode())
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerDownloadExport(w http.ResponseWriter, req *http.Request) {
+	exportID := req.PathValue("exportID")
+	err := takeout.VerifyLink(cfg.exportLinkSecret, exportID, req.URL.Query(), time.Now())
+	if errors.Is(err, takeout.ErrLinkExpired) {
+		respondWithError(w, 410, "Download link has expired, get a new one")
+		return
+	}
+	if err != nil {
+		respondWithError(w, 403, "Download link is invalid")
+		return
+	}
+
+	id, err := uuid.Parse(exportID)
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return
+	}
+	export, err := cfg.dbQueries.GetExportArchive(req.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Export not found or expired")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditExportDownloaded, uuid.Nil, export.UserID, map[string]any{
+		"export_id": id,
+	})
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(200)
+	w.Write(export.Archive)
+}
+
+// runExports builds queued exports until ctx is done. It works through the
+// queue whenever it's woken up by a new request, and every interval in case
+// a request was made to another instance. Expired archives are deleted on
+// the way.
+func (cfg *apiConfig) runExports(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.dbQueries.DeleteExpiredExports(ctx)
+		if err != nil {
+			log.Printf("Error deleting expired exports: %s", err)
+		}
+		for {
+			job, err := cfg.dbQueries.ClaimExportJob(ctx, sql.NullTime{Time: time.Now().UTC().Add(-exportStaleAfter), Valid: true})
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				log.Printf("Error claiming export: %s", err)
+				break
+			}
+			cfg.runExport(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.exportWake:
+		}
+	}
+}
+
+// runExport builds a claimed export. If the job was claimed again in the
+// meantime, because this worker was slow enough to look dead, the result is
+// dropped and left to the worker that took over.
+func (cfg *apiConfig) runExport(ctx context.Context, job database.ClaimExportJobRow) {
+	user, archive, err := cfg.buildExport(ctx, job.UserID)
+	if err != nil {
+		log.Printf("Error building export %s: %s", job.ID, err)
+		_, err = cfg.dbQueries.FailExportJob(ctx, database.FailExportJobParams{
+			ID:      job.ID,
+			ClaimID: job.ClaimID,
+			Error:   "The export couldn't be built, please try again",
+		})
+		if err != nil {
+			log.Printf("Error failing export %s: %s", job.ID, err)
+		}
+		return
+	}
+
+	saved, err := cfg.dbQueries.CompleteExportJob(ctx, database.CompleteExportJobParams{
+		ID:        job.ID,
+		ClaimID:   job.ClaimID,
+		ExpiresAt: sql.NullTime{Time: time.Now().UTC().Add(cfg.exportTTL), Valid: true},
+		Archive:   archive,
+	})
+	if err != nil {
+		log.Printf("Error saving export %s: %s", job.ID, err)
+		return
+	}
+	if saved == 0 {
+		log.Printf("Export %s was claimed by another worker, dropping it", job.ID)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy data export is ready",
+		Body: fmt.Sprintf("The copy of your Chirpy data you asked for is ready.\n\n"+
+			"Log in to download it within %s, after which it's deleted.\n", cfg.exportTTL),
+	})
+	if err != nil {
+		log.Printf("Error sending export mail: %s", err)
+	}
+}
+
+// buildExport zips up everything stored about the user: their profile,
+// chirps, sessions, passkeys, API keys and security events. Secrets such as
+// password hashes and keys are left out.
+func (cfg *apiConfig) buildExport(ctx context.Context, userID uuid.UUID) (database.User, []byte, error) {
+	user, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return user, nil, err
+	}
+	chirps, err := cfg.dbQueries.GetChirpsByUser(ctx, userID)
+	if err != nil {
+		return user, nil, err
+	}
+	sessions, err := cfg.dbQueries.ListSessions(ctx, userID)
+	if err != nil {
+		return user, nil, err
+	}
+	passkeys, err := cfg.dbQueries.ListPasskeys(ctx, userID)
+	if err != nil {
+		return user, nil, err
+	}
+	apiKeys, err := cfg.dbQueries.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return user, nil, err
+	}
+	events, err := cfg.dbQueries.ListSecurityEvents(ctx, database.ListSecurityEventsParams{
+		TargetID:   uuid.NullUUID{UUID: userID, Valid: true},
+		EventTypes: securityEventTypes,
+		MaxResults: math.MaxInt32,
+	})
+	if err != nil {
+		return user, nil, err
+	}
+
+	chirpsExport := []takeout.Chirp{}
+	for _, chirp := range chirps {
+		chirpsExport = append(chirpsExport, takeout.Chirp{
+			ID:        chirp.ID.String(),
+			CreatedAt: chirp.CreatedAt,
+			UpdatedAt: chirp.UpdatedAt,
+			Body:      chirp.Body,
+			Hidden:    chirp.HiddenAt.Valid,
+		})
+	}
+	sessionsExport := []sessionResponse{}
+	for _, session := range sessions {
+		sessionsExport = append(sessionsExport, newSessionResponse(session))
+	}
+	passkeysExport := []passkeyResponse{}
+	for _, key := range passkeys {
+		passkeysExport = append(passkeysExport, newPasskeyResponse(key))
+	}
+	apiKeysExport := []apiKeyResponse{}
+	for _, key := range apiKeys {
+		apiKeysExport = append(apiKeysExport, newAPIKeyResponse(key))
+	}
+	eventsExport := []auditEventResponse{}
+	for _, event := range events {
+		e := newAuditEventResponse(event)
+		e.ActorID = nil
+		e.TargetID = nil
+		eventsExport = append(eventsExport, e)
+	}
+
+	buf := bytes.Buffer{}
+	archive := takeout.NewArchive(&buf)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", profileExport{
+			ID:            user.ID,
+			CreatedAt:     user.CreatedAt,
+			UpdatedAt:     user.UpdatedAt,
+			Email:         user.Email,
+			EmailVerified: user.EmailVerifiedAt.Valid,
+			PendingEmail:  user.PendingEmail.String,
+			Role:          user.Role,
+			MFAEnabled:    user.TotpEnabledAt.Valid,
+		}},
+		{"chirps.json", chirpsExport},
+		{"sessions.json", sessionsExport},
+		{"passkeys.json", passkeysExport},
+		{"api_keys.json", apiKeysExport},
+		{"security_events.json", eventsExport},
+	}
+	for _, file := range files {
+		err = archive.AddJSON(file.name, file.data)
+		if err != nil {
+			return user, nil, err
+		}
+	}
+	err = archive.AddChirpsHTML("chirps.html", user.Email, chirpsExport)
+	if err != nil {
+		return user, nil, err
+	}
+	err = archive.Close()
+	if err != nil {
+		return user, nil, err
+	}
+	return user, buf.Bytes(), nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/chirpimport"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+const (
+	// importBatchSize is how many rows are imported per transaction. Progress
+	// is saved with each batch, so that's the most an interrupted import
+	// goes over again.
+	importBatchSize = 100
+	// importStaleAfter is how long a running import may go without saving
+	// progress before another worker assumes it died and carries it on.
+	importStaleAfter = 5 * time.Minute
+	// maxImportRowErrors caps the bad rows an import keeps the details of.
+	// The rest are only counted.
+	maxImportRowErrors = 100
+)
+
+type importResponse struct {
+	ID            uuid.UUID       `json:"id"`
+	Status        string          `json:"status"`
+	Format        string          `json:"format"`
+	CreatedAt     time.Time       `json:"created_at"`
+	StartedAt     *time.Time      `json:"started_at"`
+	CompletedAt   *time.Time      `json:"completed_at"`
+	TotalRows     int32           `json:"total_rows"`
+	ProcessedRows int32           `json:"processed_rows"`
+	ImportedRows  int32           `json:"imported_rows"`
+	SkippedRows   int32           `json:"skipped_rows"`
+	FailedRows    int32           `json:"failed_rows"`
+	RowErrors     json.RawMessage `json:"row_errors"`
+	Error         string          `json:"error,omitempty"`
+}
+
+func newImportResponse(job database.GetImportJobRow) importResponse {
+	return importResponse{
+		ID:            job.ID,
+		Status:        job.Status,
+		Format:        job.Format,
+		CreatedAt:     job.CreatedAt,
+		StartedAt:     nullTime(job.StartedAt),
+		CompletedAt:   nullTime(job.CompletedAt),
+		TotalRows:     job.TotalRows,
+		ProcessedRows: job.ProcessedRows,
+		ImportedRows:  job.ImportedRows,
+		SkippedRows:   job.SkippedRows,
+		FailedRows:    job.FailedRows,
+		RowErrors:     job.RowErrors,
+		Error:         job.Error,
+	}
+}
+
+// importRowError is a row that wasn't imported, as the progress report shows
+// it.
+type importRowError struct {
+	Line       int    `json:"line"`
+	ExternalID string `json:"external_id,omitempty"`
+	Error      string `json:"error"`
+}
+
+// handlerRequestImport queues an archive of chirps to be imported with their
+// original timestamps. The body is the archive itself, as JSON Lines or CSV,
+// given by the format query parameter or else the Content-Type. Rows are
+// checked against the same rules as new chirps, and rows whose external ID
+// was imported before are skipped, so the same archive can be sent again.
+func (cfg *apiConfig) handlerRequestImport(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticateScoped(req, auth.ScopeChirpsWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	if !cfg.checkEmailVerified(w, req, userID, restrictPostChirps) {
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		format = chirpimport.FormatFor(mediaType, "")
+	}
+	if format != chirpimport.FormatJSONL && format != chirpimport.FormatCSV {
+		respondWithError(w, 415, "Send the archive as application/x-ndjson or text/csv, or give its format as jsonl or csv")
+		return
+	}
+
+	archive, err := io.ReadAll(http.MaxBytesReader(w, req.Body, cfg.importMaxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondWithError(w, 413, fmt.Sprintf("Archive is larger than %d bytes", tooLarge.Limit))
+		return
+	}
+	if err != nil {
+		respondWithError(w, 400, "Couldn't read the archive")
+		return
+	}
+
+	reader, err := chirpimport.NewReader(format, bytes.NewReader(archive))
+	total := 0
+	if err == nil {
+		total, err = reader.Count()
+	}
+	if err != nil {
+		message, ok := archiveErrorMessage(err)
+		if !ok {
+			respondWithInternalError(w, err)
+			return
+		}
+		respondWithProblem(w, problem{
+			Status: 400,
+			Detail: "Archive couldn't be read",
+			Errors: []fieldError{{Field: "archive", Message: message}},
+		})
+		return
+	}
+	if total == 0 {
+		respondWithError(w, 400, "Archive has no chirps in it")
+		return
+	}
+
+	job, err := cfg.dbQueries.CreateImportJob(req.Context(), database.CreateImportJobParams{
+		UserID:    userID,
+		Status:    "pending",
+		Format:    format,
+		Archive:   archive,
+		TotalRows: int32(total),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	select {
+	case cfg.importWake <- struct{}{}:
+	default:
+	}
+
+	cfg.audit(req.Context(), req, auditImportRequested, userID, userID, map[string]any{
+		"import_id": job.ID,
+		"format":    format,
+		"rows":      total,
+	})
+	w.Header().Set("Location", "/api/users/me/imports/"+job.ID.String())
+	respondWithJSON(w, 202, newImportResponse(database.GetImportJobRow(job)))
+}
+
+// handlerGetImport reports how an import is getting on, with the rows that
+// couldn't be imported and why.
+func (cfg *apiConfig) handlerGetImport(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticateScoped(req, auth.ScopeChirpsRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	importID, err := uuid.Parse(req.PathValue("importID"))
+	if err != nil {
+		respondWithError(w, 404, "Import not found")
+		return
+	}
+
+	job, err := cfg.dbQueries.GetImportJob(req.Context(), database.GetImportJobParams{
+		ID:     importID,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Import not found")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	respondWithJSON(w, 200, newImportResponse(job))
+}
+
+// runImports works through queued imports until ctx is done, the same way
+// runExports does. Imports that were cut off are carried on from their last
+// saved batch.
+func (cfg *apiConfig) runImports(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.dbQueries.DeleteFinishedImports(ctx)
+		if err != nil {
+			log.Printf("Error deleting finished imports: %s", err)
+		}
+		for {
+			job, err := cfg.dbQueries.ClaimImportJob(ctx, sql.NullTime{Time: time.Now().UTC().Add(-importStaleAfter), Valid: true})
+			if errors.Is(err, sql.ErrNoRows) {
+				break
+			}
+			if err != nil {
+				log.Printf("Error claiming import: %s", err)
+				break
+			}
+			cfg.runImport(ctx, job, nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.importWake:
+		}
+	}
+}
+
+// runImport imports a job's rows from where it last left off, calling
+// progress, if given, after each batch. A job that can't go on for a reason
+// other than its archive is left running, for a worker to carry on once it
+// goes stale. Every write is made under the job's claim, and the import
+// stops, throwing away its current batch, as soon as another worker has
+// claimed it since.
+func (cfg *apiConfig) runImport(ctx context.Context, job database.ClaimImportJobRow, progress func(processed int32)) {
+	fail := func(readErr error) {
+		reason, ok := archiveErrorMessage(readErr)
+		if !ok {
+			log.Printf("Error reading import %s: %s", job.ID, readErr)
+			reason = "The archive couldn't be read"
+		}
+		_, err := cfg.dbQueries.FailImportJob(ctx, database.FailImportJobParams{
+			ID:      job.ID,
+			ClaimID: job.ClaimID,
+			Error:   reason,
+		})
+		if err != nil {
+			log.Printf("Error failing import %s: %s", job.ID, err)
+		}
+	}
+
+	reader, err := chirpimport.NewReader(job.Format, bytes.NewReader(job.Archive))
+	if err != nil {
+		fail(err)
+		return
+	}
+	for range job.ProcessedRows {
+		_, err = reader.Read()
+		var rowErr *chirpimport.RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			fail(err)
+			return
+		}
+	}
+
+	recorded := min(job.FailedRows, maxImportRowErrors)
+	for done := false; !done; {
+		tx, err := cfg.db.BeginTx(ctx, nil)
+		if err != nil {
+			log.Printf("Error importing %s: %s", job.ID, err)
+			return
+		}
+		qtx := cfg.dbQueries.WithTx(tx)
+
+		rowErrors := []importRowError{}
+		for range importBatchSize {
+			row, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				done = true
+				break
+			}
+			var rowErr *chirpimport.RowError
+			if err != nil && !errors.As(err, &rowErr) {
+				tx.Rollback()
+				fail(err)
+				return
+			}
+			if rowErr == nil {
+				err = importRow(ctx, qtx, row, &job)
+				if err != nil && !errors.As(err, &rowErr) {
+					tx.Rollback()
+					log.Printf("Error importing %s: %s", job.ID, err)
+					return
+				}
+			}
+			job.ProcessedRows++
+			if rowErr != nil {
+				job.FailedRows++
+				if recorded < maxImportRowErrors {
+					rowErrors = append(rowErrors, importRowError{
+						Line:       rowErr.Line,
+						ExternalID: row.ExternalID,
+						Error:      rowErr.Err.Error(),
+					})
+					recorded++
+				}
+			}
+		}
+
+		var recordedRows int64
+		newRowErrors, err := json.Marshal(rowErrors)
+		if err == nil {
+			recordedRows, err = qtx.RecordImportProgress(ctx, database.RecordImportProgressParams{
+				ID:            job.ID,
+				ClaimID:       job.ClaimID,
+				ProcessedRows: job.ProcessedRows,
+				ImportedRows:  job.ImportedRows,
+				SkippedRows:   job.SkippedRows,
+				FailedRows:    job.FailedRows,
+				NewRowErrors:  newRowErrors,
+			})
+		}
+		if err == nil && recordedRows == 0 {
+			tx.Rollback()
+			log.Printf("Import %s was claimed by another worker, stopping", job.ID)
+			return
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Printf("Error importing %s: %s", job.ID, err)
+			return
+		}
+		if progress != nil {
+			progress(job.ProcessedRows)
+		}
+	}
+
+	completed, err := cfg.dbQueries.CompleteImportJob(ctx, database.CompleteImportJobParams{
+		ID:      job.ID,
+		ClaimID: job.ClaimID,
+	})
+	if err != nil {
+		log.Printf("Error completing import %s: %s", job.ID, err)
+		return
+	}
+	if completed == 0 {
+		log.Printf("Import %s was claimed by another worker, stopping", job.ID)
+		return
+	}
+	cfg.audit(ctx, nil, auditImportCompleted, job.UserID, job.UserID, map[string]any{
+		"import_id": job.ID,
+		"imported":  job.ImportedRows,
+		"skipped":   job.SkippedRows,
+		"failed":    job.FailedRows,
+	})
+}
+
+// archiveErrorMessage says why an archive can't be read, in words that are
+// safe to show its owner, and returns false for errors that aren't about the
+// archive.
+func archiveErrorMessage(err error) (string, bool) {
+	var archiveErr *chirpimport.ArchiveError
+	switch {
+	case errors.As(err, &archiveErr):
+		return fmt.Sprintf("line %d: %s", archiveErr.Line, archiveErr.Err), true
+	case errors.Is(err, chirpimport.ErrCSVHeader), errors.Is(err, chirpimport.ErrUnknownFormat):
+		return err.Error(), true
+	}
+	return "", false
+}
+
+// importRow adds one row as a chirp, counting it on the job as imported or
+// skipped. A row that breaks the rules for chirps gets a *RowError.
+func importRow(ctx context.Context, qtx *database.Queries, row chirpimport.Row, job *database.ClaimImportJobRow) error {
+	body, err := cleanChirp(row.Body)
+	if err != nil {
+		return &chirpimport.RowError{Line: row.Line, Err: err}
+	}
+	if row.CreatedAt.After(time.Now()) {
+		return &chirpimport.RowError{Line: row.Line, Err: errors.New("created_at is in the future")}
+	}
+
+	imported, err := qtx.ImportChirp(ctx, database.ImportChirpParams{
+		CreatedAt:  row.CreatedAt.UTC(),
+		Body:       body,
+		UserID:     job.UserID,
+		ExternalID: sql.NullString{String: row.ExternalID, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if imported == 0 {
+		job.SkippedRows++
+	} else {
+		job.ImportedRows++
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/wjseele/chirpy/internal/chirpimport"
+)
+
+func TestArchiveErrorMessage(t *testing.T) {
+	cases := []struct {
+		err     error
+		message string
+		ok      bool
+	}{
+		{&chirpimport.ArchiveError{Line: 3, Err: chirpimport.ErrMalformedCSV, Cause: errors.New(`extraneous " in field`)}, "line 3: CSV is malformed", true},
+		{&chirpimport.ArchiveError{Line: 7, Err: chirpimport.ErrLineTooLong}, "line 7: line is longer than 65536 bytes", true},
+		{chirpimport.ErrCSVHeader, chirpimport.ErrCSVHeader.Error(), true},
+		{errors.New("read /tmp/archive: input/output error"), "", false},
+	}
+	for _, c := range cases {
+		message, ok := archiveErrorMessage(c.err)
+		if message != c.message || ok != c.ok {
+			t.Errorf("archiveErrorMessage(%v): Got %q, %v, expected %q, %v", c.err, message, ok, c.message, c.ok)
+		}
+	}
+}
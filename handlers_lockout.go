@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+type lockoutResponse struct {
+	Subject       string    `json:"subject"`
+	Failures      int32     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// Failed logins are counted per account and per client address. Accounts
+// are keyed by the email that was tried rather than by user ID, so unknown
+// addresses lock exactly like known ones and a lockout reveals nothing.
+func accountSubject(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+func ipSubject(req *http.Request) string {
+	return "ip:" + clientIP(req)
+}
+
+// checkLoginLockout responds with a 429 and returns false while the account
+// or the client address is locked out.
+func (cfg *apiConfig) checkLoginLockout(w http.ResponseWriter, req *http.Request, email string) bool {
+	lockedUntil, err := cfg.loginLockedUntil(req, email)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	if lockedUntil.IsZero() {
+		return true
+	}
+	respondLocked(w, lockedUntil, "login_locked", "Too many failed login attempts, try again later")
+	return false
+}
+
+// respondLocked responds with a 429 that says when to try again.
+func respondLocked(w http.ResponseWriter, lockedUntil time.Time, code, detail string) {
+	retryAfter := int(math.Ceil(time.Until(lockedUntil).Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	respondWithCode(w, 429, code, detail)
+}
+
+// loginLockedUntil returns when the lockout of the account or the client
+// address ends, whichever is later, or the zero time if neither is locked.
+func (cfg *apiConfig) loginLockedUntil(req *http.Request, email string) (time.Time, error) {
+	return cfg.lockedUntil(req.Context(), accountSubject(email), ipSubject(req))
+}
+
+// lockedUntil returns when the last lockout of the subjects ends, or the
+// zero time if none of them is locked.
+func (cfg *apiConfig) lockedUntil(ctx context.Context, subjects ...string) (time.Time, error) {
+	lockouts, err := cfg.dbQueries.GetActiveLockouts(ctx, subjects)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var lockedUntil time.Time
+	for _, lockout := range lockouts {
+		if lockout.LockedUntil.Time.After(lockedUntil) {
+			lockedUntil = lockout.LockedUntil.Time
+		}
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginFailure audits a failed attempt and counts it against the
+// account and the client address, locking either one once its policy says
+// so.
+func (cfg *apiConfig) recordLoginFailure(req *http.Request, email string, userID uuid.UUID) {
+	cfg.audit(req.Context(), req, auditLoginFailed, uuid.Nil, userID, map[string]any{
+		"email": email,
+	})
+	counters := []struct {
+		subject string
+		policy  auth.LockoutPolicy
+		target  uuid.UUID
+	}{
+		{subject: accountSubject(email), policy: cfg.accountLockout, target: userID},
+		{subject: ipSubject(req), policy: cfg.ipLockout, target: uuid.Nil},
+	}
+	for _, counter := range counters {
+		failures, lockedUntil, err := cfg.countAttempt(req.Context(), counter.subject, counter.policy)
+		if err != nil {
+			log.Printf("Error recording failed login: %s", err)
+			continue
+		}
+		if lockedUntil.IsZero() {
+			continue
+		}
+		cfg.audit(req.Context(), req, auditLoginLocked, uuid.Nil, counter.target, map[string]any{
+			"subject":      counter.subject,
+			"failures":     failures,
+			"locked_until": lockedUntil,
+		})
+	}
+}
+
+// countAttempt counts an attempt against subject within the failure window,
+// and locks the subject once policy says so. It returns the attempts so far
+// and, when it locked, until when.
+func (cfg *apiConfig) countAttempt(ctx context.Context, subject string, policy auth.LockoutPolicy) (int32, time.Time, error) {
+	now := time.Now().UTC()
+	attempts, err := cfg.dbQueries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Subject:     subject,
+		ResetBefore: now.Add(-cfg.loginFailureWindow),
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lockout := policy.LockoutFor(attempts)
+	if lockout == 0 {
+		return attempts, time.Time{}, nil
+	}
+	lockedUntil := now.Add(lockout)
+	err = cfg.dbQueries.LockLogin(ctx, database.LockLoginParams{
+		Subject:     subject,
+		LockedUntil: sql.NullTime{Time: lockedUntil, Valid: true},
+	})
+	if err != nil {
+		return attempts, time.Time{}, err
+	}
+	return attempts, lockedUntil, nil
+}
+
+// clearLoginFailures forgets the failed attempts against an account after a
+// successful login. The client address keeps its count, or an attacker could
+// reset it by logging into an account of their own.
+func (cfg *apiConfig) clearLoginFailures(req *http.Request, email string) {
+	_, err := cfg.dbQueries.ClearLoginFailures(req.Context(), accountSubject(email))
+	if err != nil {
+		log.Printf("Error clearing failed logins: %s", err)
+	}
+}
+
+func (cfg *apiConfig) handlerListLockouts(w http.ResponseWriter, req *http.Request) {
+	lockouts, err := cfg.dbQueries.ListActiveLockouts(req.Context())
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []lockoutResponse{}
+	for _, lockout := range lockouts {
+		resp = append(resp, lockoutResponse{
+			Subject:       lockout.Subject,
+			Failures:      lockout.Failures,
+			LastFailureAt: lockout.LastFailureAt,
+			LockedUntil:   lockout.LockedUntil.Time,
+		})
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerClearLockout lifts the lockout of a subject such as
+// email:walt@example.com or ip:203.0.113.7 and resets its failure count.
+func (cfg *apiConfig) handlerClearLockout(w http.ResponseWriter, req *http.Request) {
+	subject := req.PathValue("subject")
+	cleared, err := cfg.dbQueries.ClearLoginFailures(req.Context(), subject)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if cleared == 0 {
+		respondWithError(w, 404, "No failed logins recorded for that subject")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditLockoutCleared, actorID(req), uuid.Nil, map[string]any{
+		"subject": subject,
+	})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Chirpy"
+)
+
+type mfaChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaJWT is the token config for MFA challenge tokens. They are signed like
+// access tokens but carry their own audience, so one can never be used in
+// place of the other.
+func (cfg *apiConfig) mfaJWT() auth.JWTConfig {
+	config := cfg.jwt
+	config.Audience = cfg.jwt.Audience + "/mfa"
+	return config
+}
+
+func (cfg *apiConfig) respondWithMFAChallenge(w http.ResponseWriter, dbUser database.User) {
+	token, err := auth.MakeJWT(dbUser.ID, dbUser.TokenVersion, cfg.mfaJWT(), mfaChallengeTTL)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	respondWithJSON(w, 200, mfaChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+	})
+}
+
+// requiresMFA reports whether signing in with a password, or an identity
+// provider, needs a second factor: an authenticator app or any passkey.
+func (cfg *apiConfig) requiresMFA(ctx context.Context, user database.User) (bool, error) {
+	if user.TotpEnabledAt.Valid {
+		return true, nil
+	}
+	return cfg.dbQueries.HasPasskeys(ctx, user.ID)
+}
+
+// checkSecondFactor accepts either a current TOTP code or an unused recovery
+// code. A TOTP code is only good once, and so is a recovery code. Codes from
+// a secret whose enrollment hasn't been confirmed don't count, since
+// enrolling only takes an access token.
+func (cfg *apiConfig) checkSecondFactor(ctx context.Context, user database.User, code, recoveryCode string) (bool, error) {
+	if code != "" && user.TotpEnabledAt.Valid {
+		step, ok := auth.ValidateTOTP(user.TotpSecret.String, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		used, err := cfg.dbQueries.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			ID:           user.ID,
+			TotpLastStep: step,
+		})
+		return used == 1, err
+	}
+	if recoveryCode != "" {
+		used, err := cfg.dbQueries.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID:   user.ID,
+			CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(recoveryCode)),
+		})
+		return used == 1, err
+	}
+	return false, nil
+}
+
+// replaceRecoveryCodes throws away the user's recovery codes and returns a
+// fresh set. Only hashes are kept, so this is the one time they are shown.
+func (cfg *apiConfig) replaceRecoveryCodes(ctx context.Context, qtx *database.Queries, userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	err = qtx.DeleteRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		err = qtx.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// validateMFAToken returns the user an MFA challenge token was issued to.
+func (cfg *apiConfig) validateMFAToken(req *http.Request, token string) (uuid.UUID, error) {
+	return auth.ValidateJWT(token, cfg.mfaJWT(), func(userID uuid.UUID) (int32, error) {
+		return cfg.dbQueries.GetTokenVersion(req.Context(), userID)
+	})
+}
+
+// useToken marks a short-lived token as used, and reports false when it
+// already was. Tokens are remembered until they'd have expired anyway.
+func (cfg *apiConfig) useToken(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	err := cfg.dbQueries.DeleteExpiredUsedTokens(ctx)
+	if err != nil {
+		log.Printf("Error deleting expired used tokens: %s", err)
+	}
+	used, err := cfg.dbQueries.UseToken(ctx, database.UseTokenParams{
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().UTC().Add(ttl + cfg.jwt.Leeway),
+	})
+	return used == 1, err
+}
+
+// useMFAToken spends an MFA challenge token once its second factor has
+// checked out, so it can't start a second session. It responds and returns
+// false when the token was already spent.
+func (cfg *apiConfig) useMFAToken(w http.ResponseWriter, req *http.Request, token string) bool {
+	fresh, err := cfg.useToken(req.Context(), token, mfaChallengeTTL)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	if !fresh {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, req *http.Request) {
+	type mfaLogin struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		DeviceName   string `json:"device_name"`
+	}
+
+	post := mfaLogin{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	userID, err := cfg.validateMFAToken(req, post.MFAToken)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	// Codes are short, so guesses count towards the same lockout as
+	// passwords.
+	if !cfg.checkLoginLockout(w, req, dbUser.Email) {
+		return
+	}
+	ok, err := cfg.checkSecondFactor(req.Context(), dbUser, post.Code, post.RecoveryCode)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+		respondWithError(w, 401, "Incorrect code")
+		return
+	}
+	cfg.clearLoginFailures(req, dbUser.Email)
+	if !cfg.useMFAToken(w, req, post.MFAToken) {
+		return
+	}
+
+	cfg.startSession(w, req, dbUser, post.DeviceName, "mfa_code")
+}
+
+// handlerEnrollTOTP starts enrollment by generating a secret. Two-factor
+// authentication isn't on until a code from it has been confirmed. It takes
+// the password or a reauth token, so a stolen access token can't turn it on
+// and lock the owner out.
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, req *http.Request) {
+	type enrollRequest struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+	}
+	type enrollResponse struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := enrollRequest{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if !cfg.checkReauth(w, req, user, post.Password, post.ReauthToken) {
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	err = cfg.dbQueries.SetTOTPSecret(req.Context(), database.SetTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	respondWithJSON(w, 201, enrollResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, req *http.Request) {
+	type totpConfirm struct {
+		Code string `json:"code"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := totpConfirm{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if user.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if !user.TotpSecret.Valid {
+		respondWithError(w, 400, "Start enrollment first")
+		return
+	}
+	step, ok := auth.ValidateTOTP(user.TotpSecret.String, post.Code, time.Now())
+	if !ok {
+		respondWithError(w, 400, "Incorrect code")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.EnableTOTP(req.Context(), database.EnableTOTPParams{
+		ID:           userID,
+		TotpLastStep: step,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	codes, err := cfg.replaceRecoveryCodes(req.Context(), qtx, userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditMFAEnabled, userID, userID, map[string]any{})
+	respondWithJSON(w, 200, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// secondFactorRequest is the body of the endpoints that change an enrolled
+// second factor. They need a fresh code, so a stolen access token alone
+// can't turn two-factor authentication off.
+type secondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// enrolledUser authenticates the request and checks the second factor in its
+// body, responding with an error and returning false when either fails.
+// Wrong codes count towards the login lockout.
+func (cfg *apiConfig) enrolledUser(w http.ResponseWriter, req *http.Request) (database.User, bool) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return database.User{}, false
+	}
+
+	post := secondFactorRequest{}
+	if !decodeJSON(w, req, &post) {
+		return database.User{}, false
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return database.User{}, false
+	}
+	if !user.TotpEnabledAt.Valid {
+		respondWithError(w, 400, "Two-factor authentication isn't enabled")
+		return database.User{}, false
+	}
+	// Guesses count towards the login lockout, as they do at login.
+	if !cfg.checkLoginLockout(w, req, user.Email) {
+		return database.User{}, false
+	}
+	ok, err := cfg.checkSecondFactor(req.Context(), user, post.Code, post.RecoveryCode)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return database.User{}, false
+	}
+	if !ok {
+		cfg.recordLoginFailure(req, user.Email, user.ID)
+		respondWithError(w, 403, "Incorrect code")
+		return database.User{}, false
+	}
+	cfg.clearLoginFailures(req, user.Email)
+	return user, true
+}
+
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.enrolledUser(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.DisableTOTP(req.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	err = qtx.DeleteRecoveryCodes(req.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditMFADisabled, user.ID, user.ID, map[string]any{})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerRegenerateRecoveryCodes(w http.ResponseWriter, req *http.Request) {
+	user, ok := cfg.enrolledUser(w, req)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := cfg.replaceRecoveryCodes(req.Context(), cfg.dbQueries.WithTx(tx), user.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditRecoveryCodesReset, user.ID, user.ID, map[string]any{})
+	respondWithJSON(w, 200, recoveryCodesResponse{RecoveryCodes: codes})
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/oauth"
+)
+
+const oauthCodeTTL = time.Minute
+
+// oauthScopes are the scopes third-party apps can ask for, with the wording
+// the consent page uses for them.
+var oauthScopes = map[string]string{
+	auth.ScopeChirpsRead:  "Read chirps on your behalf",
+	auth.ScopeChirpsWrite: "Post and delete chirps as you",
+}
+
+// oauthJWT is the token config for access tokens issued to OAuth clients.
+// Their own audience keeps them from passing for login tokens, which aren't
+// limited by scopes.
+func (cfg *apiConfig) oauthJWT() auth.JWTConfig {
+	config := cfg.jwt
+	config.Audience = cfg.jwt.Audience + "/oauth"
+	return config
+}
+
+// oauthStore keeps the authorization server's clients, codes and grants in
+// the database.
+type oauthStore struct {
+	q *database.Queries
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return oauth.ErrNotFound
+	}
+	return err
+}
+
+func newOAuthGrant(grant database.OauthGrant) oauth.Grant {
+	return oauth.Grant{
+		ID:               grant.ID,
+		ClientID:         grant.ClientID,
+		UserID:           grant.UserID,
+		Scopes:           grant.Scopes,
+		RefreshTokenHash: grant.RefreshTokenHash,
+		ExpiresAt:        grant.ExpiresAt,
+	}
+}
+
+func (s oauthStore) GetClient(ctx context.Context, clientID string) (oauth.Client, error) {
+	client, err := s.q.GetOAuthClient(ctx, clientID)
+	if err != nil {
+		return oauth.Client{}, notFound(err)
+	}
+	return oauth.Client{
+		ID:           client.ID,
+		OwnerID:      client.OwnerID,
+		Name:         client.Name,
+		SecretHash:   client.SecretHash,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+	}, nil
+}
+
+func (s oauthStore) CreateAuthorizationCode(ctx context.Context, code oauth.AuthorizationCode) error {
+	return s.q.CreateOAuthAuthorizationCode(ctx, database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      code.CodeHash,
+		ClientID:      code.ClientID,
+		UserID:        code.UserID,
+		RedirectUri:   code.RedirectURI,
+		Scopes:        code.Scopes,
+		CodeChallenge: code.CodeChallenge,
+		ExpiresAt:     code.ExpiresAt,
+	})
+}
+
+func (s oauthStore) UseAuthorizationCode(ctx context.Context, codeHash string) (oauth.AuthorizationCode, error) {
+	code, err := s.q.UseOAuthAuthorizationCode(ctx, codeHash)
+	if err != nil {
+		return oauth.AuthorizationCode{}, notFound(err)
+	}
+	return oauth.AuthorizationCode{
+		CodeHash:      code.CodeHash,
+		ClientID:      code.ClientID,
+		UserID:        code.UserID,
+		RedirectURI:   code.RedirectUri,
+		Scopes:        code.Scopes,
+		CodeChallenge: code.CodeChallenge,
+		ExpiresAt:     code.ExpiresAt,
+	}, nil
+}
+
+func (s oauthStore) CreateGrant(ctx context.Context, grant oauth.Grant) error {
+	return s.q.CreateOAuthGrant(ctx, database.CreateOAuthGrantParams{
+		ID:               grant.ID,
+		ClientID:         grant.ClientID,
+		UserID:           grant.UserID,
+		Scopes:           grant.Scopes,
+		RefreshTokenHash: grant.RefreshTokenHash,
+		ExpiresAt:        grant.ExpiresAt,
+	})
+}
+
+func (s oauthStore) GetGrant(ctx context.Context, id uuid.UUID) (oauth.Grant, error) {
+	grant, err := s.q.GetOAuthGrant(ctx, id)
+	if err != nil {
+		return oauth.Grant{}, notFound(err)
+	}
+	return newOAuthGrant(grant), nil
+}
+
+func (s oauthStore) GetGrantByRefreshToken(ctx context.Context, tokenHash string) (oauth.Grant, error) {
+	grant, err := s.q.GetOAuthGrantByRefreshToken(ctx, tokenHash)
+	if err != nil {
+		return oauth.Grant{}, notFound(err)
+	}
+	return newOAuthGrant(grant), nil
+}
+
+func (s oauthStore) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (oauth.Grant, error) {
+	grant, err := s.q.RotateOAuthRefreshToken(ctx, database.RotateOAuthRefreshTokenParams{
+		OldHash:   oldHash,
+		NewHash:   newHash,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return oauth.Grant{}, notFound(err)
+	}
+	return newOAuthGrant(grant), nil
+}
+
+func (s oauthStore) RevokeGrantByPreviousRefreshToken(ctx context.Context, oldHash string) error {
+	return s.q.RevokeOAuthGrantByPreviousRefreshToken(ctx, sql.NullString{String: oldHash, Valid: true})
+}
+
+func (s oauthStore) RevokeGrant(ctx context.Context, id uuid.UUID) error {
+	return s.q.RevokeOAuthGrant(ctx, id)
+}
+
+// oauthUsers signs users in on the consent page with the same checks as
+// POST /api/login and POST /api/login/mfa, lockouts included.
+type oauthUsers struct {
+	cfg *apiConfig
+}
+
+func (u oauthUsers) Authenticate(req *http.Request, email, password, code string) (uuid.UUID, error) {
+	cfg := u.cfg
+	lockedUntil, err := cfg.loginLockedUntil(req, email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !lockedUntil.IsZero() {
+		return uuid.Nil, &oauth.LoginError{Message: "Too many failed login attempts, try again later"}
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByEmail(req.Context(), email)
+	if errors.Is(err, sql.ErrNoRows) {
+		auth.CheckDummyPassword(password, cfg.argon2)
+		cfg.recordLoginFailure(req, email, uuid.Nil)
+		return uuid.Nil, &oauth.LoginError{Message: "Incorrect email or password"}
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	err = auth.CheckPasswordHash(password, dbUser.HashedPassword)
+	if err != nil {
+		cfg.recordLoginFailure(req, email, dbUser.ID)
+		return uuid.Nil, &oauth.LoginError{Message: "Incorrect email or password"}
+	}
+
+	// The consent page is a plain form, so it can ask for a code but not a
+	// passkey. Users whose only second factor is a passkey can't sign in
+	// here until they turn on an authenticator app.
+	mfa, err := cfg.requiresMFA(req.Context(), dbUser)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if mfa && !dbUser.TotpEnabledAt.Valid {
+		return uuid.Nil, &oauth.LoginError{Message: "This page can't ask for a passkey, turn on an authenticator app to sign in here"}
+	}
+	if mfa {
+		if code == "" {
+			return uuid.Nil, &oauth.LoginError{Message: "Enter the code from your authenticator app, or a recovery code"}
+		}
+		ok, err := cfg.checkSecondFactor(req.Context(), dbUser, code, "")
+		if err == nil && !ok {
+			ok, err = cfg.checkSecondFactor(req.Context(), dbUser, "", code)
+		}
+		if err != nil {
+			return uuid.Nil, err
+		}
+		if !ok {
+			cfg.recordLoginFailure(req, email, dbUser.ID)
+			return uuid.Nil, &oauth.LoginError{Message: "Incorrect code"}
+		}
+	}
+
+	cfg.clearLoginFailures(req, email)
+	cfg.rehashPassword(req.Context(), dbUser, password)
+	if dbUser.SuspendedAt.Valid {
+		return uuid.Nil, &oauth.LoginError{Message: "This account is suspended"}
+	}
+	cfg.cancelAccountDeletion(req, dbUser)
+	cfg.audit(req.Context(), req, auditLoginSucceeded, dbUser.ID, dbUser.ID, map[string]any{
+		"method": "oauth_consent",
+	})
+	return dbUser.ID, nil
+}
+
+func (u oauthUsers) TokenVersion(ctx context.Context, userID uuid.UUID) (int32, error) {
+	version, err := u.cfg.dbQueries.GetTokenVersion(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, auth.ErrTokenRevoked
+	}
+	return version, err
+}
+
+type oauthClientResponse struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func newOAuthClientResponse(client database.OauthClient) oauthClientResponse {
+	return oauthClientResponse{
+		ClientID:     client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Public:       client.SecretHash == "",
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// handlerCreateOAuthClient registers a third-party app. Confidential clients
+// get a secret, shown only in this response; public ones, such as mobile
+// apps, rely on PKCE alone.
+func (cfg *apiConfig) handlerCreateOAuthClient(w http.ResponseWriter, req *http.Request) {
+	type clientPost struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := clientPost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if post.Name == "" {
+		respondWithError(w, 400, "Name can't be empty")
+		return
+	}
+	if len(post.RedirectURIs) == 0 {
+		respondWithError(w, 400, "A client needs at least one redirect URI")
+		return
+	}
+	for _, uri := range post.RedirectURIs {
+		if !oauth.ValidRedirectURI(uri) {
+			respondWithError(w, 400, fmt.Sprintf("Redirect URI %q must be https, or http to localhost", uri))
+			return
+		}
+	}
+	if len(post.Scopes) == 0 {
+		respondWithError(w, 400, "A client needs at least one scope")
+		return
+	}
+	for _, scope := range post.Scopes {
+		if _, ok := oauthScopes[scope]; !ok {
+			respondWithError(w, 400, fmt.Sprintf("Unknown scope %q", scope))
+			return
+		}
+	}
+
+	secret, secretHash := "", ""
+	if !post.Public {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+		secretHash = auth.HashToken(secret)
+	}
+	client, err := cfg.dbQueries.CreateOAuthClient(req.Context(), database.CreateOAuthClientParams{
+		ID:           uuid.New().String(),
+		OwnerID:      userID,
+		Name:         post.Name,
+		SecretHash:   secretHash,
+		RedirectUris: post.RedirectURIs,
+		Scopes:       post.Scopes,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := newOAuthClientResponse(client)
+	resp.ClientSecret = secret
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) handlerListOAuthClients(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	clients, err := cfg.dbQueries.ListOAuthClients(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []oauthClientResponse{}
+	for _, client := range clients {
+		resp = append(resp, newOAuthClientResponse(client))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerDeleteOAuthClient removes a client, and with it every grant and
+// token it holds.
+func (cfg *apiConfig) handlerDeleteOAuthClient(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	deleted, err := cfg.dbQueries.DeleteOAuthClient(req.Context(), database.DeleteOAuthClientParams{
+		OwnerID: userID,
+		ID:      req.PathValue("clientID"),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Client not found")
+		return
+	}
+
+	w.WriteHeader(204)
+}
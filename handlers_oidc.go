@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/oidc"
+)
+
+const (
+	// oidcLoginTTL is how long a user has to finish signing in at the
+	// identity provider.
+	oidcLoginTTL = 10 * time.Minute
+	// oidcCodeTTL is how long the frontend has to trade the code it's sent
+	// back with for tokens.
+	oidcCodeTTL = time.Minute
+	// oidcStateCookie ties a sign-in to the browser that started it, so
+	// nobody can finish their own sign-in in someone else's browser.
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+var (
+	errEmailNotVerifiedByProvider = errors.New("The identity provider hasn't verified your email address")
+	errEmailNotVerifiedHere       = errors.New("An account with this email already exists; verify its email address or sign in with its password before using single sign-on")
+)
+
+// handlerOIDCLogin starts a single sign-on: it remembers the attempt and
+// sends the browser to the identity provider. The state, nonce and PKCE
+// verifier tie the callback to this attempt, and a cookie holding the
+// state's hash ties it to this browser.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, req *http.Request) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = cfg.dbQueries.DeleteExpiredOIDCLoginStates(req.Context())
+	if err != nil {
+		log.Printf("Error deleting expired OIDC login states: %s", err)
+	}
+	err = cfg.dbQueries.DeleteExpiredOIDCLoginCodes(req.Context())
+	if err != nil {
+		log.Printf("Error deleting expired OIDC login codes: %s", err)
+	}
+	err = cfg.dbQueries.CreateOIDCLoginState(req.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		DeviceName:   req.URL.Query().Get("device_name"),
+		ExpiresAt:    time.Now().UTC().Add(oidcLoginTTL),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    auth.HashToken(state),
+		Path:     "/api/login/oidc",
+		MaxAge:   int(oidcLoginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, req, cfg.oidc.AuthCodeURL(state, nonce, oidc.CodeChallenge(verifier)), 302)
+}
+
+// handlerOIDCCallback finishes a single sign-on. Tokens don't belong in a
+// page the browser navigated to, so it sends the browser on to the frontend
+// with a short-lived code, which the frontend trades for tokens at POST
+// /api/login/oidc/token.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	// The cookie has done its job either way.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/api/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	if providerErr := query.Get("error"); providerErr != "" {
+		log.Printf("Identity provider refused the sign-in in request %s: %s %s", w.Header().Get(requestIDHeader), providerErr, query.Get("error_description"))
+		respondWithError(w, 401, "The identity provider refused the sign-in")
+		return
+	}
+
+	stateHash := auth.HashToken(query.Get("state"))
+	cookie, err := req.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash)) != 1 {
+		respondWithError(w, 400, "Sign-in wasn't started in this browser, start again")
+		return
+	}
+
+	// The state row is deleted as it's read, so each attempt can only be
+	// completed once.
+	loginState, err := cfg.dbQueries.UseOIDCLoginState(req.Context(), stateHash)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && time.Now().After(loginState.ExpiresAt)) {
+		respondWithError(w, 400, "Unknown or expired sign-in attempt, start again")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	idToken, err := cfg.oidc.Exchange(req.Context(), query.Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("Error finishing OIDC login in request %s: %s", w.Header().Get(requestIDHeader), err)
+		respondWithError(w, 401, "The identity provider didn't confirm who you are")
+		return
+	}
+
+	dbUser, err := cfg.userForIdentity(req, idToken)
+	if errors.Is(err, errEmailNotVerifiedByProvider) {
+		respondWithError(w, 403, fmt.Sprintf("%s", err))
+		return
+	}
+	if errors.Is(err, errEmailNotVerifiedHere) {
+		respondWithError(w, 409, fmt.Sprintf("%s", err))
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	err = cfg.dbQueries.CreateOIDCLoginCode(req.Context(), database.CreateOIDCLoginCodeParams{
+		CodeHash:   auth.HashToken(code),
+		UserID:     dbUser.ID,
+		DeviceName: loginState.DeviceName,
+		ExpiresAt:  time.Now().UTC().Add(oidcCodeTTL),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	redirect := *cfg.oidcFrontendURL
+	redirectQuery := redirect.Query()
+	redirectQuery.Set("code", code)
+	redirect.RawQuery = redirectQuery.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, req, redirect.String(), 302)
+}
+
+// handlerOIDCToken trades the code a single sign-on ended with for tokens.
+// It answers like POST /api/login: tokens, or an MFA challenge for users
+// with two-factor authentication on. A code only works once.
+func (cfg *apiConfig) handlerOIDCToken(w http.ResponseWriter, req *http.Request) {
+	type tokenRequest struct {
+		Code string `json:"code"`
+	}
+
+	post := tokenRequest{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	login, err := cfg.dbQueries.UseOIDCLoginCode(req.Context(), auth.HashToken(post.Code))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Invalid or expired sign-in code")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), login.UserID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	mfa, err := cfg.requiresMFA(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if mfa {
+		cfg.respondWithMFAChallenge(w, dbUser)
+		return
+	}
+
+	cfg.startSession(w, req, dbUser, login.DeviceName, "oidc")
+}
+
+// userForIdentity finds the chirpy user behind an external identity. An
+// identity seen before maps to the user it was linked to. A new one is
+// linked by email, which both sides must have verified: otherwise whoever
+// controls an unverified address on one side could take over the account on
+// the other. Without a matching account, one is created; it has no password
+// until the user sets one through a password reset.
+func (cfg *apiConfig) userForIdentity(req *http.Request, idToken oidc.IDToken) (database.User, error) {
+	ctx := req.Context()
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userID, err := qtx.GetUserByIdentity(ctx, database.GetUserByIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	})
+	if err == nil {
+		dbUser, err := qtx.GetUserByID(ctx, userID)
+		if err != nil {
+			return database.User{}, err
+		}
+		return dbUser, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errEmailNotVerifiedByProvider
+	}
+	dbUser, err := qtx.GetUserByEmail(ctx, idToken.Email)
+	created := errors.Is(err, sql.ErrNoRows)
+	if created {
+		dbUser, err = qtx.CreateExternalUser(ctx, idToken.Email)
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	if !dbUser.EmailVerifiedAt.Valid {
+		return database.User{}, errEmailNotVerifiedHere
+	}
+
+	err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		UserID:  dbUser.ID,
+		Email:   idToken.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	cfg.audit(ctx, req, auditIdentityLinked, dbUser.ID, dbUser.ID, map[string]any{
+		"issuer":       idToken.Issuer,
+		"subject":      idToken.Subject,
+		"email":        idToken.Email,
+		"user_created": created,
+	})
+	return dbUser, nil
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/passkey"
+)
+
+const webauthnCeremonyTTL = 5 * time.Minute
+
+// WebAuthn ceremony kinds. A ceremony can only be finished by the endpoint
+// of its kind.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonySecondFactor = "second_factor"
+	ceremonyReauth       = "reauth"
+)
+
+type passkeyResponse struct {
+	ID             uuid.UUID  `json:"id"`
+	Name           string     `json:"name"`
+	Transports     []string   `json:"transports"`
+	BackupEligible bool       `json:"backup_eligible"`
+	BackupState    bool       `json:"backup_state"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+func newPasskeyResponse(key database.Passkey) passkeyResponse {
+	return passkeyResponse{
+		ID:             key.ID,
+		Name:           key.Name,
+		Transports:     key.Transports,
+		BackupEligible: key.BackupEligible,
+		BackupState:    key.BackupState,
+		CreatedAt:      key.CreatedAt,
+		LastUsedAt:     nullTime(key.LastUsedAt),
+	}
+}
+
+// ceremonyResponse carries the options for navigator.credentials.create()
+// or get(). The ceremony ID goes back with the authenticator's answer.
+type ceremonyResponse struct {
+	CeremonyID uuid.UUID `json:"ceremony_id"`
+	Options    any       `json:"options"`
+}
+
+func (cfg *apiConfig) passkeyUser(ctx context.Context, dbUser database.User) (passkey.User, error) {
+	keys, err := cfg.dbQueries.ListPasskeys(ctx, dbUser.ID)
+	if err != nil {
+		return passkey.User{}, err
+	}
+	user := passkey.User{ID: dbUser.ID, Name: dbUser.Email}
+	for _, key := range keys {
+		user.Credentials = append(user.Credentials, passkey.Credential{
+			ID:              key.CredentialID,
+			PublicKey:       key.PublicKey,
+			AttestationType: key.AttestationType,
+			AAGUID:          key.Aaguid,
+			SignCount:       uint32(key.SignCount),
+			Transports:      key.Transports,
+			BackupEligible:  key.BackupEligible,
+			BackupState:     key.BackupState,
+		})
+	}
+	return user, nil
+}
+
+// beginCeremony keeps a ceremony's state until it's finished, and responds
+// with the options for the browser. userID is uuid.Nil for a passwordless
+// login, where the user isn't known yet.
+func (cfg *apiConfig) beginCeremony(w http.ResponseWriter, req *http.Request, kind string, userID uuid.UUID, options any, state []byte) {
+	err := cfg.dbQueries.DeleteExpiredWebAuthnCeremonies(req.Context())
+	if err != nil {
+		log.Printf("Error deleting expired WebAuthn ceremonies: %s", err)
+	}
+	id := uuid.New()
+	err = cfg.dbQueries.CreateWebAuthnCeremony(req.Context(), database.CreateWebAuthnCeremonyParams{
+		ID:        id,
+		Kind:      kind,
+		UserID:    uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+		State:     state,
+		ExpiresAt: time.Now().UTC().Add(webauthnCeremonyTTL),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	respondWithJSON(w, 200, ceremonyResponse{CeremonyID: id, Options: options})
+}
+
+// finishCeremony takes back a ceremony's state. It's deleted as it's read,
+// so each ceremony can be finished once.
+func (cfg *apiConfig) finishCeremony(w http.ResponseWriter, req *http.Request, ceremonyID uuid.UUID, kind string, userID uuid.UUID) ([]byte, bool) {
+	state, err := cfg.dbQueries.UseWebAuthnCeremony(req.Context(), database.UseWebAuthnCeremonyParams{
+		ID:     ceremonyID,
+		Kind:   kind,
+		UserID: uuid.NullUUID{UUID: userID, Valid: userID != uuid.Nil},
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Unknown or expired ceremony, start again")
+		return nil, false
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return nil, false
+	}
+	return state, true
+}
+
+// recordPasskeyUse stores the passkey's new signature counter, which the next
+// sign-in with it has to exceed.
+func (cfg *apiConfig) recordPasskeyUse(ctx context.Context, credential passkey.Credential) error {
+	return cfg.dbQueries.UsePasskey(ctx, database.UsePasskeyParams{
+		CredentialID: credential.ID,
+		SignCount:    int64(credential.SignCount),
+		BackupState:  credential.BackupState,
+	})
+}
+
+// respondWithPasskeyError answers a failed sign-in with a passkey. A
+// counter that went backwards is also audited, as it can mean the passkey
+// has been copied.
+func (cfg *apiConfig) respondWithPasskeyError(w http.ResponseWriter, req *http.Request, userID uuid.UUID, err error) {
+	if errors.Is(err, passkey.ErrCloned) {
+		cfg.audit(req.Context(), req, auditPasskeyCloned, uuid.Nil, userID, nil)
+	}
+	if !respondWithPasskeyCheck(w, 401, err) {
+		respondWithInternalError(w, err)
+	}
+}
+
+// respondWithPasskeyCheck responds to a passkey that failed a check with a
+// fixed message for the check, and returns false for any other error. What
+// the WebAuthn library said is only logged, with the request ID, since it
+// says more about the check than the client needs to know.
+func respondWithPasskeyCheck(w http.ResponseWriter, status int, err error) bool {
+	var code, detail string
+	switch {
+	case errors.Is(err, passkey.ErrCloned):
+		code, detail = "passkey_cloned", "Passkey's signature counter went backwards, it may have been copied"
+	case errors.Is(err, passkey.ErrExpired):
+		code, detail = "passkey_expired", "Passkey ceremony has expired, start it again"
+	case errors.Is(err, passkey.ErrVerification):
+		code, detail = "passkey_invalid", "Passkey couldn't be verified"
+	default:
+		return false
+	}
+	log.Printf("Passkey check failed in request %s: %s", w.Header().Get(requestIDHeader), err)
+	respondWithCode(w, status, code, detail)
+	return true
+}
+
+func (cfg *apiConfig) handlerBeginPasskeyRegistration(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	creation, state, err := cfg.passkeys.BeginRegistration(user)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.beginCeremony(w, req, ceremonyRegistration, userID, creation, state)
+}
+
+// handlerCreatePasskey finishes registration with the authenticator's answer
+// to the options from handlerBeginPasskeyRegistration.
+func (cfg *apiConfig) handlerCreatePasskey(w http.ResponseWriter, req *http.Request) {
+	type passkeyPost struct {
+		CeremonyID uuid.UUID       `json:"ceremony_id"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	post := passkeyPost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if post.Name == "" {
+		post.Name = "Passkey"
+	}
+	if len(post.Name) > 100 {
+		respondWithError(w, 400, "Name is too long")
+		return
+	}
+
+	state, ok := cfg.finishCeremony(w, req, post.CeremonyID, ceremonyRegistration, userID)
+	if !ok {
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	credential, err := cfg.passkeys.FinishRegistration(user, state, post.Credential)
+	if err != nil {
+		if !respondWithPasskeyCheck(w, 400, err) {
+			respondWithInternalError(w, err)
+		}
+		return
+	}
+
+	key, err := cfg.dbQueries.CreatePasskey(req.Context(), database.CreatePasskeyParams{
+		UserID:          userID,
+		Name:            post.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Aaguid:          credential.AAGUID,
+		SignCount:       int64(credential.SignCount),
+		Transports:      credential.Transports,
+		BackupEligible:  credential.BackupEligible,
+		BackupState:     credential.BackupState,
+	})
+	if isUniqueViolation(err) {
+		respondWithError(w, 409, "This passkey is already registered")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditPasskeyAdded, userID, userID, map[string]any{
+		"passkey_id": key.ID,
+		"name":       key.Name,
+	})
+	respondWithJSON(w, 201, newPasskeyResponse(key))
+}
+
+func (cfg *apiConfig) handlerListPasskeys(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	keys, err := cfg.dbQueries.ListPasskeys(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []passkeyResponse{}
+	for _, key := range keys {
+		resp = append(resp, newPasskeyResponse(key))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerDeletePasskey removes one of the user's passkeys. A passkey can be
+// all that stands between a password and an account, so removing one takes
+// the password or a reauth token, not just an access token.
+func (cfg *apiConfig) handlerDeletePasskey(w http.ResponseWriter, req *http.Request) {
+	type passkeyDelete struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	passkeyID, err := uuid.Parse(req.PathValue("passkeyID"))
+	if err != nil {
+		respondWithError(w, 400, "Invalid passkey ID")
+		return
+	}
+	post := passkeyDelete{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !cfg.checkReauth(w, req, dbUser, post.Password, post.ReauthToken) {
+		return
+	}
+	deleted, err := cfg.dbQueries.DeletePasskey(req.Context(), database.DeletePasskeyParams{
+		ID:     passkeyID,
+		UserID: userID,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Passkey not found")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditPasskeyRemoved, userID, userID, map[string]any{
+		"passkey_id": passkeyID,
+	})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handlerBeginPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	assertion, state, err := cfg.passkeys.BeginLogin()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	cfg.beginCeremony(w, req, ceremonyLogin, uuid.Nil, assertion, state)
+}
+
+// handlerPasskeyLogin signs a user in with a passkey alone. The passkey's
+// user verification already makes it two factors, so users with two-factor
+// authentication on get tokens without an MFA challenge.
+func (cfg *apiConfig) handlerPasskeyLogin(w http.ResponseWriter, req *http.Request) {
+	type passkeyLogin struct {
+		CeremonyID uuid.UUID       `json:"ceremony_id"`
+		Credential json.RawMessage `json:"credential"`
+		DeviceName string          `json:"device_name"`
+	}
+
+	post := passkeyLogin{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	state, ok := cfg.finishCeremony(w, req, post.CeremonyID, ceremonyLogin, uuid.Nil)
+	if !ok {
+		return
+	}
+
+	// Lookup failures other than an unknown user are ours, not the
+	// client's, so they're kept aside from the verification error they'd
+	// otherwise turn into.
+	var dbUser database.User
+	var lookupErr error
+	_, credential, err := cfg.passkeys.FinishLogin(state, post.Credential, func(userID uuid.UUID) (passkey.User, error) {
+		dbUser, lookupErr = cfg.dbQueries.GetUserByID(req.Context(), userID)
+		if errors.Is(lookupErr, sql.ErrNoRows) {
+			lookupErr = nil
+			return passkey.User{}, passkey.ErrUnknownPasskey
+		}
+		if lookupErr != nil {
+			return passkey.User{}, lookupErr
+		}
+		user, err := cfg.passkeyUser(req.Context(), dbUser)
+		lookupErr = err
+		return user, err
+	})
+	if lookupErr != nil {
+		respondWithInternalError(w, lookupErr)
+		return
+	}
+	if err != nil {
+		cfg.respondWithPasskeyError(w, req, dbUser.ID, err)
+		return
+	}
+	err = cfg.recordPasskeyUse(req.Context(), credential)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.startSession(w, req, dbUser, post.DeviceName, "passkey")
+}
+
+// handlerBeginPasskeyMFA offers the user's passkeys as the second factor
+// after a password, in place of an authenticator code.
+func (cfg *apiConfig) handlerBeginPasskeyMFA(w http.ResponseWriter, req *http.Request) {
+	type mfaBegin struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	post := mfaBegin{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	userID, err := cfg.validateMFAToken(req, post.MFAToken)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	assertion, state, err := cfg.passkeys.BeginSecondFactor(user)
+	if errors.Is(err, passkey.ErrNoCredentials) {
+		respondWithError(w, 400, "You have no passkeys")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.beginCeremony(w, req, ceremonySecondFactor, userID, assertion, state)
+}
+
+func (cfg *apiConfig) handlerPasskeyMFA(w http.ResponseWriter, req *http.Request) {
+	type mfaPasskey struct {
+		MFAToken   string          `json:"mfa_token"`
+		CeremonyID uuid.UUID       `json:"ceremony_id"`
+		Credential json.RawMessage `json:"credential"`
+		DeviceName string          `json:"device_name"`
+	}
+
+	post := mfaPasskey{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	userID, err := cfg.validateMFAToken(req, post.MFAToken)
+	if err != nil {
+		respondWithError(w, 401, "Invalid or expired MFA token")
+		return
+	}
+	state, ok := cfg.finishCeremony(w, req, post.CeremonyID, ceremonySecondFactor, userID)
+	if !ok {
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	credential, err := cfg.passkeys.FinishSecondFactor(user, state, post.Credential)
+	if err != nil {
+		cfg.respondWithPasskeyError(w, req, userID, err)
+		return
+	}
+	err = cfg.recordPasskeyUse(req.Context(), credential)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !cfg.useMFAToken(w, req, post.MFAToken) {
+		return
+	}
+
+	cfg.startSession(w, req, dbUser, post.DeviceName, "mfa_passkey")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+)
+
+// Reset requests are throttled per email and per client address, the way
+// failed logins are, so the endpoint can't be used to flood an inbox.
+var (
+	resetEmailThrottle = auth.LockoutPolicy{FreeAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+	resetIPThrottle    = auth.LockoutPolicy{FreeAttempts: 20, BaseDelay: time.Minute, MaxDelay: time.Hour}
+)
+
+// passwordResetResendAfter is how long after a reset mail a new request
+// doesn't send another one.
+const passwordResetResendAfter = 2 * time.Minute
+
+// handlerRequestPasswordReset always answers 202, and the lookup and the mail
+// happen after the response has gone out, so neither the status nor the
+// response time says whether the address has an account. Too many requests
+// for an address or from a client get a 429, whether the address has an
+// account or not.
+func (cfg *apiConfig) handlerRequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	type resetRequest struct {
+		Email string `json:"email"`
+	}
+
+	post := resetRequest{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	counters := []struct {
+		subject string
+		policy  auth.LockoutPolicy
+	}{
+		{subject: "reset:" + accountSubject(post.Email), policy: resetEmailThrottle},
+		{subject: "reset:" + ipSubject(req), policy: resetIPThrottle},
+	}
+	lockedUntil, err := cfg.lockedUntil(req.Context(), counters[0].subject, counters[1].subject)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		respondLocked(w, lockedUntil, "reset_throttled", "Too many password reset requests, try again later")
+		return
+	}
+	for _, counter := range counters {
+		_, _, err := cfg.countAttempt(req.Context(), counter.subject, counter.policy)
+		if err != nil {
+			log.Printf("Error counting password reset request: %s", err)
+		}
+	}
+
+	go cfg.sendPasswordReset(post.Email)
+
+	w.WriteHeader(202)
+}
+
+func (cfg *apiConfig) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if err != nil {
+		return
+	}
+	// The mail that was just sent is still on its way.
+	recent, err := cfg.dbQueries.HasRecentPasswordReset(ctx, database.HasRecentPasswordResetParams{
+		UserID:        user.ID,
+		WithinSeconds: passwordResetResendAfter.Seconds(),
+	})
+	if err != nil {
+		log.Printf("Error checking for recent password resets: %s", err)
+		return
+	}
+	if recent {
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error creating password reset token: %s", err)
+		return
+	}
+	err = cfg.dbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(cfg.passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving password reset token: %s", err)
+		return
+	}
+
+	err = cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"To choose a new password, open this link within %s:\n\n%s/reset-password?token=%s\n\n"+
+			"If this wasn't you, you can ignore this email.\n",
+			cfg.passwordResetTTL, cfg.baseURL, token),
+	})
+	if err != nil {
+		log.Printf("Error sending password reset mail: %s", err)
+	}
+}
+
+// handlerConfirmPasswordReset sets a new password with a reset token. The
+// token only works once, and every session, API key and app authorization
+// of the account is revoked, since a reset usually means somebody else may
+// know the old password and could have used it to get those.
+func (cfg *apiConfig) handlerConfirmPasswordReset(w http.ResponseWriter, req *http.Request) {
+	type resetConfirm struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	post := resetConfirm{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	userID, err := qtx.UsePasswordResetToken(req.Context(), auth.HashToken(post.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 400, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	// Checked once the token has named the account, so the email can be
+	// ruled out too. A rejected password rolls back and leaves the token
+	// usable for another try.
+	user, err := qtx.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !cfg.checkPasswordPolicy(w, post.Password, user.Email) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(post.Password, cfg.argon2)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = qtx.UpdatePassword(req.Context(), database.UpdatePasswordParams{
+		ID:             userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	err = qtx.InvalidatePasswordResetTokens(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	err = revokeCredentials(req.Context(), qtx, userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditPasswordReset, userID, userID, map[string]any{})
+	w.WriteHeader(204)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/passkey"
+)
+
+// reauthTokenTTL is how long a user has to make the change they confirmed
+// who they are for.
+const reauthTokenTTL = 5 * time.Minute
+
+type reauthResponse struct {
+	ReauthToken string `json:"reauth_token"`
+}
+
+// reauthJWT is the token config for reauth tokens. Like MFA challenge tokens,
+// they carry their own audience, so they can't stand in for access tokens.
+func (cfg *apiConfig) reauthJWT() auth.JWTConfig {
+	config := cfg.jwt
+	config.Audience = cfg.jwt.Audience + "/reauth"
+	return config
+}
+
+// handlerBeginPasskeyReauth offers the user's passkeys for confirming who
+// they are, the same way they're offered as a second factor.
+func (cfg *apiConfig) handlerBeginPasskeyReauth(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	assertion, state, err := cfg.passkeys.BeginSecondFactor(user)
+	if errors.Is(err, passkey.ErrNoCredentials) {
+		respondWithError(w, 400, "You have no passkeys")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.beginCeremony(w, req, ceremonyReauth, userID, assertion, state)
+}
+
+// handlerReauth confirms who the user is, with their password, a code from
+// their authenticator app, a recovery code, a passkey or the code from a
+// fresh sign-in with their identity provider, and answers with a reauth
+// token. Changes that a stolen access token mustn't be enough for
+// take one in place of the password, which is how users without a password
+// make them. Failures count towards the login lockout.
+func (cfg *apiConfig) handlerReauth(w http.ResponseWriter, req *http.Request) {
+	type reauthRequest struct {
+		Password     string          `json:"password"`
+		Code         string          `json:"code"`
+		RecoveryCode string          `json:"recovery_code"`
+		CeremonyID   uuid.UUID       `json:"ceremony_id"`
+		Credential   json.RawMessage `json:"credential"`
+		OIDCCode     string          `json:"oidc_code"`
+	}
+
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	post := reauthRequest{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if post.Password == "" && post.Code == "" && post.RecoveryCode == "" && post.CeremonyID == uuid.Nil && post.OIDCCode == "" {
+		errs := fieldErrors{}
+		errs.add("password", "Give your password, a code, a recovery code, a passkey or a sign-in code")
+		errs.check(w)
+		return
+	}
+
+	dbUser, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if !cfg.checkLoginLockout(w, req, dbUser.Email) {
+		return
+	}
+
+	method := "password"
+	switch {
+	case post.Password != "":
+		err = auth.CheckPasswordHash(post.Password, dbUser.HashedPassword)
+		if err != nil {
+			cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+			respondWithCode(w, 403, "incorrect_password", "Incorrect password")
+			return
+		}
+	case post.OIDCCode != "":
+		method = "oidc"
+		if !cfg.checkOIDCReauth(w, req, dbUser, post.OIDCCode) {
+			return
+		}
+	case post.CeremonyID != uuid.Nil:
+		method = "passkey"
+		if !cfg.checkPasskeyReauth(w, req, dbUser, post.CeremonyID, post.Credential) {
+			return
+		}
+	default:
+		method = "mfa_code"
+		ok, err := cfg.checkSecondFactor(req.Context(), dbUser, post.Code, post.RecoveryCode)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+		if !ok {
+			cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+			respondWithError(w, 403, "Incorrect code")
+			return
+		}
+	}
+	cfg.clearLoginFailures(req, dbUser.Email)
+
+	cfg.respondWithReauthToken(w, req, dbUser, method)
+}
+
+// checkPasskeyReauth finishes a passkey ceremony begun for reauthentication.
+// It responds and returns false when the passkey doesn't check out.
+func (cfg *apiConfig) checkPasskeyReauth(w http.ResponseWriter, req *http.Request, dbUser database.User, ceremonyID uuid.UUID, response json.RawMessage) bool {
+	state, ok := cfg.finishCeremony(w, req, ceremonyID, ceremonyReauth, dbUser.ID)
+	if !ok {
+		return false
+	}
+	user, err := cfg.passkeyUser(req.Context(), dbUser)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	credential, err := cfg.passkeys.FinishSecondFactor(user, state, response)
+	if err != nil {
+		cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+		if errors.Is(err, passkey.ErrCloned) {
+			cfg.audit(req.Context(), req, auditPasskeyCloned, uuid.Nil, dbUser.ID, nil)
+		}
+		if !respondWithPasskeyCheck(w, 403, err) {
+			respondWithInternalError(w, err)
+		}
+		return false
+	}
+	err = cfg.recordPasskeyUse(req.Context(), credential)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	return true
+}
+
+// checkOIDCReauth spends the code a fresh sign-in with an identity provider
+// redirected back with, in place of exchanging it at POST
+// /api/login/oidc/token. It has to be the same user's sign-in. It responds
+// and returns false when it isn't.
+func (cfg *apiConfig) checkOIDCReauth(w http.ResponseWriter, req *http.Request, dbUser database.User, code string) bool {
+	login, err := cfg.dbQueries.UseOIDCLoginCode(req.Context(), auth.HashToken(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 403, "Invalid or expired sign-in code")
+		return false
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return false
+	}
+	if login.UserID != dbUser.ID {
+		cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+		respondWithError(w, 403, "Sign-in code belongs to a different account")
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) respondWithReauthToken(w http.ResponseWriter, req *http.Request, dbUser database.User, method string) {
+	token, err := auth.MakeJWT(dbUser.ID, dbUser.TokenVersion, cfg.reauthJWT(), reauthTokenTTL)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	cfg.audit(req.Context(), req, auditReauthenticated, dbUser.ID, dbUser.ID, map[string]any{
+		"method": method,
+	})
+	respondWithJSON(w, 200, reauthResponse{ReauthToken: token})
+}
+
+// checkReauth guards changes that a stolen access token mustn't be enough
+// for. It takes the user's password, which counts towards the login lockout
+// when it's wrong, or a reauth token from POST /api/users/me/reauth, which
+// only works once. It responds and returns false when neither is good.
+func (cfg *apiConfig) checkReauth(w http.ResponseWriter, req *http.Request, dbUser database.User, password, reauthToken string) bool {
+	if reauthToken != "" {
+		userID, err := auth.ValidateJWT(reauthToken, cfg.reauthJWT(), func(userID uuid.UUID) (int32, error) {
+			return cfg.dbQueries.GetTokenVersion(req.Context(), userID)
+		})
+		if err != nil || userID != dbUser.ID {
+			respondWithCode(w, 403, "reauth_required", "Reauth token is invalid or expired, confirm it's you again")
+			return false
+		}
+		fresh, err := cfg.useToken(req.Context(), reauthToken, reauthTokenTTL)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return false
+		}
+		if !fresh {
+			respondWithCode(w, 403, "reauth_required", "Reauth token has already been used, confirm it's you again")
+			return false
+		}
+		return true
+	}
+	if password == "" {
+		respondWithCode(w, 403, "reauth_required", "Confirm it's you with your password or a reauth token")
+		return false
+	}
+
+	if !cfg.checkLoginLockout(w, req, dbUser.Email) {
+		return false
+	}
+	err := auth.CheckPasswordHash(password, dbUser.HashedPassword)
+	if err != nil {
+		cfg.recordLoginFailure(req, dbUser.Email, dbUser.ID)
+		respondWithCode(w, 403, "incorrect_password", "Incorrect password")
+		return false
+	}
+	cfg.clearLoginFailures(req, dbUser.Email)
+	return true
+}
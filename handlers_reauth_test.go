@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+func TestReauthPendingTOTP(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	// A secret that was never confirmed is what enrolling leaves behind, and
+	// enrolling used to take nothing but an access token.
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = cfg.dbQueries.SetTOTPSecret(t.Context(), database.SetTOTPSecretParams{
+		ID:         user.ID,
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+	})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	rec := serve(cfg.handlerReauth, "POST", "/api/users/me/reauth", token, `{"code": "`+code+`"}`)
+	if rec.Code != 403 {
+		t.Errorf("Accepted a code from a pending enrollment: Got %d %s", rec.Code, rec.Body)
+	}
+	_, err = cfg.dbQueries.ClearLoginFailures(t.Context(), accountSubject(user.Email))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+}
+
+func TestEnrollTOTPNeedsReauth(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	rec := serve(cfg.handlerEnrollTOTP, "POST", "/api/users/me/totp", token, `{}`)
+	if rec.Code != 403 || decodeProblem(t, rec).Code != "reauth_required" {
+		t.Errorf("Enrolled without the password: Got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(cfg.handlerEnrollTOTP, "POST", "/api/users/me/totp", token, `{"password": "correct horse battery staple"}`)
+	if rec.Code != 201 {
+		t.Errorf("Didn't enroll with the password: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestCheckSecondFactorPendingTOTP needs no database: a pending secret has
+// to be turned away before anything is looked up.
+func TestCheckSecondFactorPendingTOTP(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	cfg := &apiConfig{}
+	user := database.User{TotpSecret: sql.NullString{String: secret, Valid: true}}
+	ok, err := cfg.checkSecondFactor(t.Context(), user, code, "")
+	if err != nil || ok {
+		t.Errorf("Accepted a code from a pending enrollment: Got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+)
+
+// Reasons a chirp or user can be reported for.
+var reportReasons = []string{
+	"spam",
+	"harassment",
+	"hate",
+	"violence",
+	"sexual_content",
+	"self_harm",
+	"misinformation",
+	"impersonation",
+	"other",
+}
+
+// Ways a moderator can resolve a report.
+const (
+	reportActionDismiss       = "dismiss"
+	reportActionHideChirp     = "hide_chirp"
+	reportActionSuspendAuthor = "suspend_author"
+)
+
+const (
+	reportTargetChirp = "chirp"
+	reportTargetUser  = "user"
+)
+
+const maxReportDetails = 1000
+
+var errReportNotClaimed = errors.New("report isn't claimed by this moderator")
+
+type reportSubmissionResponse struct {
+	ReporterID uuid.UUID `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type reportResponse struct {
+	ID             uuid.UUID                  `json:"id"`
+	CreatedAt      time.Time                  `json:"created_at"`
+	UpdatedAt      time.Time                  `json:"updated_at"`
+	TargetType     string                     `json:"target_type"`
+	TargetID       uuid.UUID                  `json:"target_id"`
+	TargetUserID   uuid.UUID                  `json:"target_user_id"`
+	Content        string                     `json:"content,omitempty"`
+	Status         string                     `json:"status"`
+	ClaimedBy      *uuid.UUID                 `json:"claimed_by"`
+	ClaimedAt      *time.Time                 `json:"claimed_at"`
+	ResolvedBy     *uuid.UUID                 `json:"resolved_by"`
+	ResolvedAt     *time.Time                 `json:"resolved_at"`
+	Action         string                     `json:"action,omitempty"`
+	ResolutionNote string                     `json:"resolution_note,omitempty"`
+	Reporters      int64                      `json:"reporters"`
+	Reasons        []string                   `json:"reasons"`
+	Submissions    []reportSubmissionResponse `json:"submissions,omitempty"`
+}
+
+func newReportResponse(report database.Report) reportResponse {
+	return reportResponse{
+		ID:             report.ID,
+		CreatedAt:      report.CreatedAt,
+		UpdatedAt:      report.UpdatedAt,
+		TargetType:     report.TargetType,
+		TargetID:       report.TargetID,
+		TargetUserID:   report.TargetUserID,
+		Content:        report.Content,
+		Status:         report.Status,
+		ClaimedBy:      nullUUID(report.ClaimedBy),
+		ClaimedAt:      nullTime(report.ClaimedAt),
+		ResolvedBy:     nullUUID(report.ResolvedBy),
+		ResolvedAt:     nullTime(report.ResolvedAt),
+		Action:         report.Action,
+		ResolutionNote: report.ResolutionNote,
+	}
+}
+
+func nullUUID(id uuid.NullUUID) *uuid.UUID {
+	if !id.Valid {
+		return nil
+	}
+	return &id.UUID
+}
+
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, req *http.Request) {
+	reporterID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	chirpID, err := uuid.Parse(req.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	chirp, err := cfg.dbQueries.GetSpecificChirp(req.Context(), chirpID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && chirp.HiddenAt.Valid) {
+		respondWithError(w, 404, "Chirp not found")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if chirp.UserID == reporterID {
+		respondWithError(w, 400, "You can't report your own chirp")
+		return
+	}
+
+	cfg.fileReport(w, req, reporterID, database.OpenReportParams{
+		TargetType:   reportTargetChirp,
+		TargetID:     chirp.ID,
+		TargetUserID: chirp.UserID,
+		Content:      chirp.Body,
+	})
+}
+
+func (cfg *apiConfig) handlerReportUser(w http.ResponseWriter, req *http.Request) {
+	reporterID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	userID, err := uuid.Parse(req.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	user, err := cfg.dbQueries.GetUserByID(req.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if user.ID == reporterID {
+		respondWithError(w, 400, "You can't report yourself")
+		return
+	}
+
+	cfg.fileReport(w, req, reporterID, database.OpenReportParams{
+		TargetType:   reportTargetUser,
+		TargetID:     user.ID,
+		TargetUserID: user.ID,
+	})
+}
+
+// fileReport adds the reporter to the unresolved report on target, opening
+// one if there is none, so moderators see each target once however many
+// people flag it. Reporting the same target twice changes nothing. The
+// content of a chirp is kept with the report in case the chirp is deleted.
+func (cfg *apiConfig) fileReport(w http.ResponseWriter, req *http.Request, reporterID uuid.UUID, target database.OpenReportParams) {
+	type reportPost struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	post := reportPost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+	if !slices.Contains(reportReasons, post.Reason) {
+		respondWithError(w, 400, fmt.Sprintf("reason must be one of %s", strings.Join(reportReasons, ", ")))
+		return
+	}
+	if len(post.Details) > maxReportDetails {
+		respondWithError(w, 400, fmt.Sprintf("details can be at most %d characters", maxReportDetails))
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	reportID, err := qtx.OpenReport(req.Context(), target)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	_, err = qtx.AddReportSubmission(req.Context(), database.AddReportSubmissionParams{
+		ReportID:   reportID,
+		ReporterID: reporterID,
+		Reason:     post.Reason,
+		Details:    post.Details,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(202)
+}
+
+// handlerListReports is the moderation queue: unresolved reports, those
+// with the most reporters first. The status query parameter takes a comma
+// separated list of statuses to show instead.
+func (cfg *apiConfig) handlerListReports(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	limit, offset, ok := parsePage(w, query)
+	if !ok {
+		return
+	}
+	statuses := []string{"open", "claimed"}
+	if status := query.Get("status"); status != "" {
+		statuses = strings.Split(status, ",")
+		for _, s := range statuses {
+			if s != "open" && s != "claimed" && s != "resolved" {
+				respondWithError(w, 400, "status must be open, claimed or resolved")
+				return
+			}
+		}
+	}
+
+	reports, err := cfg.dbQueries.ListReports(req.Context(), database.ListReportsParams{
+		Statuses:   statuses,
+		MaxResults: limit,
+		Skip:       offset,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []reportResponse{}
+	for _, row := range reports {
+		report := newReportResponse(database.Report{
+			ID:             row.ID,
+			CreatedAt:      row.CreatedAt,
+			UpdatedAt:      row.UpdatedAt,
+			TargetType:     row.TargetType,
+			TargetID:       row.TargetID,
+			TargetUserID:   row.TargetUserID,
+			Content:        row.Content,
+			Status:         row.Status,
+			ClaimedBy:      row.ClaimedBy,
+			ClaimedAt:      row.ClaimedAt,
+			ResolvedBy:     row.ResolvedBy,
+			ResolvedAt:     row.ResolvedAt,
+			Action:         row.Action,
+			ResolutionNote: row.ResolutionNote,
+		})
+		report.Reporters = row.Reporters
+		report.Reasons = row.Reasons
+		resp = append(resp, report)
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerGetReport(w http.ResponseWriter, req *http.Request) {
+	report, ok := cfg.pathReport(w, req)
+	if !ok {
+		return
+	}
+	submissions, err := cfg.dbQueries.ListReportSubmissions(req.Context(), report.ID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := newReportResponse(report)
+	resp.Reasons = []string{}
+	for _, submission := range submissions {
+		resp.Submissions = append(resp.Submissions, reportSubmissionResponse{
+			ReporterID: submission.ReporterID,
+			Reason:     submission.Reason,
+			Details:    submission.Details,
+			CreatedAt:  submission.CreatedAt,
+		})
+		if !slices.Contains(resp.Reasons, submission.Reason) {
+			resp.Reasons = append(resp.Reasons, submission.Reason)
+		}
+	}
+	resp.Reporters = int64(len(submissions))
+	respondWithJSON(w, 200, resp)
+}
+
+// handlerClaimReport assigns a report to the moderator, so two moderators
+// don't work on the same one. Claiming a report again is fine.
+func (cfg *apiConfig) handlerClaimReport(w http.ResponseWriter, req *http.Request) {
+	report, ok := cfg.pathReport(w, req)
+	if !ok {
+		return
+	}
+
+	claimed, err := cfg.dbQueries.ClaimReport(req.Context(), database.ClaimReportParams{
+		ID:          report.ID,
+		ModeratorID: actorID(req),
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if claimed == 0 {
+		if report.Status == "resolved" {
+			respondWithError(w, 409, "Report is already resolved")
+			return
+		}
+		respondWithError(w, 409, "Report is claimed by another moderator")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditReportClaimed, actorID(req), report.TargetUserID, map[string]any{
+		"report_id": report.ID,
+	})
+	w.WriteHeader(204)
+}
+
+// handlerResolveReport closes a report the moderator has claimed, taking
+// one of the report actions, and lets the reporters know.
+func (cfg *apiConfig) handlerResolveReport(w http.ResponseWriter, req *http.Request) {
+	type resolvePost struct {
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	post := resolvePost{}
+	if !decodeJSON(w, req, &post) {
+		return
+	}
+
+	report, ok := cfg.pathReport(w, req)
+	if !ok {
+		return
+	}
+
+	var author database.User
+	var err error
+	switch post.Action {
+	case reportActionDismiss:
+	case reportActionHideChirp:
+		if report.TargetType != reportTargetChirp {
+			respondWithError(w, 400, "Only reported chirps can be hidden")
+			return
+		}
+	case reportActionSuspendAuthor:
+		if !actorHasPermission(req, auth.PermissionSuspendUsers) {
+			respondWithCode(w, 403, "permission_denied", "You don't have permission to do this")
+			return
+		}
+		author, err = cfg.dbQueries.GetUserByID(req.Context(), report.TargetUserID)
+		if err != nil {
+			respondWithInternalError(w, err)
+			return
+		}
+		if !checkCanModerate(w, req, author) {
+			return
+		}
+		if author.ID == actorID(req) {
+			respondWithError(w, 400, "You can't suspend your own account")
+			return
+		}
+	default:
+		respondWithError(w, 400, "action must be dismiss, hide_chirp or suspend_author")
+		return
+	}
+
+	reason := fmt.Sprintf("Report %s", report.ID)
+	if post.Note != "" {
+		reason += ": " + post.Note
+	}
+	err = cfg.withAdminGuard(req.Context(), author, func(qtx *database.Queries) error {
+		resolved, err := qtx.ResolveReport(req.Context(), database.ResolveReportParams{
+			ID:          report.ID,
+			ModeratorID: actorID(req),
+			Action:      post.Action,
+			Note:        post.Note,
+		})
+		if err != nil {
+			return err
+		}
+		if resolved == 0 {
+			return errReportNotClaimed
+		}
+
+		switch post.Action {
+		case reportActionHideChirp:
+			// The author may have deleted the chirp in the meantime.
+			_, err = qtx.HideChirp(req.Context(), report.TargetID)
+			return err
+		case reportActionSuspendAuthor:
+			if author.SuspendedAt.Valid {
+				return nil
+			}
+			return suspendUser(req.Context(), qtx, author.ID, reason)
+		}
+		return nil
+	})
+	if errors.Is(err, errReportNotClaimed) {
+		respondWithError(w, 409, "Claim the report before resolving it")
+		return
+	}
+	if errors.Is(err, errLastAdmin) {
+		respondWithError(w, 409, "The last admin can't be suspended")
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditReportResolved, actorID(req), report.TargetUserID, map[string]any{
+		"report_id": report.ID,
+		"action":    post.Action,
+		"note":      post.Note,
+	})
+	if post.Action == reportActionSuspendAuthor && !author.SuspendedAt.Valid {
+		cfg.audit(req.Context(), req, auditUserSuspended, actorID(req), author.ID, map[string]any{
+			"reason": reason,
+		})
+	}
+	go cfg.notifyReporters(report.ID, post.Action)
+
+	w.WriteHeader(204)
+}
+
+// pathReport loads the report named in the path, responding with a 404 and
+// returning false if there's no such report.
+func (cfg *apiConfig) pathReport(w http.ResponseWriter, req *http.Request) (database.Report, bool) {
+	reportID, err := uuid.Parse(req.PathValue("reportID"))
+	if err != nil {
+		respondWithError(w, 404, "Report not found")
+		return database.Report{}, false
+	}
+	report, err := cfg.dbQueries.GetReport(req.Context(), reportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Report not found")
+		return database.Report{}, false
+	}
+	if err != nil {
+		respondWithInternalError(w, err)
+		return database.Report{}, false
+	}
+	return report, true
+}
+
+// notifyReporters tells everyone who filed a report how it turned out. It
+// says whether action was taken but not which, and each reporter is only
+// told once.
+func (cfg *apiConfig) notifyReporters(reportID uuid.UUID, action string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	reporters, err := cfg.dbQueries.ListReportersToNotify(ctx, reportID)
+	if err != nil {
+		log.Printf("Error listing reporters: %s", err)
+		return
+	}
+
+	outcome := "We've reviewed it and taken action. Thank you for helping keep Chirpy safe."
+	if action == reportActionDismiss {
+		outcome = "We've reviewed it and found that it doesn't break our rules, so no action was taken."
+	}
+	for _, reporter := range reporters {
+		err = cfg.mailer.Send(ctx, mailer.Message{
+			To:      reporter.Email,
+			Subject: "An update on your Chirpy report",
+			Body:    fmt.Sprintf("Thanks for reporting content on Chirpy.\n\n%s\n", outcome),
+		})
+		if err != nil {
+			log.Printf("Error sending report outcome mail: %s", err)
+			continue
+		}
+		err = cfg.dbQueries.MarkReporterNotified(ctx, database.MarkReporterNotifiedParams{
+			ReportID:   reportID,
+			ReporterID: reporter.ID,
+		})
+		if err != nil {
+			log.Printf("Error marking reporter notified: %s", err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+// withPathValue sets a path value the way the mux would, for handlers
+// called without one.
+func withPathValue(handler http.HandlerFunc, name, value string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		req.SetPathValue(name, value)
+		handler(w, req)
+	}
+}
+
+func TestReportDedupAndResolve(t *testing.T) {
+	cfg := newTestConfig(t)
+	author := createTestUser(t, cfg, "correct horse battery staple")
+	first := createTestUser(t, cfg, "correct horse battery staple")
+	second := createTestUser(t, cfg, "correct horse battery staple")
+	moderator := createTestUser(t, cfg, "correct horse battery staple")
+	err := cfg.dbQueries.SetUserRole(t.Context(), database.SetUserRoleParams{ID: moderator.ID, Role: auth.RoleModerator})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	moderator.Role = auth.RoleModerator
+	chirp, err := cfg.dbQueries.CreateChirp(t.Context(), database.CreateChirpParams{Body: "reported", UserID: author.ID})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	report := withPathValue(cfg.handlerReportChirp, "chirpID", chirp.ID.String())
+	for _, reporter := range []database.User{first, first, second} {
+		rec := serve(report, "POST", "/api/chirps/"+chirp.ID.String()+"/report", createTestAccessToken(t, cfg, reporter), `{"reason": "spam"}`)
+		if rec.Code != 202 {
+			t.Fatalf("Didn't file the report: Got %d %s", rec.Code, rec.Body)
+		}
+	}
+
+	// However often it's reported, there's one open report per chirp, and
+	// each reporter is on it once.
+	var reportID uuid.UUID
+	err = cfg.db.QueryRowContext(t.Context(), "SELECT id FROM reports WHERE target_id = $1 AND status <> 'resolved'", chirp.ID).Scan(&reportID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	submissions, err := cfg.dbQueries.ListReportSubmissions(t.Context(), reportID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if len(submissions) != 2 {
+		t.Errorf("Didn't dedup the reporters: Got %d submissions, expected 2", len(submissions))
+	}
+
+	token := createTestAccessToken(t, cfg, moderator)
+	target := "/admin/reports/" + reportID.String()
+	resolve := withPathValue(cfg.requirePermission(auth.PermissionModerate, cfg.handlerResolveReport), "reportID", reportID.String())
+	rec := serve(resolve, "POST", target+"/resolve", token, `{"action": "hide_chirp"}`)
+	if rec.Code != 409 {
+		t.Errorf("Resolved an unclaimed report: Got %d %s", rec.Code, rec.Body)
+	}
+
+	claim := withPathValue(cfg.requirePermission(auth.PermissionModerate, cfg.handlerClaimReport), "reportID", reportID.String())
+	rec = serve(claim, "POST", target+"/claim", token, "")
+	if rec.Code != 204 {
+		t.Fatalf("Didn't claim the report: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(resolve, "POST", target+"/resolve", token, `{"action": "hide_chirp", "note": "spam"}`)
+	if rec.Code != 204 {
+		t.Fatalf("Didn't resolve the report: Got %d %s", rec.Code, rec.Body)
+	}
+
+	resolved, err := cfg.dbQueries.GetReport(t.Context(), reportID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if resolved.Status != "resolved" || resolved.Action != reportActionHideChirp || resolved.ResolvedBy.UUID != moderator.ID {
+		t.Errorf("Didn't record the resolution: Got %+v", resolved)
+	}
+	hidden, err := cfg.dbQueries.GetSpecificChirp(t.Context(), chirp.ID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if !hidden.HiddenAt.Valid {
+		t.Errorf("Didn't hide the chirp")
+	}
+
+	// A resolved report is closed for good.
+	rec = serve(claim, "POST", target+"/claim", token, "")
+	if rec.Code != 409 {
+		t.Errorf("Claimed a resolved report: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestListReportsStatus covers the status filter, which is checked before
+// the database is asked.
+func TestListReportsStatus(t *testing.T) {
+	cfg := &apiConfig{}
+	for _, status := range []string{"bogus", "open,bogus", "open,", "Open"} {
+		rec := serve(cfg.handlerListReports, "GET", "/admin/reports?status="+status, "", "")
+		if rec.Code != 400 || decodeProblem(t, rec).Detail != "status must be open, claimed or resolved" {
+			t.Errorf("Didn't reject status %q: Got %d %s", status, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/database"
+)
+
+// A session is one login: the chain of refresh tokens that rotation creates
+// from it all share a family ID, which doubles as the session ID.
+type sessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func newSessionResponse(session database.ListSessionsRow) sessionResponse {
+	return sessionResponse{
+		ID:         session.FamilyID,
+		DeviceName: session.DeviceName,
+		UserAgent:  session.UserAgent,
+		IPAddress:  session.IpAddress,
+		LastUsedAt: session.LastUsedAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+// clientIP returns the address of the peer that sent the request, without
+// the port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (cfg *apiConfig) handlerListSessions(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	sessions, err := cfg.dbQueries.ListSessions(req.Context(), userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	resp := []sessionResponse{}
+	for _, session := range sessions {
+		resp = append(resp, newSessionResponse(session))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handlerRevokeSession(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	sessionID, err := uuid.Parse(req.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokeSession(req.Context(), database.RevokeSessionParams{
+		UserID:   userID,
+		FamilyID: sessionID,
+	})
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Session not found")
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditSessionRevoked, userID, userID, map[string]any{
+		"session_id": sessionID,
+	})
+	w.WriteHeader(204)
+}
+
+// handlerRevokeAllSessions logs the user out everywhere. Revoking the refresh
+// tokens stops new access tokens from being minted, and bumping the token
+// version invalidates the access tokens that are still outstanding.
+func (cfg *apiConfig) handlerRevokeAllSessions(w http.ResponseWriter, req *http.Request) {
+	userID, err := cfg.authenticate(req)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+
+	err = cfg.revokeAllSessions(req, userID)
+	if err != nil {
+		respondWithInternalError(w, err)
+		return
+	}
+
+	cfg.audit(req.Context(), req, auditSessionsRevoked, userID, userID, map[string]any{})
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) revokeAllSessions(req *http.Request, userID uuid.UUID) error {
+	tx, err := cfg.db.BeginTx(req.Context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	err = qtx.RevokeAllSessions(req.Context(), userID)
+	if err != nil {
+		return err
+	}
+	_, err = qtx.IncrementTokenVersion(req.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeCredentials revokes everything that lets a client act as the user:
+// sessions, API keys and app authorizations, plus the access tokens still
+// outstanding.
+func revokeCredentials(ctx context.Context, q *database.Queries, userID uuid.UUID) error {
+	err := q.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = q.RevokeAllAPIKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = q.RevokeAllOAuthGrants(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = q.IncrementTokenVersion(ctx, userID)
+	return err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshRotation(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	first := createTestSession(t, cfg, user.ID, time.Now().Add(time.Hour))
+
+	rec := serve(cfg.handlerRefresh, "POST", "/api/refresh", first, "")
+	if rec.Code != 200 {
+		t.Fatalf("Didn't refresh: Got %d %s", rec.Code, rec.Body)
+	}
+	second := decodeUser(t, rec).RefreshToken
+	if second == "" || second == first {
+		t.Fatalf("Didn't rotate the refresh token: Got %q", second)
+	}
+
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", second, "")
+	if rec.Code != 200 {
+		t.Fatalf("Didn't refresh with the rotated token: Got %d %s", rec.Code, rec.Body)
+	}
+	third := decodeUser(t, rec).RefreshToken
+
+	// Using a token that was already rotated means it leaked, so the whole
+	// session goes, including the newest token.
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", first, "")
+	if rec.Code != 401 || decodeProblem(t, rec).Detail != "Refresh token has already been used" {
+		t.Errorf("Didn't reject the reused token: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", third, "")
+	if rec.Code != 401 {
+		t.Errorf("Didn't revoke the token family: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	expired := createTestSession(t, cfg, user.ID, time.Now().Add(-time.Minute))
+	other := createTestSession(t, cfg, user.ID, time.Now().Add(time.Hour))
+
+	rec := serve(cfg.handlerRefresh, "POST", "/api/refresh", expired, "")
+	if rec.Code != 401 || decodeProblem(t, rec).Detail != "Invalid or expired refresh token" {
+		t.Errorf("Didn't reject the expired token: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", other, "")
+	if rec.Code != 200 {
+		t.Errorf("Expired token affected another session: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateUserOmittedFields(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	for _, body := range []string{`{}`, `{"device_name": "laptop"}`, `{"email": "` + user.Email + `"}`} {
+		rec := serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token, body)
+		if rec.Code != 200 {
+			t.Fatalf("Didn't accept %s: Got %d %s", body, rec.Code, rec.Body)
+		}
+		if got := decodeUser(t, rec); got.Email != user.Email || got.Token != "" {
+			t.Errorf("Didn't leave the user alone for %s: Got %+v", body, got)
+		}
+	}
+}
+
+func TestUpdateUserUnchangedPassword(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+	session := createTestSession(t, cfg, user.ID, time.Now().Add(time.Hour))
+
+	rec := serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token,
+		`{"password": "correct horse battery staple", "current_password": "correct horse battery staple"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Didn't accept the unchanged password: Got %d %s", rec.Code, rec.Body)
+	}
+	after, err := cfg.dbQueries.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if after.HashedPassword != user.HashedPassword || after.TokenVersion != user.TokenVersion {
+		t.Errorf("Rehashed an unchanged password")
+	}
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", session, "")
+	if rec.Code != 200 {
+		t.Errorf("Unchanged password ended a session: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestUpdateUserCurrentPassword(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.accountLockout.FreeAttempts = 0
+	cfg.accountLockout.BaseDelay = time.Minute
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	rec := serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token, `{"password": "a whole new passphrase"}`)
+	if rec.Code != 403 || decodeProblem(t, rec).Code != "reauth_required" {
+		t.Errorf("Didn't ask for the current password: Got %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token,
+		`{"password": "a whole new passphrase", "current_password": "wrong horse battery staple"}`)
+	if rec.Code != 403 || decodeProblem(t, rec).Code != "incorrect_password" {
+		t.Errorf("Didn't reject the wrong current password: Got %d %s", rec.Code, rec.Body)
+	}
+
+	// The wrong guess counts towards the login lockout, so with no free
+	// attempts even the right password is turned away now.
+	rec = serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token,
+		`{"password": "a whole new passphrase", "current_password": "correct horse battery staple"}`)
+	if rec.Code != 429 {
+		t.Errorf("Didn't count the wrong guess towards the lockout: Got %d %s", rec.Code, rec.Body)
+	}
+
+	after, err := cfg.dbQueries.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if after.HashedPassword != user.HashedPassword {
+		t.Errorf("Changed the password without the current one")
+	}
+	_, err = cfg.dbQueries.ClearLoginFailures(t.Context(), accountSubject(user.Email))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+}
+
+func TestUpdateUserEmailTaken(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	other := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	rec := serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token,
+		`{"email": "`+other.Email+`", "password": "a whole new passphrase", "current_password": "correct horse battery staple"}`)
+	if rec.Code != 409 || decodeProblem(t, rec).Code != "email_taken" {
+		t.Fatalf("Didn't reject the taken address: Got %d %s", rec.Code, rec.Body)
+	}
+	after, err := cfg.dbQueries.GetUserByID(t.Context(), user.ID)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if after.HashedPassword != user.HashedPassword || after.TokenVersion != user.TokenVersion {
+		t.Errorf("Changed the password along with a taken address")
+	}
+	if after.PendingEmail.Valid {
+		t.Errorf("Parked a taken address: Got %q", after.PendingEmail.String)
+	}
+}
+
+func TestUpdateUserPasswordRevokesSessions(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+	session := createTestSession(t, cfg, user.ID, time.Now().Add(time.Hour))
+
+	rec := serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token,
+		`{"password": "a whole new passphrase", "current_password": "correct horse battery staple"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Didn't change the password: Got %d %s", rec.Code, rec.Body)
+	}
+	fresh := decodeUser(t, rec)
+	if fresh.Token == "" || fresh.RefreshToken == "" {
+		t.Fatalf("Didn't return a fresh session: Got %+v", fresh)
+	}
+
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", session, "")
+	if rec.Code != 401 {
+		t.Errorf("Didn't revoke the other session: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", token, `{}`)
+	if rec.Code != 401 {
+		t.Errorf("Didn't revoke the old access token: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(cfg.handlerRefresh, "POST", "/api/refresh", fresh.RefreshToken, "")
+	if rec.Code != 200 {
+		t.Errorf("Didn't keep the fresh session: Got %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(cfg.handlerUpdateUser, "PATCH", "/api/users/me", fresh.Token, `{}`)
+	if rec.Code != 200 {
+		t.Errorf("Didn't accept the fresh access token: Got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPutUserDeprecated(t *testing.T) {
+	cfg := newTestConfig(t)
+	user := createTestUser(t, cfg, "correct horse battery staple")
+	token := createTestAccessToken(t, cfg, user)
+
+	rec := serve(cfg.handlerPutUser, "PUT", "/api/users", token, `{}`)
+	if rec.Code != 200 {
+		t.Fatalf("Didn't update through PUT: Got %d %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Deprecation") != putUserDeprecatedAt {
+		t.Errorf("Didn't mark PUT deprecated: Got %q", rec.Header().Get("Deprecation"))
+	}
+	if rec.Header().Get("Link") != `</api/users/me>; rel="successor-version"` {
+		t.Errorf("Didn't point to the successor: Got %q", rec.Header().Get("Link"))
+	}
+}
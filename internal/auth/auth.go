@@ -2,7 +2,9 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,61 +12,199 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Claims are the claims carried by an access token. TokenVersion is the
+// user's token version at the time the token was minted. Role is only set on
+// login access tokens; changing a user's role bumps their token version, so
+// a token never outlives the role it carries.
+type Claims struct {
+	jwt.RegisteredClaims
+	TokenVersion int32  `json:"ver"`
+	Role         string `json:"role,omitempty"`
+}
+
+// TokenVersionFunc looks up a user's current token version. Access tokens
+// minted with any other version are rejected, which lets a user invalidate
+// every outstanding token by bumping the version.
+type TokenVersionFunc func(userID uuid.UUID) (int32, error)
+
+// JWTConfig describes how access tokens are signed and what a valid token
+// has to look like. Leeway is the clock skew tolerated when checking exp, nbf
+// and iat.
+type JWTConfig struct {
+	Keys     *Keyring
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+}
+
+// Errors returned by ValidateJWT. Anything wrapping one of these means the
+// token itself was rejected, as opposed to the version lookup failing.
+var (
+	ErrTokenMalformed   = errors.New("token is malformed")
+	ErrTokenSignature   = errors.New("token signature is invalid")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrTokenClaims      = errors.New("token has invalid claims")
+	ErrTokenRevoked     = errors.New("token has been revoked")
+)
+
+func MakeJWT(userID uuid.UUID, tokenVersion int32, config JWTConfig, expiresIn time.Duration) (string, error) {
+	return MakeAccessToken(userID, tokenVersion, "", config, expiresIn)
+}
+
+// MakeAccessToken is MakeJWT for the access tokens logging in gives, which
+// also carry the user's role.
+func MakeAccessToken(userID uuid.UUID, tokenVersion int32, role string, config JWTConfig, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	tokenString, err := config.Keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    config.Issuer,
+			Audience:  jwt.ClaimStrings{config.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		TokenVersion: tokenVersion,
+		Role:         role,
+	})
 	if err != nil {
 		return "", err
 	}
-	return string(hashedPassword), nil
+	return tokenString, nil
+}
+
+func ValidateJWT(tokenString string, config JWTConfig, currentVersion TokenVersionFunc) (uuid.UUID, error) {
+	claims := Claims{}
+	return parseJWT(tokenString, &claims, &claims, config, currentVersion)
 }
 
-func CheckPasswordHash(password, hash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// ValidateAccessToken validates a token like ValidateJWT does and returns
+// its claims, the role among them.
+func ValidateAccessToken(tokenString string, config JWTConfig, currentVersion TokenVersionFunc) (Claims, error) {
+	claims := Claims{}
+	_, err := parseJWT(tokenString, &claims, &claims, config, currentVersion)
 	if err != nil {
-		return err
+		return Claims{}, err
 	}
-	return nil
+	return claims, nil
+}
+
+// ScopedClaims are the claims of an access token issued to an OAuth client.
+// The token ID is the ID of the grant it was issued under, so revoking the
+// grant revokes every token minted from it.
+type ScopedClaims struct {
+	Claims
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String()})
-	tokenString, err := token.SignedString([]byte(tokenSecret))
+// Scopes returns the space separated scope claim as a list.
+func (c ScopedClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// MakeScopedJWT mints an access token for an OAuth client, limited to scopes.
+// config should use an audience of its own, so these tokens can't pass for
+// the unscoped tokens that logging in gives.
+func MakeScopedJWT(userID uuid.UUID, tokenVersion int32, grantID uuid.UUID, clientID string, scopes []string, config JWTConfig, expiresIn time.Duration) (string, error) {
+	now := time.Now().UTC()
+	return config.Keys.sign(ScopedClaims{
+		Claims: Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        grantID.String(),
+				Issuer:    config.Issuer,
+				Audience:  jwt.ClaimStrings{config.Audience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				NotBefore: jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+				Subject:   userID.String(),
+			},
+			TokenVersion: tokenVersion,
+		},
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+	})
+}
+
+// ValidateScopedJWT validates a token from MakeScopedJWT like ValidateJWT
+// does, and returns its claims. Checking that the grant is still active is
+// up to the caller.
+func ValidateScopedJWT(tokenString string, config JWTConfig, currentVersion TokenVersionFunc) (ScopedClaims, error) {
+	claims := ScopedClaims{}
+	_, err := parseJWT(tokenString, &claims, &claims.Claims, config, currentVersion)
 	if err != nil {
-		return "", err
+		return ScopedClaims{}, err
 	}
-	return tokenString, nil
+	if claims.ClientID == "" {
+		return ScopedClaims{}, fmt.Errorf("%w: missing client_id", ErrTokenClaims)
+	}
+	if _, err := uuid.Parse(claims.ID); err != nil {
+		return ScopedClaims{}, fmt.Errorf("%w: invalid jti: %w", ErrTokenClaims, err)
+	}
+	return claims, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claims := jwt.MapClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
-		return []byte(tokenSecret), nil
-	})
+// parseJWT parses a token into dest, whose embedded Claims are base, checks
+// everything ValidateJWT promises and returns the subject.
+func parseJWT(tokenString string, dest jwt.Claims, base *Claims, config JWTConfig, currentVersion TokenVersionFunc) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(tokenString, dest, config.Keys.keyfunc,
+		jwt.WithValidMethods(config.Keys.algorithms()),
+		jwt.WithIssuer(config.Issuer),
+		jwt.WithAudience(config.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(config.Leeway),
+	)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, classifyJWTError(err)
+	}
+	if base.NotBefore == nil {
+		return uuid.Nil, fmt.Errorf("%w: missing nbf", ErrTokenClaims)
 	}
 	userString, err := token.Claims.GetSubject()
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, fmt.Errorf("%w: %w", ErrTokenClaims, err)
 	}
 	userID, err := uuid.Parse(userString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%w: invalid subject: %w", ErrTokenClaims, err)
+	}
+	version, err := currentVersion(userID)
 	if err != nil {
 		return uuid.Nil, err
 	}
+	if base.TokenVersion != version {
+		return uuid.Nil, ErrTokenRevoked
+	}
 	return userID, nil
 }
 
+// classifyJWTError maps the errors of the jwt package onto ours, keeping the
+// original around for logging.
+func classifyJWTError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return fmt.Errorf("%w: %w", ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return fmt.Errorf("%w: %w", ErrTokenSignature, err)
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return fmt.Errorf("%w: %w", ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return fmt.Errorf("%w: %w", ErrTokenNotYetValid, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrTokenClaims, err)
+	}
+}
+
+var ErrNoBearerToken = errors.New("didn't find a authorization or tokenstring")
+
 func GetBearerToken(headers http.Header) (string, error) {
 	tokenString := headers.Get("Authorization")
 	if tokenString == "" {
-		return "", fmt.Errorf("didn't find a authorization or tokenstring")
+		return "", ErrNoBearerToken
 	}
 	tokenString = strings.TrimPrefix(tokenString, "Bearer")
 	tokenString = strings.Trim(tokenString, " ")
@@ -80,3 +220,10 @@ func MakeRefreshToken() (string, error) {
 	keyString := hex.EncodeToString(key)
 	return keyString, nil
 }
+
+// HashToken returns the hex encoded SHA-256 of an opaque token so that only
+// the hash has to be stored. Tokens are random, so no salt is needed.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
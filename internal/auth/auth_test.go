@@ -1,17 +1,21 @@
 package auth
 
 import (
+	"crypto/rsa"
+	"errors"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type makeInput struct {
-	userID      uuid.UUID
-	tokenSecret string
-	expiresIn   time.Duration
+	userID    uuid.UUID
+	config    JWTConfig
+	expiresIn time.Duration
 }
 
 type makeOutput struct {
@@ -26,7 +30,7 @@ type makeCase struct {
 
 type validateInput struct {
 	tokenString string
-	tokenSecret string
+	config      JWTConfig
 }
 
 type validateOutput struct {
@@ -42,16 +46,17 @@ type validateCase struct {
 func TestMakeJWT(t *testing.T) {
 	happyCase := makeCase{
 		testInput: makeInput{
-			userID:      uuid.New(),
-			tokenSecret: "omgsecret",
-			expiresIn:   time.Duration(time.Hour),
+			userID:    uuid.New(),
+			config:    jwtConfig(NewHMACKeyring("omgsecret")),
+			expiresIn: time.Duration(time.Hour),
 		},
 		testOutput: makeOutput{},
 	}
 
 	happyCase.testOutput.tokenString, happyCase.testOutput.errorOut = MakeJWT(
 		happyCase.testInput.userID,
-		happyCase.testInput.tokenSecret,
+		0,
+		happyCase.testInput.config,
 		happyCase.testInput.expiresIn,
 	)
 
@@ -63,7 +68,7 @@ func TestMakeJWT(t *testing.T) {
 func TestValidateJWT(t *testing.T) {
 	happyCase := validateCase{
 		testInput: validateInput{
-			tokenSecret: "omgsecret",
+			config: jwtConfig(NewHMACKeyring("omgsecret")),
 		},
 		testOutput: validateOutput{
 			userID:   uuid.New(),
@@ -72,13 +77,15 @@ func TestValidateJWT(t *testing.T) {
 	}
 	happyCase.testInput.tokenString, _ = MakeJWT(
 		happyCase.testOutput.userID,
-		happyCase.testInput.tokenSecret,
+		0,
+		happyCase.testInput.config,
 		time.Duration(time.Hour),
 	)
 
 	testID, err := ValidateJWT(
 		happyCase.testInput.tokenString,
-		happyCase.testInput.tokenSecret,
+		happyCase.testInput.config,
+		currentVersion(0),
 	)
 	if err != happyCase.testOutput.errorOut {
 		t.Errorf("Error generated: Got %v, expected %v", err, happyCase.testOutput.errorOut)
@@ -89,6 +96,287 @@ func TestValidateJWT(t *testing.T) {
 	}
 }
 
+func TestValidateJWTRevoked(t *testing.T) {
+	userID := uuid.New()
+	config := jwtConfig(NewHMACKeyring("omgsecret"))
+	tokenString, err := MakeJWT(userID, 1, config, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	testID, err := ValidateJWT(tokenString, config, currentVersion(2))
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrTokenRevoked)
+	}
+	if testID != uuid.Nil {
+		t.Errorf("Didn't get nil ID: Got %v", testID)
+	}
+}
+
+func TestAccessTokenRole(t *testing.T) {
+	userID := uuid.New()
+	config := jwtConfig(NewHMACKeyring("omgsecret"))
+	tokenString, err := MakeAccessToken(userID, 1, RoleModerator, config, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	claims, err := ValidateAccessToken(tokenString, config, currentVersion(1))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if claims.Role != RoleModerator || claims.Subject != userID.String() {
+		t.Errorf("Didn't get correct claims: Got %v and %v, expected %v and %v", claims.Role, claims.Subject, RoleModerator, userID)
+	}
+
+	// Other tokens carry no role at all.
+	tokenString, err = MakeJWT(userID, 1, config, time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	claims, err = ValidateAccessToken(tokenString, config, currentVersion(1))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if claims.Role != "" {
+		t.Errorf("Didn't get correct role: Got %q, expected %q", claims.Role, "")
+	}
+}
+
+func TestHasPermission(t *testing.T) {
+	cases := []struct {
+		role       string
+		permission string
+		want       bool
+	}{
+		{RoleAdmin, PermissionResetDatabase, true},
+		{RoleAdmin, PermissionManageLockouts, true},
+		{RoleModerator, PermissionManageLockouts, true},
+		{RoleModerator, PermissionResetDatabase, false},
+		{RoleModerator, PermissionSuspendUsers, true},
+		{RoleModerator, PermissionManageUsers, false},
+		{RoleAdmin, PermissionManageUsers, true},
+		{RoleUser, PermissionViewUsers, false},
+		{RoleModerator, PermissionModerate, true},
+		{RoleUser, PermissionModerate, false},
+		{RoleAdmin, PermissionViewAudit, true},
+		{RoleModerator, PermissionViewAudit, false},
+		{RoleUser, PermissionViewMetrics, false},
+		{"", PermissionViewMetrics, false},
+		{"root", PermissionViewMetrics, false},
+	}
+	for _, c := range cases {
+		if got := HasPermission(c.role, c.permission); got != c.want {
+			t.Errorf("Didn't get correct permission for %q to %q: Got %v, expected %v", c.role, c.permission, got, c.want)
+		}
+	}
+	if !ValidRole(RoleUser) || ValidRole("root") {
+		t.Errorf("Didn't validate roles correctly")
+	}
+}
+
+type validateErrorCase struct {
+	name        string
+	tokenString string
+	errorOut    error
+}
+
+func TestValidateJWTErrors(t *testing.T) {
+	config := jwtConfig(NewHMACKeyring("omgsecret"))
+	userID := uuid.New()
+	now := time.Now()
+
+	// claims returns valid claims with the given changes applied.
+	claims := func(change func(*Claims)) Claims {
+		c := Claims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Audience:  jwt.ClaimStrings{"chirpy"},
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+		if change != nil {
+			change(&c)
+		}
+		return c
+	}
+	sign := func(keys *Keyring, c Claims) string {
+		tokenString, err := keys.sign(c)
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		return tokenString
+	}
+
+	rsaKey := newRSAKey(t, "rsa", time.Time{})
+	rsaKeys, _ := NewKeyring(rsaKey)
+	// An HS256 token keyed with the RSA public key and naming the RSA kid:
+	// the classic algorithm confusion attack.
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(nil))
+	confused.Header["kid"] = "rsa"
+	confusedString, _ := confused.SignedString(rsaKey.PublicKey.(*rsa.PublicKey).N.Bytes())
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims(nil))
+	unsigned.Header["kid"] = "hs256"
+	unsignedString, _ := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	cases := []validateErrorCase{
+		{
+			name:        "valid",
+			tokenString: sign(config.Keys, claims(nil)),
+			errorOut:    nil,
+		},
+		{
+			name: "expired within leeway",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-2 * time.Second))
+			})),
+			errorOut: nil,
+		},
+		{
+			name: "expired",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute))
+			})),
+			errorOut: ErrTokenExpired,
+		},
+		{
+			name: "not valid yet",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute))
+			})),
+			errorOut: ErrTokenNotYetValid,
+		},
+		{
+			name:        "malformed",
+			tokenString: "not.a.jwt",
+			errorOut:    ErrTokenMalformed,
+		},
+		{
+			name:        "wrong secret",
+			tokenString: sign(NewHMACKeyring("notthesecret"), claims(nil)),
+			errorOut:    ErrTokenSignature,
+		},
+		{
+			name:        "unknown key",
+			tokenString: sign(rsaKeys, claims(nil)),
+			errorOut:    ErrTokenSignature,
+		},
+		{
+			name:        "alg none",
+			tokenString: unsignedString,
+			errorOut:    ErrTokenSignature,
+		},
+		{
+			name: "wrong issuer",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.Issuer = "someone-else"
+			})),
+			errorOut: ErrTokenClaims,
+		},
+		{
+			name: "wrong audience",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.Audience = jwt.ClaimStrings{"another-service"}
+			})),
+			errorOut: ErrTokenClaims,
+		},
+		{
+			name: "missing expiry",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.ExpiresAt = nil
+			})),
+			errorOut: ErrTokenClaims,
+		},
+		{
+			name: "missing not before",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.NotBefore = nil
+			})),
+			errorOut: ErrTokenClaims,
+		},
+		{
+			name: "subject isn't a user ID",
+			tokenString: sign(config.Keys, claims(func(c *Claims) {
+				c.Subject = "admin"
+			})),
+			errorOut: ErrTokenClaims,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := ValidateJWT(c.tokenString, config, currentVersion(0))
+			if c.errorOut == nil && err != nil {
+				t.Errorf("Error generated: Got %v, expected nil", err)
+			}
+			if c.errorOut != nil && !errors.Is(err, c.errorOut) {
+				t.Errorf("Error generated: Got %v, expected %v", err, c.errorOut)
+			}
+		})
+	}
+
+	rsaConfig := jwtConfig(rsaKeys)
+	_, err := ValidateJWT(confusedString, rsaConfig, currentVersion(0))
+	if !errors.Is(err, ErrTokenSignature) {
+		t.Errorf("Algorithm confusion: Got %v, expected %v", err, ErrTokenSignature)
+	}
+}
+
+func TestScopedJWT(t *testing.T) {
+	config := jwtConfig(NewHMACKeyring("omgsecret"))
+	config.Audience = "chirpy/oauth"
+	userID := uuid.New()
+	grantID := uuid.New()
+
+	token, err := MakeScopedJWT(userID, 2, grantID, "client-1", []string{ScopeChirpsRead, ScopeChirpsWrite}, config, time.Minute)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	claims, err := ValidateScopedJWT(token, config, currentVersion(2))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if claims.Subject != userID.String() || claims.ID != grantID.String() || claims.ClientID != "client-1" {
+		t.Errorf("Didn't get correct claims: Got %+v", claims)
+	}
+	if scopes := claims.Scopes(); len(scopes) != 2 || scopes[0] != ScopeChirpsRead || scopes[1] != ScopeChirpsWrite {
+		t.Errorf("Didn't get correct scopes: Got %v", scopes)
+	}
+
+	// A scoped token must not pass as a first-party access token, and the
+	// other way round.
+	_, err = ValidateJWT(token, jwtConfig(NewHMACKeyring("omgsecret")), currentVersion(2))
+	if !errors.Is(err, ErrTokenClaims) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrTokenClaims)
+	}
+	plain, _ := MakeJWT(userID, 2, jwtConfig(NewHMACKeyring("omgsecret")), time.Minute)
+	_, err = ValidateScopedJWT(plain, config, currentVersion(2))
+	if !errors.Is(err, ErrTokenClaims) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrTokenClaims)
+	}
+
+	_, err = ValidateScopedJWT(token, config, currentVersion(3))
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrTokenRevoked)
+	}
+}
+
+func jwtConfig(keys *Keyring) JWTConfig {
+	return JWTConfig{
+		Keys:     keys,
+		Issuer:   "chirpy",
+		Audience: "chirpy",
+		Leeway:   5 * time.Second,
+	}
+}
+
+func currentVersion(version int32) TokenVersionFunc {
+	return func(uuid.UUID) (int32, error) {
+		return version, nil
+	}
+}
+
 func TestGetBearerToken(t *testing.T) {
 	input := http.Header{}
 	input = make(http.Header)
@@ -104,3 +392,41 @@ func TestGetBearerToken(t *testing.T) {
 		t.Errorf("Didn't get correct string: Got %s, expected wobbles", output)
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	hash := HashToken(token)
+	if hash == token {
+		t.Errorf("Hash should not equal the token")
+	}
+	if len(hash) != 64 {
+		t.Errorf("Didn't get a SHA-256 hex digest: Got %d characters, expected 64", len(hash))
+	}
+	if HashToken(token) != hash {
+		t.Errorf("Hashing the same token twice gave different results")
+	}
+}
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if !IsAPIKey(key) || !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("Didn't get correct key format: Got %s with prefix %s", key, prefix)
+	}
+	if len(prefix) != len("chirpy_")+8 || len(key) != len(prefix)+1+64 {
+		t.Errorf("Didn't get correct key length: Got %s", key)
+	}
+	other, _, _ := MakeAPIKey()
+	if key == other {
+		t.Errorf("Got the same key twice")
+	}
+	if IsAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Errorf("A JWT should not look like an API key")
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// breachedPrefixLength is how many hex digits of the SHA-1 hash name a
+// bucket, the same split the Pwned Passwords range API uses.
+const breachedPrefixLength = 5
+
+// BreachedPasswords is a local copy of a breached-password corpus in the
+// k-anonymity layout of Pwned Passwords: SHA-1 hashes bucketed by their
+// first five hex digits, one "SUFFIX:COUNT" line per hash.
+//
+// It is loaded from either a directory holding one file per prefix, named
+// after the prefix (such as 5BAA6), which is read on demand so the corpus
+// never has to fit in memory; or a single file of "HASH:COUNT" lines, which
+// is read into memory up front.
+type BreachedPasswords struct {
+	dir     string
+	buckets map[string]map[string]bool
+}
+
+// LoadBreachedPasswords opens the corpus at path, which may be a directory
+// of prefix files or a single hash file.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buckets := map[string]map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if hash == "" {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+		if buckets[prefix] == nil {
+			buckets[prefix] = map[string]bool{}
+		}
+		buckets[prefix][suffix] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &BreachedPasswords{buckets: buckets}, nil
+}
+
+// Contains reports whether the password is in the corpus. Only the bucket
+// for the hash prefix is looked at.
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:breachedPrefixLength], hash[breachedPrefixLength:]
+
+	if b.buckets != nil {
+		return b.buckets[prefix][suffix], nil
+	}
+
+	file, err := os.Open(filepath.Join(b.dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
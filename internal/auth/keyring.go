@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key in a Keyring. A key signs new tokens from NotBefore
+// until RetireAt and is accepted for verification until ExpiresAt, so during
+// a rotation the old key keeps verifying the tokens it signed while the new
+// one takes over signing. Zero RetireAt or ExpiresAt means never.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey any
+	PublicKey  any
+	NotBefore  time.Time
+	RetireAt   time.Time
+	ExpiresAt  time.Time
+}
+
+// Keyring holds the keys used to sign and verify access tokens. Tokens carry
+// the ID of their signing key in the kid header.
+type Keyring struct {
+	keys []SigningKey
+	now  func() time.Time
+}
+
+var (
+	ErrNoSigningKey   = errors.New("no active signing key")
+	ErrUnknownKey     = errors.New("token was signed with an unknown key")
+	ErrWrongAlgorithm = errors.New("token algorithm doesn't match its key")
+)
+
+func NewKeyring(keys ...SigningKey) (*Keyring, error) {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("signing key needs an ID")
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate signing key ID %q", key.ID)
+		}
+		seen[key.ID] = true
+		if key.Method == nil {
+			return nil, fmt.Errorf("signing key %q has no signing method", key.ID)
+		}
+		if key.PublicKey == nil {
+			return nil, fmt.Errorf("signing key %q has no public key", key.ID)
+		}
+	}
+	return &Keyring{keys: keys, now: time.Now}, nil
+}
+
+// NewHMACKeyring returns a keyring with a single HS256 key. It is meant for
+// local development, where setting up key files isn't worth it; the secret
+// has to be shared with anything that verifies tokens.
+func NewHMACKeyring(secret string) *Keyring {
+	keys, _ := NewKeyring(SigningKey{
+		ID:         "hs256",
+		Method:     jwt.SigningMethodHS256,
+		PrivateKey: []byte(secret),
+		PublicKey:  []byte(secret),
+	})
+	return keys
+}
+
+type keyringFile struct {
+	Keys []struct {
+		ID             string    `json:"kid"`
+		Algorithm      string    `json:"alg"`
+		PrivateKeyFile string    `json:"private_key_file"`
+		PublicKeyFile  string    `json:"public_key_file"`
+		NotBefore      time.Time `json:"not_before"`
+		RetireAt       time.Time `json:"retire_at"`
+		ExpiresAt      time.Time `json:"expires_at"`
+	} `json:"keys"`
+}
+
+// LoadKeyring reads a JSON keyring description such as
+//
+//	{"keys": [{"kid": "2026-10", "alg": "EdDSA",
+//	           "private_key_file": "2026-10.pem",
+//	           "not_before": "2026-10-01T00:00:00Z"}]}
+//
+// Key files are PEM encoded and resolved relative to the keyring file. A key
+// that only verifies can list a public_key_file instead of a private one.
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := keyringFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("parsing keyring %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	keys := []SigningKey{}
+	for _, entry := range file.Keys {
+		key := SigningKey{
+			ID:        entry.ID,
+			NotBefore: entry.NotBefore,
+			RetireAt:  entry.RetireAt,
+			ExpiresAt: entry.ExpiresAt,
+		}
+		switch entry.Algorithm {
+		case "RS256":
+			key.Method = jwt.SigningMethodRS256
+		case "EdDSA":
+			key.Method = jwt.SigningMethodEdDSA
+		default:
+			return nil, fmt.Errorf("signing key %q: unsupported algorithm %q", entry.ID, entry.Algorithm)
+		}
+
+		if entry.PrivateKeyFile != "" {
+			key.PrivateKey, err = readPEMKey(filepath.Join(dir, entry.PrivateKeyFile), true)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", entry.ID, err)
+			}
+			signer, ok := key.PrivateKey.(crypto.Signer)
+			if !ok {
+				return nil, fmt.Errorf("signing key %q: not a signing key", entry.ID)
+			}
+			key.PublicKey = signer.Public()
+		} else {
+			key.PublicKey, err = readPEMKey(filepath.Join(dir, entry.PublicKeyFile), false)
+			if err != nil {
+				return nil, fmt.Errorf("signing key %q: %w", entry.ID, err)
+			}
+		}
+
+		err = checkKeyType(key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(keys...)
+}
+
+func readPEMKey(path string, private bool) (any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", path)
+	}
+	if !private {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func checkKeyType(key SigningKey) error {
+	var ok bool
+	switch key.Method {
+	case jwt.SigningMethodRS256:
+		_, ok = key.PublicKey.(*rsa.PublicKey)
+	case jwt.SigningMethodEdDSA:
+		_, ok = key.PublicKey.(ed25519.PublicKey)
+	}
+	if !ok {
+		return fmt.Errorf("signing key %q: key type doesn't match %s", key.ID, key.Method.Alg())
+	}
+	return nil
+}
+
+// sign signs the claims with the newest key that is currently allowed to
+// sign.
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	now := k.now()
+	var signer *SigningKey
+	for i, key := range k.keys {
+		if key.PrivateKey == nil || now.Before(key.NotBefore) {
+			continue
+		}
+		if !key.RetireAt.IsZero() && !now.Before(key.RetireAt) {
+			continue
+		}
+		if signer == nil || key.NotBefore.After(signer.NotBefore) {
+			signer = &k.keys[i]
+		}
+	}
+	if signer == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(signer.Method, claims)
+	token.Header["kid"] = signer.ID
+	return token.SignedString(signer.PrivateKey)
+}
+
+// keyfunc finds the verification key named by the token's kid header. The
+// token has to use the algorithm the key was configured with, so a token
+// can't pick how its own signature gets checked.
+func (k *Keyring) keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	now := k.now()
+	for _, key := range k.keys {
+		if key.ID != kid {
+			continue
+		}
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, ErrWrongAlgorithm
+		}
+		return key.PublicKey, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// algorithms lists the signing algorithms of the keys in the keyring.
+func (k *Keyring) algorithms() []string {
+	algs := []string{}
+	for _, key := range k.keys {
+		if !slices.Contains(algs, key.Method.Alg()) {
+			algs = append(algs, key.Method.Alg())
+		}
+	}
+	return algs
+}
+
+// JWK is the JSON Web Key (RFC 7517) form of a public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key that still verifies tokens,
+// including keys that haven't started signing yet, so verifiers can pick up
+// a new key before the first token signed with it shows up. Symmetric keys
+// are never published.
+func (k *Keyring) JWKS() JWKSet {
+	now := k.now()
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range k.keys {
+		if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+			continue
+		}
+		jwk := JWK{
+			KeyID:     key.ID,
+			Algorithm: key.Method.Alg(),
+			Use:       "sig",
+		}
+		switch pub := key.PublicKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newEdDSAKey(t *testing.T, id string, notBefore time.Time) SigningKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodEdDSA,
+		PrivateKey: priv,
+		PublicKey:  pub,
+		NotBefore:  notBefore,
+	}
+}
+
+func newRSAKey(t *testing.T, id string, notBefore time.Time) SigningKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return SigningKey{
+		ID:         id,
+		Method:     jwt.SigningMethodRS256,
+		PrivateKey: priv,
+		PublicKey:  &priv.PublicKey,
+		NotBefore:  notBefore,
+	}
+}
+
+func TestKeyringAsymmetric(t *testing.T) {
+	now := time.Now()
+	cases := map[string]SigningKey{
+		"EdDSA": newEdDSAKey(t, "ed", now.Add(-time.Hour)),
+		"RS256": newRSAKey(t, "rsa", now.Add(-time.Hour)),
+	}
+	for name, key := range cases {
+		t.Run(name, func(t *testing.T) {
+			keys, err := NewKeyring(key)
+			if err != nil {
+				t.Fatalf("Error generated: Got %v, expected nil", err)
+			}
+			userID := uuid.New()
+			tokenString, err := MakeJWT(userID, 0, jwtConfig(keys), time.Hour)
+			if err != nil {
+				t.Fatalf("Error generated: Got %v, expected nil", err)
+			}
+
+			token, _, err := jwt.NewParser().ParseUnverified(tokenString, &Claims{})
+			if err != nil {
+				t.Fatalf("Error generated: Got %v, expected nil", err)
+			}
+			if token.Header["kid"] != key.ID {
+				t.Errorf("Didn't get correct kid: Got %v, expected %s", token.Header["kid"], key.ID)
+			}
+			if token.Method.Alg() != name {
+				t.Errorf("Didn't get correct alg: Got %s, expected %s", token.Method.Alg(), name)
+			}
+
+			testID, err := ValidateJWT(tokenString, jwtConfig(keys), currentVersion(0))
+			if err != nil {
+				t.Errorf("Error generated: Got %v, expected nil", err)
+			}
+			if testID != userID {
+				t.Errorf("Didn't get correct ID: Got %v, expected %v", testID, userID)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	now := time.Now()
+	oldKey := newEdDSAKey(t, "old", now.Add(-48*time.Hour))
+	newKey := newEdDSAKey(t, "new", now.Add(time.Hour))
+
+	keys, err := NewKeyring(oldKey, newKey)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	oldToken, err := MakeJWT(uuid.New(), 0, jwtConfig(keys), time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	// An hour later the new key has taken over signing, but the old one
+	// still verifies the tokens it signed.
+	keys.now = func() time.Time { return now.Add(90 * time.Minute) }
+	newToken, err := MakeJWT(uuid.New(), 0, jwtConfig(keys), time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	token, _, _ := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	if token.Header["kid"] != "new" {
+		t.Errorf("Didn't sign with the new key: Got %v", token.Header["kid"])
+	}
+	_, err = ValidateJWT(oldToken, jwtConfig(keys), currentVersion(0))
+	if err != nil {
+		t.Errorf("Old key should still verify: Got %v", err)
+	}
+
+	// Once the old key expires its tokens are rejected.
+	keys.keys[0].ExpiresAt = now.Add(time.Hour)
+	keys.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err = ValidateJWT(oldToken, jwtConfig(keys), currentVersion(0))
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrUnknownKey)
+	}
+}
+
+func TestKeyringNoSigningKey(t *testing.T) {
+	keys, err := NewKeyring(newEdDSAKey(t, "future", time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	_, err = MakeJWT(uuid.New(), 0, jwtConfig(keys), time.Hour)
+	if err != ErrNoSigningKey {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrNoSigningKey)
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	now := time.Now()
+	expired := newRSAKey(t, "expired", now.Add(-72*time.Hour))
+	expired.ExpiresAt = now.Add(-time.Hour)
+	keys, err := NewKeyring(
+		newEdDSAKey(t, "ed", now),
+		newRSAKey(t, "rsa", now),
+		expired,
+		SigningKey{ID: "hmac", Method: jwt.SigningMethodHS256, PrivateKey: []byte("s"), PublicKey: []byte("s")},
+	)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	set := keys.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("Didn't get correct number of keys: Got %d, expected 2", len(set.Keys))
+	}
+	if set.Keys[0].KeyType != "OKP" || set.Keys[0].Curve != "Ed25519" || set.Keys[0].X == "" {
+		t.Errorf("Didn't get an Ed25519 JWK: Got %+v", set.Keys[0])
+	}
+	if set.Keys[1].KeyType != "RSA" || set.Keys[1].E != "AQAB" || set.Keys[1].N == "" {
+		t.Errorf("Didn't get an RSA JWK: Got %+v", set.Keys[1])
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	key := newEdDSAKey(t, "2026-10", time.Time{})
+	der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "2026-10.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	config := `{"keys": [{"kid": "2026-10", "alg": "EdDSA", "private_key_file": "2026-10.pem"}]}`
+	err = os.WriteFile(filepath.Join(dir, "keyring.json"), []byte(config), 0o600)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	keys, err := LoadKeyring(filepath.Join(dir, "keyring.json"))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	tokenString, err := MakeJWT(uuid.New(), 0, jwtConfig(keys), time.Hour)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	_, err = ValidateJWT(tokenString, jwtConfig(keys), currentVersion(0))
+	if err != nil {
+		t.Errorf("Error generated: Got %v, expected nil", err)
+	}
+}
+
+func TestLoadKeyringNotASigningKey(t *testing.T) {
+	dir := t.TempDir()
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "x25519.pem"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	config := `{"keys": [{"kid": "x25519", "alg": "EdDSA", "private_key_file": "x25519.pem"}]}`
+	err = os.WriteFile(filepath.Join(dir, "keyring.json"), []byte(config), 0o600)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	_, err = LoadKeyring(filepath.Join(dir, "keyring.json"))
+	if err == nil || err.Error() != `signing key "x25519": not a signing key` {
+		t.Errorf("Error generated: Got %v, expected not a signing key", err)
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// LockoutPolicy decides how long logins are blocked after repeated failures.
+// The first FreeAttempts failures cost nothing; every failure after that
+// locks for BaseDelay, doubling each time up to MaxDelay.
+type LockoutPolicy struct {
+	FreeAttempts int32
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+// LockoutFor returns how long to lock after the given number of consecutive
+// failures, or zero if no lockout is due yet.
+func (p LockoutPolicy) LockoutFor(failures int32) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return min(delay, p.MaxDelay)
+}
+
+var ErrDummyPassword = errors.New("checked against the dummy password hash")
+
+var (
+	dummyHashesMu sync.Mutex
+	dummyHashes   = map[Argon2Params]string{}
+)
+
+func dummyHash(params Argon2Params) string {
+	dummyHashesMu.Lock()
+	defer dummyHashesMu.Unlock()
+	hash, ok := dummyHashes[params]
+	if !ok {
+		hash, _ = HashPassword("chirpy dummy password", params)
+		dummyHashes[params] = hash
+	}
+	return hash
+}
+
+// CheckDummyPassword does the same work as checking a real password hash made
+// with params and always fails. Logins for unknown accounts call it so they
+// take as long as logins with a wrong password, instead of revealing which
+// accounts exist.
+func CheckDummyPassword(password string, params Argon2Params) error {
+	err := CheckPasswordHash(password, dummyHash(params))
+	if err == nil {
+		return ErrDummyPassword
+	}
+	return err
+}
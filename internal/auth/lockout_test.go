@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutFor(t *testing.T) {
+	policy := LockoutPolicy{
+		FreeAttempts: 3,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     10 * time.Minute,
+	}
+	cases := map[int32]time.Duration{
+		0:  0,
+		3:  0,
+		4:  30 * time.Second,
+		5:  time.Minute,
+		6:  2 * time.Minute,
+		8:  8 * time.Minute,
+		9:  10 * time.Minute,
+		50: 10 * time.Minute,
+	}
+	for failures, want := range cases {
+		got := policy.LockoutFor(failures)
+		if got != want {
+			t.Errorf("Didn't get correct lockout after %d failures: Got %v, expected %v", failures, got, want)
+		}
+	}
+}
+
+func TestCheckDummyPassword(t *testing.T) {
+	err := CheckDummyPassword("chirpy dummy password", DefaultArgon2Params)
+	if err == nil {
+		t.Errorf("Dummy password check should always fail")
+	}
+	err = CheckDummyPassword("hunter2", DefaultArgon2Params)
+	if err == nil {
+		t.Errorf("Dummy password check should always fail")
+	}
+}
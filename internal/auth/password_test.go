@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep the tests fast.
+var testArgon2Params = Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("gravel orbit lantern", testArgon2Params)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Didn't get correct hash format: Got %s", hash)
+	}
+	other, _ := HashPassword("gravel orbit lantern", testArgon2Params)
+	if hash == other {
+		t.Errorf("Two hashes of the same password should have different salts")
+	}
+
+	err = CheckPasswordHash("gravel orbit lantern", hash)
+	if err != nil {
+		t.Errorf("Error generated: Got %v, expected nil", err)
+	}
+	err = CheckPasswordHash("gravel orbit lanterns", hash)
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrPasswordMismatch)
+	}
+}
+
+func TestCheckPasswordHashBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = CheckPasswordHash("hunter2", string(hash))
+	if err != nil {
+		t.Errorf("Error generated: Got %v, expected nil", err)
+	}
+	err = CheckPasswordHash("hunter3", string(hash))
+	if !errors.Is(err, ErrPasswordMismatch) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrPasswordMismatch)
+	}
+}
+
+func TestCheckPasswordHashUnknown(t *testing.T) {
+	for _, hash := range []string{"", "plaintext", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdA$a2V5"} {
+		err := CheckPasswordHash("hunter2", hash)
+		if !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("Error generated for %q: Got %v, expected %v", hash, err, ErrUnknownHash)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	current, _ := HashPassword("hunter2", testArgon2Params)
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+
+	cases := []struct {
+		name   string
+		hash   string
+		params Argon2Params
+		want   bool
+	}{
+		{name: "bcrypt", hash: string(bcryptHash), params: testArgon2Params, want: true},
+		{name: "current", hash: current, params: testArgon2Params, want: false},
+		{name: "outdated parameters", hash: current, params: stronger, want: true},
+	}
+	for _, c := range cases {
+		if got := NeedsRehash(c.hash, c.params); got != c.want {
+			t.Errorf("Didn't get correct result for %s: Got %v, expected %v", c.name, got, c.want)
+		}
+	}
+}
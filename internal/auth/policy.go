@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is what a new password has to satisfy. MinStrength is a
+// score from 0 to 4 on the same scale as zxcvbn, see PasswordStrength.
+// Breached is optional.
+type PasswordPolicy struct {
+	MinLength   int
+	MaxLength   int
+	MinStrength int
+	Breached    *BreachedPasswords
+}
+
+// PolicyViolation is one rule a password broke. Rule is stable and meant for
+// programs, Message for people.
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Check returns every rule the password breaks, or nothing if it is fine.
+// userInputs are things like the email address that the password must not
+// be built from.
+func (p PasswordPolicy) Check(password string, userInputs ...string) ([]PolicyViolation, error) {
+	violations := []PolicyViolation{}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxLength),
+		})
+		// Scoring a huge password is wasted work.
+		return violations, nil
+	}
+	if containsUserInput(password, userInputs) {
+		violations = append(violations, PolicyViolation{
+			Rule:    "personal_info",
+			Message: "Password must not contain your email address or name",
+		})
+	}
+	if score := PasswordStrength(password, userInputs...); score < p.MinStrength {
+		violations = append(violations, PolicyViolation{
+			Rule:    "strength",
+			Message: fmt.Sprintf("Password is too easy to guess (strength %d of 4, needs %d)", score, p.MinStrength),
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, PolicyViolation{
+				Rule:    "breached",
+				Message: "Password has appeared in a data breach",
+			})
+		}
+	}
+	return violations, nil
+}
+
+// userInputParts splits inputs such as email addresses into the pieces a
+// person might build a password from.
+func userInputParts(userInputs []string) []string {
+	parts := []string{}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		parts = append(parts, input)
+		for _, part := range strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len(part) >= 3 {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return parts
+}
+
+func containsUserInput(password string, userInputs []string) bool {
+	lower := strings.ToLower(password)
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		if input == "" {
+			continue
+		}
+		if strings.Contains(lower, input) {
+			return true
+		}
+		// The local part of an address is the bit people reuse.
+		local, _, found := strings.Cut(input, "@")
+		if found && len(local) >= 4 && strings.Contains(lower, local) {
+			return true
+		}
+	}
+	return false
+}
+
+//go:embed common_passwords.txt
+var commonPasswordsFile []byte
+
+// commonPasswords maps frequent passwords and words to their rank, most
+// common first.
+var commonPasswords = func() map[string]int {
+	ranks := map[string]int{}
+	scanner := bufio.NewScanner(bytes.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if _, ok := ranks[word]; !ok {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	return ranks
+}()
+
+var keyboardRows = []string{
+	"1234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+	"qazwsxedcrfvtgbyhnujmikolp",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// PasswordStrength estimates how hard a password is to guess, in the style
+// of zxcvbn: the password is covered by the cheapest sequence of patterns an
+// attacker would try (common passwords, l33t spellings, keyboard runs,
+// sequences, repeats, years and the user's own details), the number of
+// guesses for that cover is estimated, and the result is bucketed into a
+// score from 0 (guessable in a thousand tries) to 4 (over 10^10 tries).
+func PasswordStrength(password string, userInputs ...string) int {
+	log10 := passwordGuessesLog10(password, userInputParts(userInputs))
+	switch {
+	case log10 < 3:
+		return 0
+	case log10 < 6:
+		return 1
+	case log10 < 8:
+		return 2
+	case log10 < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// match is a pattern found in the password covering runes [start, end).
+type match struct {
+	start, end int
+	guesses    float64
+}
+
+// passwordGuessesLog10 finds the cheapest cover of the password with a
+// dynamic program over rune positions. Runes no pattern covers are brute
+// forced at ten guesses each, the same constant zxcvbn uses.
+func passwordGuessesLog10(password string, userInputs []string) float64 {
+	runes := []rune(password)
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+
+	matches := findMatches(runes, userInputs)
+	best := make([]float64, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = best[j-1] + 1
+		for _, m := range matches {
+			if m.end == j {
+				best[j] = math.Min(best[j], best[m.start]+math.Log10(math.Max(m.guesses, 2)))
+			}
+		}
+	}
+	return best[n]
+}
+
+func findMatches(runes []rune, userInputs []string) []match {
+	matches := []match{}
+	lower := []rune(strings.ToLower(string(runes)))
+	unleet := make([]rune, len(lower))
+	for i, r := range lower {
+		if sub, ok := leetSubstitutions[r]; ok {
+			unleet[i] = sub
+		} else {
+			unleet[i] = r
+		}
+	}
+
+	// Dictionary words, reversed words and l33t spellings.
+	for i := range lower {
+		for j := i + 3; j <= len(lower); j++ {
+			word := string(lower[i:j])
+			variations := uppercaseVariations(runes[i:j])
+			if rank, ok := commonPasswords[word]; ok {
+				matches = append(matches, match{i, j, float64(rank) * variations})
+			}
+			if rank, ok := commonPasswords[reverse(word)]; ok {
+				matches = append(matches, match{i, j, float64(rank) * variations * 2})
+			}
+			leet := string(unleet[i:j])
+			if leet != word {
+				if rank, ok := commonPasswords[leet]; ok {
+					matches = append(matches, match{i, j, float64(rank) * variations * 2})
+				}
+			}
+			for _, input := range userInputs {
+				if word == input || leet == input {
+					matches = append(matches, match{i, j, 1})
+				}
+			}
+		}
+	}
+
+	// Runs of a single repeated character.
+	for i := 0; i < len(lower); {
+		j := i + 1
+		for j < len(lower) && lower[j] == lower[i] {
+			j++
+		}
+		if j-i >= 3 {
+			matches = append(matches, match{i, j, 10 * float64(j-i)})
+		}
+		i = j
+	}
+
+	// Ascending or descending sequences such as abcd or 9876.
+	for i := 0; i < len(lower)-2; i++ {
+		delta := lower[i+1] - lower[i]
+		if delta != 1 && delta != -1 {
+			continue
+		}
+		j := i + 2
+		for j < len(lower) && lower[j]-lower[j-1] == delta {
+			j++
+		}
+		if j-i >= 3 {
+			base := 26.0
+			if unicode.IsDigit(lower[i]) {
+				base = 10
+			}
+			if delta == -1 {
+				base *= 2
+			}
+			matches = append(matches, match{i, j, base * float64(j-i)})
+		}
+	}
+
+	// Runs along a keyboard row, forwards or backwards.
+	for _, row := range keyboardRows {
+		for _, line := range []string{row, reverse(row)} {
+			for i := range lower {
+				for j := i + 4; j <= len(lower); j++ {
+					if !strings.Contains(line, string(lower[i:j])) {
+						break
+					}
+					matches = append(matches, match{i, j, 40 * float64(j-i)})
+				}
+			}
+		}
+	}
+
+	// Recent years, which people love to append.
+	thisYear := time.Now().Year()
+	for i := 0; i+4 <= len(lower); i++ {
+		year, err := strconv.Atoi(string(lower[i : i+4]))
+		if err != nil || year < 1900 || year > 2099 {
+			continue
+		}
+		distance := thisYear - year
+		if distance < 0 {
+			distance = -distance
+		}
+		matches = append(matches, match{i, i + 4, float64(max(distance, 20))})
+	}
+
+	return matches
+}
+
+// uppercaseVariations is how many capitalisations of a word an attacker has
+// to try before reaching this one.
+func uppercaseVariations(word []rune) float64 {
+	upper := 0
+	for _, r := range word {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case upper == len(word), upper == 1 && unicode.IsUpper(word[0]):
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
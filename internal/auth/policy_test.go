@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	cases := []struct {
+		password string
+		min, max int
+	}{
+		{password: "", min: 0, max: 0},
+		{password: "password", min: 0, max: 0},
+		{password: "P@ssw0rd", min: 0, max: 1},
+		{password: "qwertyuiop", min: 0, max: 1},
+		{password: "abcdefgh", min: 0, max: 1},
+		{password: "aaaaaaaaaaaa", min: 0, max: 1},
+		{password: "drowssap1234", min: 0, max: 2},
+		{password: "monkey2019", min: 0, max: 2},
+		{password: "walt.chirps", min: 0, max: 2},
+		{password: "tR7#kq9!Vx2$mP", min: 4, max: 4},
+		{password: "gravel orbit lantern quietly", min: 4, max: 4},
+	}
+	for _, c := range cases {
+		score := PasswordStrength(c.password, "walt.chirps@example.com")
+		if score < c.min || score > c.max {
+			t.Errorf("Didn't get correct strength for %q: Got %d, expected %d to %d", c.password, score, c.min, c.max)
+		}
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 10, MaxLength: 72, MinStrength: 3}
+
+	cases := []struct {
+		name     string
+		password string
+		rules    []string
+	}{
+		{name: "strong", password: "gravel orbit lantern quietly", rules: []string{}},
+		{name: "empty", password: "", rules: []string{"min_length", "strength"}},
+		{name: "common", password: "password123", rules: []string{"strength"}},
+		{name: "email", password: "walt@example.com", rules: []string{"personal_info", "strength"}},
+		{name: "local part", password: "waltwhitman!", rules: []string{"personal_info", "strength"}},
+		{name: "too long", password: string(make([]byte, 73)), rules: []string{"max_length"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			violations, err := policy.Check(c.password, "walt@example.com", "waltwhitman@example.org")
+			if err != nil {
+				t.Fatalf("Error generated: Got %v, expected nil", err)
+			}
+			got := []string{}
+			for _, v := range violations {
+				got = append(got, v.Rule)
+			}
+			if len(got) != len(c.rules) {
+				t.Fatalf("Didn't get correct violations: Got %v, expected %v", got, c.rules)
+			}
+			for i := range got {
+				if got[i] != c.rules[i] {
+					t.Errorf("Didn't get correct violations: Got %v, expected %v", got, c.rules)
+				}
+			}
+		})
+	}
+}
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+func TestBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+	prefixDir := filepath.Join(dir, "ranges")
+	err := os.Mkdir(prefixDir, 0o755)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = os.WriteFile(filepath.Join(prefixDir, "5BAA6"), []byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	hashFile := filepath.Join(dir, "hashes.txt")
+	err = os.WriteFile(hashFile, []byte("5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:9659365\n"), 0o644)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	for _, path := range []string{prefixDir, hashFile} {
+		breached, err := LoadBreachedPasswords(path)
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		found, err := breached.Contains("password")
+		if err != nil || !found {
+			t.Errorf("Didn't find breached password in %s: Got %v, %v", path, found, err)
+		}
+		found, err = breached.Contains("gravel orbit lantern quietly")
+		if err != nil || found {
+			t.Errorf("Found password that isn't breached in %s: Got %v, %v", path, found, err)
+		}
+	}
+
+	policy := PasswordPolicy{Breached: &BreachedPasswords{dir: prefixDir}}
+	violations, err := policy.Check("password")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if len(violations) != 1 || violations[0].Rule != "breached" {
+		t.Errorf("Didn't get correct violations: Got %v, expected breached", violations)
+	}
+}
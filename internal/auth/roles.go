@@ -0,0 +1,55 @@
+package auth
+
+import "slices"
+
+// Roles a user can have. Everyone starts out as a user.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permissions guard the admin endpoints. Handlers ask for a permission, not
+// a role, so what a role may do is decided here alone.
+const (
+	PermissionViewMetrics    = "metrics:view"
+	PermissionResetDatabase  = "database:reset"
+	PermissionManageLockouts = "lockouts:manage"
+	PermissionViewUsers      = "users:view"
+	PermissionSuspendUsers   = "users:suspend"
+	PermissionManageUsers    = "users:manage"
+	PermissionModerate       = "reports:moderate"
+	PermissionViewAudit      = "audit:view"
+)
+
+var rolePermissions = map[string][]string{
+	RoleUser: {},
+	RoleModerator: {
+		PermissionManageLockouts,
+		PermissionViewUsers,
+		PermissionSuspendUsers,
+		PermissionModerate,
+	},
+	RoleAdmin: {
+		PermissionViewMetrics,
+		PermissionResetDatabase,
+		PermissionManageLockouts,
+		PermissionViewUsers,
+		PermissionSuspendUsers,
+		PermissionManageUsers,
+		PermissionModerate,
+		PermissionViewAudit,
+	},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants permission. Unknown roles,
+// including the empty role of tokens minted before roles existed, grant
+// nothing.
+func HasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
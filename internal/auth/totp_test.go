@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors of RFC 6238, appendix B, cut down to six digits.
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		if code != want {
+			t.Errorf("Didn't get correct code at %d: Got %s, expected %s", unix, code, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	now := time.Unix(1760000000, 0)
+	current := now.Unix() / totpPeriod
+
+	cases := []struct {
+		name   string
+		at     time.Time
+		valid  bool
+		offset int64
+	}{
+		{name: "current period", at: now, valid: true, offset: 0},
+		{name: "previous period", at: now.Add(-totpPeriod * time.Second), valid: true, offset: -1},
+		{name: "next period", at: now.Add(totpPeriod * time.Second), valid: true, offset: 1},
+		{name: "too old", at: now.Add(-3 * totpPeriod * time.Second), valid: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, _ := TOTPCode(secret, c.at)
+			step, ok := ValidateTOTP(secret, code, now)
+			if ok != c.valid {
+				t.Fatalf("Didn't get correct result: Got %v, expected %v", ok, c.valid)
+			}
+			if ok && step != current+c.offset {
+				t.Errorf("Didn't get correct step: Got %d, expected %d", step, current+c.offset)
+			}
+		})
+	}
+
+	if _, ok := ValidateTOTP(secret, "", now); ok {
+		t.Errorf("Empty code should not validate")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "walt@example.com", "JBSWY3DPEHPK3PXP")
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
+		t.Errorf("Didn't get an otpauth totp URI: Got %s", uri)
+	}
+	if parsed.Path != "/Chirpy:walt@example.com" {
+		t.Errorf("Didn't get correct label: Got %s", parsed.Path)
+	}
+	if parsed.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || parsed.Query().Get("issuer") != "Chirpy" {
+		t.Errorf("Didn't get correct parameters: Got %s", parsed.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Didn't get correct format: Got %s", code)
+		}
+		if seen[code] {
+			t.Errorf("Got duplicate code %s", code)
+		}
+		seen[code] = true
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if NormalizeRecoveryCode(typed) != code {
+			t.Errorf("Didn't normalize %q: Got %s, expected %s", typed, NormalizeRecoveryCode(typed), code)
+		}
+	}
+}
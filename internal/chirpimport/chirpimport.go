@@ -0,0 +1,233 @@
+// Package chirpimport reads archives of chirps brought over from elsewhere.
+// Archives are JSON Lines or CSV, one chirp per row, each with the ID it had
+// where it came from, its body, and when it was posted.
+package chirpimport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// maxLineLength caps a JSON Lines row. Chirps are short, so anything near it
+// isn't a chirp.
+const maxLineLength = 64 * 1024
+
+var (
+	ErrUnknownFormat = errors.New("archive format must be jsonl or csv")
+	ErrCSVHeader     = errors.New("CSV archive needs a header row with external_id, body and created_at columns")
+	ErrLineTooLong   = fmt.Errorf("line is longer than %d bytes", maxLineLength)
+	ErrMalformedCSV  = errors.New("CSV is malformed")
+)
+
+// Row is a chirp read from an archive.
+type Row struct {
+	// Line is where the row starts in the archive, counting from 1.
+	Line       int
+	ExternalID string
+	Body       string
+	CreatedAt  time.Time
+}
+
+// RowError is a row that couldn't be read. The rows after it still can be.
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ArchiveError is a problem that stops the rest of an archive from being
+// read. Err is one of this package's errors, so it's safe to show users;
+// Cause is what went wrong underneath, if anything, and is for logs.
+type ArchiveError struct {
+	Line  int
+	Err   error
+	Cause error
+}
+
+func (e *ArchiveError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Err, e.Cause)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads the rows of an archive in order.
+type Reader struct {
+	next func() (Row, error)
+}
+
+// NewReader reads an archive in the given format. For CSV, the header row is
+// read straight away.
+func NewReader(format string, r io.Reader) (*Reader, error) {
+	switch format {
+	case FormatJSONL:
+		return newJSONLReader(r), nil
+	case FormatCSV:
+		return newCSVReader(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// FormatFor works out an archive's format from its media type or file name,
+// returning "" when neither gives it away.
+func FormatFor(mediaType, name string) string {
+	switch mediaType {
+	case "application/jsonl", "application/x-ndjson", "application/x-jsonlines":
+		return FormatJSONL
+	case "text/csv":
+		return FormatCSV
+	}
+	switch {
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"):
+		return FormatJSONL
+	case strings.HasSuffix(name, ".csv"):
+		return FormatCSV
+	}
+	return ""
+}
+
+// Read returns the next row, or io.EOF after the last one. A *RowError means
+// that row is bad but reading can go on; any other error ends the archive.
+func (r *Reader) Read() (Row, error) {
+	return r.next()
+}
+
+// Count reads the rest of the archive and returns how many rows it has, good
+// or bad.
+func (r *Reader) Count() (int, error) {
+	n := 0
+	for {
+		_, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return n, err
+		}
+		n++
+	}
+}
+
+func newJSONLReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineLength)
+	line := 0
+	return &Reader{next: func() (Row, error) {
+		for scanner.Scan() {
+			line++
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var raw struct {
+				ExternalID string `json:"external_id"`
+				Body       string `json:"body"`
+				CreatedAt  string `json:"created_at"`
+			}
+			err := json.Unmarshal(text, &raw)
+			if err != nil {
+				return Row{}, &RowError{Line: line, Err: errors.New("row isn't a JSON object")}
+			}
+			return newRow(line, raw.ExternalID, raw.Body, raw.CreatedAt)
+		}
+		err := scanner.Err()
+		if errors.Is(err, bufio.ErrTooLong) {
+			return Row{}, &ArchiveError{Line: line + 1, Err: ErrLineTooLong}
+		}
+		if err != nil {
+			return Row{}, err
+		}
+		return Row{}, io.EOF
+	}}
+}
+
+// csvError turns a CSV syntax error into an *ArchiveError.
+func csvError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return &ArchiveError{Line: parseErr.StartLine, Err: ErrMalformedCSV, Cause: parseErr.Err}
+	}
+	return err
+}
+
+func newCSVReader(r io.Reader) (*Reader, error) {
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+	header, err := records.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrCSVHeader
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	externalID, ok1 := columns["external_id"]
+	body, ok2 := columns["body"]
+	createdAt, ok3 := columns["created_at"]
+	if !ok1 || !ok2 || !ok3 {
+		return nil, ErrCSVHeader
+	}
+
+	return &Reader{next: func() (Row, error) {
+		record, err := records.Read()
+		if err != nil {
+			return Row{}, csvError(err)
+		}
+		line, _ := records.FieldPos(0)
+		field := func(i int) string {
+			if i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		return newRow(line, field(externalID), field(body), field(createdAt))
+	}}, nil
+}
+
+func newRow(line int, externalID, body, createdAt string) (Row, error) {
+	externalID = strings.TrimSpace(externalID)
+	if externalID == "" {
+		return Row{}, &RowError{Line: line, Err: errors.New("external_id is missing")}
+	}
+	if len(externalID) > 255 {
+		return Row{}, &RowError{Line: line, Err: errors.New("external_id is longer than 255 bytes")}
+	}
+	if body == "" {
+		return Row{}, &RowError{Line: line, Err: errors.New("body is missing")}
+	}
+	if createdAt == "" {
+		return Row{}, &RowError{Line: line, Err: errors.New("created_at is missing")}
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(createdAt))
+	if err != nil {
+		return Row{}, &RowError{Line: line, Err: errors.New("created_at must be an RFC 3339 time")}
+	}
+	return Row{Line: line, ExternalID: externalID, Body: body, CreatedAt: t}, nil
+}
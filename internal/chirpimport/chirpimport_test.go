@@ -0,0 +1,146 @@
+package chirpimport
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readAll returns the good rows of an archive, and the lines of the bad ones.
+func readAll(t *testing.T, r *Reader) ([]Row, []int) {
+	t.Helper()
+	rows := []Row{}
+	bad := []int{}
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, bad
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			bad = append(bad, rowErr.Line)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	archive := `{"external_id": "1", "body": "hello", "created_at": "2020-01-02T03:04:05Z"}
+
+not json
+{"external_id": "3", "body": "no time"}
+{"external_id": "4", "body": "later", "created_at": "2020-01-02T05:04:05+02:00"}
+`
+	r, err := NewReader(FormatJSONL, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	rows, bad := readAll(t, r)
+
+	if len(rows) != 2 || rows[0].ExternalID != "1" || rows[0].Body != "hello" || rows[1].Line != 5 {
+		t.Errorf("Didn't get correct rows: Got %+v", rows)
+	}
+	if !rows[1].CreatedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Didn't get correct time: Got %v, expected 2020-01-02 03:04:05 UTC", rows[1].CreatedAt)
+	}
+	if len(bad) != 2 || bad[0] != 3 || bad[1] != 4 {
+		t.Errorf("Didn't get correct bad lines: Got %v, expected [3 4]", bad)
+	}
+}
+
+func TestJSONLLineTooLong(t *testing.T) {
+	archive := `{"external_id": "1", "body": "` + strings.Repeat("a", maxLineLength) + `"}`
+	r, err := NewReader(FormatJSONL, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	_, err = r.Read()
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) || archiveErr.Line != 1 || !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("Didn't stop at a line that's too long: Got %v", err)
+	}
+}
+
+func TestCSVMalformed(t *testing.T) {
+	archive := "external_id,body,created_at\n1,a,2020-01-02T03:04:05Z\n2,\"b,2020-01-02T03:04:05Z\n"
+	r, err := NewReader(FormatCSV, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	_, err = r.Count()
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) || archiveErr.Line != 3 || !errors.Is(err, ErrMalformedCSV) || archiveErr.Cause == nil {
+		t.Errorf("Didn't stop at malformed CSV: Got %v", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	archive := "Body,External_ID,created_at,likes\n" +
+		"\"hello, world\",a,2020-01-02T03:04:05Z,3\n" +
+		"\"two\nlines\",b,2020-01-03T03:04:05Z,0\n" +
+		"missing,,2020-01-03T03:04:05Z,0\n" +
+		"short,c\n"
+	r, err := NewReader(FormatCSV, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	rows, bad := readAll(t, r)
+
+	if len(rows) != 2 || rows[0].Body != "hello, world" || rows[1].Body != "two\nlines" || rows[1].ExternalID != "b" {
+		t.Errorf("Didn't get correct rows: Got %+v", rows)
+	}
+	if len(bad) != 2 || bad[0] != 5 || bad[1] != 6 {
+		t.Errorf("Didn't get correct bad lines: Got %v, expected [5 6]", bad)
+	}
+}
+
+func TestCSVHeader(t *testing.T) {
+	cases := []string{"", "body,created_at\nhello,2020-01-02T03:04:05Z\n"}
+	for _, archive := range cases {
+		_, err := NewReader(FormatCSV, strings.NewReader(archive))
+		if !errors.Is(err, ErrCSVHeader) {
+			t.Errorf("Error generated for %q: Got %v, expected %v", archive, err, ErrCSVHeader)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	archive := "external_id,body,created_at\n1,a,2020-01-02T03:04:05Z\n2,b,yesterday\n3,c,2020-01-02T03:04:05Z\n"
+	r, err := NewReader(FormatCSV, strings.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	n, err := r.Count()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if n != 3 {
+		t.Errorf("Didn't count rows: Got %d, expected 3", n)
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	cases := []struct {
+		mediaType string
+		name      string
+		want      string
+	}{
+		{"application/x-ndjson", "", FormatJSONL},
+		{"text/csv", "", FormatCSV},
+		{"application/octet-stream", "history.jsonl", FormatJSONL},
+		{"", "history.csv", FormatCSV},
+		{"application/json", "history.json", ""},
+	}
+	for _, c := range cases {
+		got := FormatFor(c.mediaType, c.name)
+		if got != c.want {
+			t.Errorf("FormatFor(%q, %q): Got %q, expected %q", c.mediaType, c.name, got, c.want)
+		}
+	}
+}
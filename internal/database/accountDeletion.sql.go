@@ -0,0 +1,77 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: accountDeletion.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const cancelAccountDeletion = `-- name: CancelAccountDeletion :execrows
+UPDATE users
+SET deletion_due_at = NULL, updated_at = now()
+WHERE id = $1 AND deletion_due_at IS NOT NULL
+`
+
+func (q *Queries) CancelAccountDeletion(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelAccountDeletion, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const purgeDueAccounts = `-- name: PurgeDueAccounts :many
+DELETE FROM users
+WHERE deletion_due_at <= now()
+RETURNING id, email
+`
+
+type PurgeDueAccountsRow struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) PurgeDueAccounts(ctx context.Context) ([]PurgeDueAccountsRow, error) {
+	rows, err := q.db.QueryContext(ctx, purgeDueAccounts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PurgeDueAccountsRow
+	for rows.Next() {
+		var i PurgeDueAccountsRow
+		if err := rows.Scan(&i.ID, &i.Email); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const scheduleAccountDeletion = `-- name: ScheduleAccountDeletion :exec
+UPDATE users
+SET deletion_due_at = $2, updated_at = now()
+WHERE id = $1
+`
+
+type ScheduleAccountDeletionParams struct {
+	ID            uuid.UUID
+	DeletionDueAt sql.NullTime
+}
+
+func (q *Queries) ScheduleAccountDeletion(ctx context.Context, arg ScheduleAccountDeletionParams) error {
+	_, err := q.db.ExecContext(ctx, scheduleAccountDeletion, arg.ID, arg.DeletionDueAt)
+	return err
+}
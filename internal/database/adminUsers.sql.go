@@ -0,0 +1,167 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: adminUsers.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getUserActivity = `-- name: GetUserActivity :one
+SELECT
+    (SELECT count(*) FROM chirps WHERE chirps.user_id = $1) AS chirps,
+    (SELECT count(DISTINCT family_id) FROM refresh_tokens
+        WHERE refresh_tokens.user_id = $1 AND revoked_at IS NULL AND expires_at > now()) AS sessions,
+    (SELECT count(*) FROM passkeys WHERE passkeys.user_id = $1) AS passkeys,
+    (SELECT count(*) FROM api_keys
+        WHERE api_keys.user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())) AS api_keys
+`
+
+type GetUserActivityRow struct {
+	Chirps   int64
+	Sessions int64
+	Passkeys int64
+	ApiKeys  int64
+}
+
+func (q *Queries) GetUserActivity(ctx context.Context, userID uuid.UUID) (GetUserActivityRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserActivity, userID)
+	var i GetUserActivityRow
+	err := row.Scan(
+		&i.Chirps,
+		&i.Sessions,
+		&i.Passkeys,
+		&i.ApiKeys,
+	)
+	return i, err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, created_at, updated_at, email, hashed_password, token_version, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, suspended_at, suspension_reason, deletion_due_at
+FROM users
+WHERE ($1::text IS NULL OR email ILIKE $1)
+    AND ($2::text IS NULL OR role = $2)
+    AND ($3::bool IS NULL OR (suspended_at IS NOT NULL) = $3)
+ORDER BY created_at DESC, id
+LIMIT $5 OFFSET $4
+`
+
+type SearchUsersParams struct {
+	Email      sql.NullString
+	Role       sql.NullString
+	Suspended  sql.NullBool
+	Skip       int32
+	MaxResults int32
+}
+
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, searchUsers,
+		arg.Email,
+		arg.Role,
+		arg.Suspended,
+		arg.Skip,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.TokenVersion,
+			&i.EmailVerifiedAt,
+			&i.PendingEmail,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastStep,
+			&i.Role,
+			&i.SuspendedAt,
+			&i.SuspensionReason,
+			&i.DeletionDueAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setUserRole = `-- name: SetUserRole :exec
+UPDATE users
+SET role = $2, updated_at = now()
+WHERE id = $1
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) error {
+	_, err := q.db.ExecContext(ctx, setUserRole, arg.ID, arg.Role)
+	return err
+}
+
+const suspendUser = `-- name: SuspendUser :execrows
+UPDATE users
+SET suspended_at = now(), suspension_reason = $2, updated_at = now()
+WHERE id = $1
+`
+
+type SuspendUserParams struct {
+	ID               uuid.UUID
+	SuspensionReason string
+}
+
+func (q *Queries) SuspendUser(ctx context.Context, arg SuspendUserParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, suspendUser, arg.ID, arg.SuspensionReason)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :execrows
+UPDATE users
+SET suspended_at = NULL, suspension_reason = '', updated_at = now()
+WHERE id = $1 AND suspended_at IS NOT NULL
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unsuspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
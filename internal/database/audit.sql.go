@@ -0,0 +1,164 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: audit.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createAuditEvent = `-- name: CreateAuditEvent :exec
+INSERT INTO audit_events(id, created_at, event_type, actor_id, target_id, ip_address, user_agent, payload)
+VALUES(
+    gen_random_uuid(),
+    now(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+)
+`
+
+type CreateAuditEventParams struct {
+	EventType string
+	ActorID   uuid.NullUUID
+	TargetID  uuid.NullUUID
+	IpAddress string
+	UserAgent string
+	Payload   json.RawMessage
+}
+
+func (q *Queries) CreateAuditEvent(ctx context.Context, arg CreateAuditEventParams) error {
+	_, err := q.db.ExecContext(ctx, createAuditEvent,
+		arg.EventType,
+		arg.ActorID,
+		arg.TargetID,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.Payload,
+	)
+	return err
+}
+
+const listAuditEvents = `-- name: ListAuditEvents :many
+SELECT id, created_at, event_type, actor_id, target_id, ip_address, user_agent, payload
+FROM audit_events
+WHERE ($1::TEXT[] IS NULL OR event_type = ANY($1::TEXT[]))
+    AND ($2::UUID IS NULL OR actor_id = $2)
+    AND ($3::UUID IS NULL OR target_id = $3)
+    AND ($4::TIMESTAMP IS NULL OR created_at >= $4)
+    AND ($5::TIMESTAMP IS NULL OR created_at < $5)
+ORDER BY created_at DESC, id
+LIMIT $7 OFFSET $6
+`
+
+type ListAuditEventsParams struct {
+	EventTypes []string
+	ActorID    uuid.NullUUID
+	TargetID   uuid.NullUUID
+	Since      sql.NullTime
+	Until      sql.NullTime
+	Skip       int32
+	MaxResults int32
+}
+
+func (q *Queries) ListAuditEvents(ctx context.Context, arg ListAuditEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listAuditEvents,
+		pq.Array(arg.EventTypes),
+		arg.ActorID,
+		arg.TargetID,
+		arg.Since,
+		arg.Until,
+		arg.Skip,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.EventType,
+			&i.ActorID,
+			&i.TargetID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Payload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSecurityEvents = `-- name: ListSecurityEvents :many
+SELECT id, created_at, event_type, actor_id, target_id, ip_address, user_agent, payload
+FROM audit_events
+WHERE target_id = $1 AND event_type = ANY($2::TEXT[])
+ORDER BY created_at DESC, id
+LIMIT $4 OFFSET $3
+`
+
+type ListSecurityEventsParams struct {
+	TargetID   uuid.NullUUID
+	EventTypes []string
+	Skip       int32
+	MaxResults int32
+}
+
+func (q *Queries) ListSecurityEvents(ctx context.Context, arg ListSecurityEventsParams) ([]AuditEvent, error) {
+	rows, err := q.db.QueryContext(ctx, listSecurityEvents,
+		arg.TargetID,
+		pq.Array(arg.EventTypes),
+		arg.Skip,
+		arg.MaxResults,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEvent
+	for rows.Next() {
+		var i AuditEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.EventType,
+			&i.ActorID,
+			&i.TargetID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.Payload,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
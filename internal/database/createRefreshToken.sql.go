@@ -7,30 +7,46 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at)
+const createRefreshToken = `-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens(token_hash, created_at, updated_at, user_id, family_id, expires_at, user_agent, ip_address, device_name, last_used_at)
 VALUES(
     $1,
     now(),
     now(),
     $2,
-    now() + INTERVAL '60 days'
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    now()
 )
-RETURNING token
 `
 
 type CreateRefreshTokenParams struct {
-	Token  string
-	UserID uuid.UUID
+	TokenHash  string
+	UserID     uuid.UUID
+	FamilyID   uuid.UUID
+	ExpiresAt  time.Time
+	UserAgent  string
+	IpAddress  string
+	DeviceName string
 }
 
-func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (string, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID)
-	var token string
-	err := row.Scan(&token)
-	return token, err
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.FamilyID,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.DeviceName,
+	)
+	return err
 }
@@ -0,0 +1,115 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: emailVerification.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const confirmPendingEmail = `-- name: ConfirmPendingEmail :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = now(), updated_at = now()
+WHERE id = $1 AND pending_email = $2
+`
+
+type ConfirmPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) ConfirmPendingEmail(ctx context.Context, arg ConfirmPendingEmailParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmPendingEmail, arg.ID, arg.PendingEmail)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createEmailVerificationToken = `-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens(token_hash, created_at, user_id, email, expires_at)
+VALUES(
+    $1,
+    now(),
+    $2,
+    $3,
+    $4
+)
+`
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerificationToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.Email,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified_at = now(), updated_at = now()
+WHERE id = $1 AND email = $2
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markEmailVerified, arg.ID, arg.Email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setPendingEmail = `-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = now()
+WHERE id = $1
+`
+
+type SetPendingEmailParams struct {
+	ID           uuid.UUID
+	PendingEmail sql.NullString
+}
+
+func (q *Queries) SetPendingEmail(ctx context.Context, arg SetPendingEmailParams) error {
+	_, err := q.db.ExecContext(ctx, setPendingEmail, arg.ID, arg.PendingEmail)
+	return err
+}
+
+const useEmailVerificationToken = `-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING user_id, email
+`
+
+type UseEmailVerificationTokenRow struct {
+	UserID uuid.UUID
+	Email  string
+}
+
+func (q *Queries) UseEmailVerificationToken(ctx context.Context, tokenHash string) (UseEmailVerificationTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, useEmailVerificationToken, tokenHash)
+	var i UseEmailVerificationTokenRow
+	err := row.Scan(&i.UserID, &i.Email)
+	return i, err
+}
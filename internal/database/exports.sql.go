@@ -0,0 +1,261 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: exports.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const claimExportJob = `-- name: ClaimExportJob :one
+UPDATE export_jobs
+SET status = 'running', started_at = now(), claim_id = gen_random_uuid()
+WHERE id = (
+    SELECT queued.id
+    FROM export_jobs AS queued
+    WHERE queued.status = 'pending' OR (queued.status = 'running' AND queued.started_at < $1)
+    ORDER BY queued.created_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING id, user_id, claim_id
+`
+
+type ClaimExportJobRow struct {
+	ID      uuid.UUID
+	UserID  uuid.UUID
+	ClaimID uuid.NullUUID
+}
+
+// Takes the oldest pending job, or one whose worker seems to have died.
+func (q *Queries) ClaimExportJob(ctx context.Context, staleBefore sql.NullTime) (ClaimExportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, claimExportJob, staleBefore)
+	var i ClaimExportJobRow
+	err := row.Scan(&i.ID, &i.UserID, &i.ClaimID)
+	return i, err
+}
+
+const completeExportJob = `-- name: CompleteExportJob :execrows
+UPDATE export_jobs
+SET status = 'ready', completed_at = now(), expires_at = $3, archive = $4
+WHERE id = $1 AND status = 'running' AND claim_id = $2
+`
+
+type CompleteExportJobParams struct {
+	ID        uuid.UUID
+	ClaimID   uuid.NullUUID
+	ExpiresAt sql.NullTime
+	Archive   []byte
+}
+
+// Only the worker holding the latest claim can finish a job.
+func (q *Queries) CompleteExportJob(ctx context.Context, arg CompleteExportJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, completeExportJob,
+		arg.ID,
+		arg.ClaimID,
+		arg.ExpiresAt,
+		arg.Archive,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createExportJob = `-- name: CreateExportJob :one
+INSERT INTO export_jobs (id, user_id, created_at)
+VALUES (gen_random_uuid(), $1, now())
+RETURNING id, user_id, status, created_at, started_at, completed_at, expires_at, error
+`
+
+type CreateExportJobRow struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+	Error       string
+}
+
+func (q *Queries) CreateExportJob(ctx context.Context, userID uuid.UUID) (CreateExportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, createExportJob, userID)
+	var i CreateExportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+		&i.Error,
+	)
+	return i, err
+}
+
+const deleteExpiredExports = `-- name: DeleteExpiredExports :exec
+DELETE FROM export_jobs
+WHERE expires_at <= now() OR (status = 'failed' AND completed_at < now() - interval '7 days')
+`
+
+func (q *Queries) DeleteExpiredExports(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredExports)
+	return err
+}
+
+const failExportJob = `-- name: FailExportJob :execrows
+UPDATE export_jobs
+SET status = 'failed', completed_at = now(), error = $3
+WHERE id = $1 AND status = 'running' AND claim_id = $2
+`
+
+type FailExportJobParams struct {
+	ID      uuid.UUID
+	ClaimID uuid.NullUUID
+	Error   string
+}
+
+func (q *Queries) FailExportJob(ctx context.Context, arg FailExportJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, failExportJob, arg.ID, arg.ClaimID, arg.Error)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getActiveExportJob = `-- name: GetActiveExportJob :one
+SELECT id, user_id, status, created_at, started_at, completed_at, expires_at, error
+FROM export_jobs
+WHERE user_id = $1 AND status IN ('pending', 'running')
+ORDER BY created_at DESC
+LIMIT 1
+`
+
+type GetActiveExportJobRow struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+	Error       string
+}
+
+func (q *Queries) GetActiveExportJob(ctx context.Context, userID uuid.UUID) (GetActiveExportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, getActiveExportJob, userID)
+	var i GetActiveExportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+		&i.Error,
+	)
+	return i, err
+}
+
+const getChirpsByUser = `-- name: GetChirpsByUser :many
+SELECT id, created_at, updated_at, body, user_id, hidden_at, external_id
+FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) GetChirpsByUser(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.HiddenAt,
+			&i.ExternalID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getExportArchive = `-- name: GetExportArchive :one
+SELECT user_id, archive
+FROM export_jobs
+WHERE id = $1 AND status = 'ready' AND expires_at > now()
+`
+
+type GetExportArchiveRow struct {
+	UserID  uuid.UUID
+	Archive []byte
+}
+
+func (q *Queries) GetExportArchive(ctx context.Context, id uuid.UUID) (GetExportArchiveRow, error) {
+	row := q.db.QueryRowContext(ctx, getExportArchive, id)
+	var i GetExportArchiveRow
+	err := row.Scan(&i.UserID, &i.Archive)
+	return i, err
+}
+
+const getExportJob = `-- name: GetExportJob :one
+SELECT id, user_id, status, created_at, started_at, completed_at, expires_at, error
+FROM export_jobs
+WHERE id = $1 AND user_id = $2
+`
+
+type GetExportJobParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+type GetExportJobRow struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+	Error       string
+}
+
+func (q *Queries) GetExportJob(ctx context.Context, arg GetExportJobParams) (GetExportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, getExportJob, arg.ID, arg.UserID)
+	var i GetExportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.ExpiresAt,
+		&i.Error,
+	)
+	return i, err
+}
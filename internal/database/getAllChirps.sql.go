@@ -10,8 +10,9 @@ import (
 )
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, hidden_at, external_id
 FROM chirps
+WHERE hidden_at IS NULL
 ORDER BY created_at ASC
 `
 
@@ -30,6 +31,8 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.HiddenAt,
+			&i.ExternalID,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,36 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: getRefreshToken.sql
+
+package database
+
+import (
+	"context"
+)
+
+const getRefreshTokenByHash = `-- name: GetRefreshTokenByHash :one
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by_hash, user_agent, ip_address, device_name, last_used_at
+FROM refresh_tokens
+WHERE token_hash = $1
+`
+
+func (q *Queries) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenByHash, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedByHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
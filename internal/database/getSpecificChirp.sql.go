@@ -12,7 +12,7 @@ import (
 )
 
 const getSpecificChirp = `-- name: GetSpecificChirp :one
-SELECT id, created_at, updated_at, body, user_id
+SELECT id, created_at, updated_at, body, user_id, hidden_at, external_id
 FROM chirps
 WHERE id = $1
 `
@@ -26,6 +26,8 @@ func (q *Queries) GetSpecificChirp(ctx context.Context, id uuid.UUID) (Chirp, er
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.HiddenAt,
+		&i.ExternalID,
 	)
 	return i, err
 }
@@ -10,7 +10,7 @@ import (
 )
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password
+SELECT id, created_at, updated_at, email, hashed_password, token_version, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, suspended_at, suspension_reason, deletion_due_at
 FROM users
 WHERE email = $1
 `
@@ -24,6 +24,16 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.DeletionDueAt,
 	)
 	return i, err
 }
@@ -14,11 +14,11 @@ import (
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
 SELECT user_id
 FROM refresh_tokens
-WHERE token = $1 AND revoked_at IS NULL
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now()
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (uuid.UUID, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, tokenHash)
 	var user_id uuid.UUID
 	err := row.Scan(&user_id)
 	return user_id, err
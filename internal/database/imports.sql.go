@@ -0,0 +1,288 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: imports.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const claimImportJob = `-- name: ClaimImportJob :one
+UPDATE import_jobs
+SET status = 'running', started_at = COALESCE(started_at, now()), heartbeat_at = now(), claim_id = gen_random_uuid()
+WHERE id = (
+    SELECT queued.id
+    FROM import_jobs AS queued
+    WHERE queued.status = 'pending' OR (queued.status = 'running' AND queued.heartbeat_at < $1)
+    ORDER BY queued.created_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING id, user_id, claim_id, format, archive, processed_rows, imported_rows, skipped_rows, failed_rows
+`
+
+type ClaimImportJobRow struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	ClaimID       uuid.NullUUID
+	Format        string
+	Archive       []byte
+	ProcessedRows int32
+	ImportedRows  int32
+	SkippedRows   int32
+	FailedRows    int32
+}
+
+// Takes the oldest pending job, or one whose worker stopped reporting
+// progress, which is then picked up where it left off.
+func (q *Queries) ClaimImportJob(ctx context.Context, staleBefore sql.NullTime) (ClaimImportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, claimImportJob, staleBefore)
+	var i ClaimImportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.ClaimID,
+		&i.Format,
+		&i.Archive,
+		&i.ProcessedRows,
+		&i.ImportedRows,
+		&i.SkippedRows,
+		&i.FailedRows,
+	)
+	return i, err
+}
+
+const completeImportJob = `-- name: CompleteImportJob :execrows
+UPDATE import_jobs
+SET status = 'done', completed_at = now(), archive = NULL
+WHERE id = $1 AND status = 'running' AND claim_id = $2
+`
+
+type CompleteImportJobParams struct {
+	ID      uuid.UUID
+	ClaimID uuid.NullUUID
+}
+
+func (q *Queries) CompleteImportJob(ctx context.Context, arg CompleteImportJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, completeImportJob, arg.ID, arg.ClaimID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createImportJob = `-- name: CreateImportJob :one
+INSERT INTO import_jobs (id, user_id, status, format, created_at, heartbeat_at, archive, total_rows, claim_id)
+VALUES (gen_random_uuid(), $1, $2, $3, now(), now(), $4, $5, $6)
+RETURNING id, user_id, status, format, created_at, started_at, completed_at, total_rows, processed_rows, imported_rows, skipped_rows, failed_rows, row_errors, error
+`
+
+type CreateImportJobParams struct {
+	UserID    uuid.UUID
+	Status    string
+	Format    string
+	Archive   []byte
+	TotalRows int32
+	ClaimID   uuid.NullUUID
+}
+
+type CreateImportJobRow struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Status        string
+	Format        string
+	CreatedAt     time.Time
+	StartedAt     sql.NullTime
+	CompletedAt   sql.NullTime
+	TotalRows     int32
+	ProcessedRows int32
+	ImportedRows  int32
+	SkippedRows   int32
+	FailedRows    int32
+	RowErrors     json.RawMessage
+	Error         string
+}
+
+// Jobs the CLI runs itself start out running, so no worker takes them, and
+// come with the claim the CLI writes to them under.
+func (q *Queries) CreateImportJob(ctx context.Context, arg CreateImportJobParams) (CreateImportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, createImportJob,
+		arg.UserID,
+		arg.Status,
+		arg.Format,
+		arg.Archive,
+		arg.TotalRows,
+		arg.ClaimID,
+	)
+	var i CreateImportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.Format,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.TotalRows,
+		&i.ProcessedRows,
+		&i.ImportedRows,
+		&i.SkippedRows,
+		&i.FailedRows,
+		&i.RowErrors,
+		&i.Error,
+	)
+	return i, err
+}
+
+const deleteFinishedImports = `-- name: DeleteFinishedImports :exec
+DELETE FROM import_jobs
+WHERE completed_at < now() - interval '30 days'
+`
+
+func (q *Queries) DeleteFinishedImports(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteFinishedImports)
+	return err
+}
+
+const failImportJob = `-- name: FailImportJob :execrows
+UPDATE import_jobs
+SET status = 'failed', completed_at = now(), archive = NULL, error = $3
+WHERE id = $1 AND status = 'running' AND claim_id = $2
+`
+
+type FailImportJobParams struct {
+	ID      uuid.UUID
+	ClaimID uuid.NullUUID
+	Error   string
+}
+
+func (q *Queries) FailImportJob(ctx context.Context, arg FailImportJobParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, failImportJob, arg.ID, arg.ClaimID, arg.Error)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getImportJob = `-- name: GetImportJob :one
+SELECT id, user_id, status, format, created_at, started_at, completed_at, total_rows, processed_rows, imported_rows, skipped_rows, failed_rows, row_errors, error
+FROM import_jobs
+WHERE id = $1 AND user_id = $2
+`
+
+type GetImportJobParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+type GetImportJobRow struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Status        string
+	Format        string
+	CreatedAt     time.Time
+	StartedAt     sql.NullTime
+	CompletedAt   sql.NullTime
+	TotalRows     int32
+	ProcessedRows int32
+	ImportedRows  int32
+	SkippedRows   int32
+	FailedRows    int32
+	RowErrors     json.RawMessage
+	Error         string
+}
+
+func (q *Queries) GetImportJob(ctx context.Context, arg GetImportJobParams) (GetImportJobRow, error) {
+	row := q.db.QueryRowContext(ctx, getImportJob, arg.ID, arg.UserID)
+	var i GetImportJobRow
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.Format,
+		&i.CreatedAt,
+		&i.StartedAt,
+		&i.CompletedAt,
+		&i.TotalRows,
+		&i.ProcessedRows,
+		&i.ImportedRows,
+		&i.SkippedRows,
+		&i.FailedRows,
+		&i.RowErrors,
+		&i.Error,
+	)
+	return i, err
+}
+
+const importChirp = `-- name: ImportChirp :execrows
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, external_id)
+VALUES (gen_random_uuid(), $1, $1, $2, $3, $4)
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING
+`
+
+type ImportChirpParams struct {
+	CreatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	ExternalID sql.NullString
+}
+
+// Rows already imported under the same external ID are left alone.
+func (q *Queries) ImportChirp(ctx context.Context, arg ImportChirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, importChirp,
+		arg.CreatedAt,
+		arg.Body,
+		arg.UserID,
+		arg.ExternalID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const recordImportProgress = `-- name: RecordImportProgress :execrows
+UPDATE import_jobs
+SET processed_rows = $3,
+    imported_rows = $4,
+    skipped_rows = $5,
+    failed_rows = $6,
+    row_errors = row_errors || $7::jsonb,
+    heartbeat_at = now()
+WHERE id = $1 AND status = 'running' AND claim_id = $2
+`
+
+type RecordImportProgressParams struct {
+	ID            uuid.UUID
+	ClaimID       uuid.NullUUID
+	ProcessedRows int32
+	ImportedRows  int32
+	SkippedRows   int32
+	FailedRows    int32
+	NewRowErrors  json.RawMessage
+}
+
+// Only the worker holding the latest claim can write to a job. Its counts
+// are totals, which it carried on from what the job had when it claimed it.
+func (q *Queries) RecordImportProgress(ctx context.Context, arg RecordImportProgressParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, recordImportProgress,
+		arg.ID,
+		arg.ClaimID,
+		arg.ProcessedRows,
+		arg.ImportedRows,
+		arg.SkippedRows,
+		arg.FailedRows,
+		arg.NewRowErrors,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,140 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: loginFailures.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const clearLoginFailures = `-- name: ClearLoginFailures :execrows
+DELETE FROM login_failures
+WHERE subject = $1
+`
+
+func (q *Queries) ClearLoginFailures(ctx context.Context, subject string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, clearLoginFailures, subject)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getActiveLockouts = `-- name: GetActiveLockouts :many
+SELECT subject, locked_until
+FROM login_failures
+WHERE subject = ANY($1::text[]) AND locked_until > now()
+`
+
+type GetActiveLockoutsRow struct {
+	Subject     string
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) GetActiveLockouts(ctx context.Context, subjects []string) ([]GetActiveLockoutsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getActiveLockouts, pq.Array(subjects))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetActiveLockoutsRow
+	for rows.Next() {
+		var i GetActiveLockoutsRow
+		if err := rows.Scan(&i.Subject, &i.LockedUntil); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listActiveLockouts = `-- name: ListActiveLockouts :many
+SELECT subject, failures, last_failure_at, locked_until
+FROM login_failures
+WHERE locked_until > now()
+ORDER BY locked_until DESC
+`
+
+func (q *Queries) ListActiveLockouts(ctx context.Context) ([]LoginFailure, error) {
+	rows, err := q.db.QueryContext(ctx, listActiveLockouts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LoginFailure
+	for rows.Next() {
+		var i LoginFailure
+		if err := rows.Scan(
+			&i.Subject,
+			&i.Failures,
+			&i.LastFailureAt,
+			&i.LockedUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockLogin = `-- name: LockLogin :exec
+UPDATE login_failures
+SET locked_until = $2
+WHERE subject = $1
+`
+
+type LockLoginParams struct {
+	Subject     string
+	LockedUntil sql.NullTime
+}
+
+func (q *Queries) LockLogin(ctx context.Context, arg LockLoginParams) error {
+	_, err := q.db.ExecContext(ctx, lockLogin, arg.Subject, arg.LockedUntil)
+	return err
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_failures(subject, failures, last_failure_at)
+VALUES(
+    $1,
+    1,
+    now()
+)
+ON CONFLICT (subject) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < $2 THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = now()
+RETURNING failures
+`
+
+type RecordLoginFailureParams struct {
+	Subject     string
+	ResetBefore time.Time
+}
+
+func (q *Queries) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordLoginFailure, arg.Subject, arg.ResetBefore)
+	var failures int32
+	err := row.Scan(&failures)
+	return failures, err
+}
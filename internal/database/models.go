@@ -6,32 +6,255 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-type Chirp struct {
+type ApiKey struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type AuditEvent struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+	EventType string
+	ActorID   uuid.NullUUID
+	TargetID  uuid.NullUUID
+	IpAddress string
+	UserAgent string
+	Payload   json.RawMessage
+}
+
+type Chirp struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Body       string
+	UserID     uuid.UUID
+	HiddenAt   sql.NullTime
+	ExternalID sql.NullString
+}
+
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
 	UserID    uuid.UUID
+	Email     string
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
 }
 
-type RefreshToken struct {
-	Token     string
+type ExportJob struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+	Archive     []byte
+	Error       string
+	ClaimID     uuid.NullUUID
+}
+
+type ImportJob struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	Status        string
+	Format        string
+	CreatedAt     time.Time
+	StartedAt     sql.NullTime
+	HeartbeatAt   sql.NullTime
+	CompletedAt   sql.NullTime
+	Archive       []byte
+	TotalRows     int32
+	ProcessedRows int32
+	ImportedRows  int32
+	SkippedRows   int32
+	FailedRows    int32
+	RowErrors     json.RawMessage
+	Error         string
+	ClaimID       uuid.NullUUID
+}
+
+type LoginFailure struct {
+	Subject       string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+}
+
+type OauthClient struct {
+	ID           string
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectUris []string
+	Scopes       []string
+	CreatedAt    time.Time
+}
+
+type OauthGrant struct {
+	ID                       uuid.UUID
+	ClientID                 string
+	UserID                   uuid.UUID
+	Scopes                   []string
+	RefreshTokenHash         string
+	PreviousRefreshTokenHash sql.NullString
+	CreatedAt                time.Time
+	ExpiresAt                time.Time
+	RevokedAt                sql.NullTime
+}
+
+type OidcLoginCode struct {
+	CodeHash   string
+	UserID     uuid.UUID
+	DeviceName string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+type OidcLoginState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type Passkey struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Aaguid          []byte
+	SignCount       int64
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      sql.NullTime
+}
+
+type PasswordResetToken struct {
+	TokenHash string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 	UserID    uuid.UUID
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
 }
 
-type User struct {
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	CodeHash  string
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash      string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uuid.UUID
+	ExpiresAt      time.Time
+	RevokedAt      sql.NullTime
+	FamilyID       uuid.UUID
+	ReplacedByHash sql.NullString
+	UserAgent      string
+	IpAddress      string
+	DeviceName     string
+	LastUsedAt     time.Time
+}
+
+type Report struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
+	TargetType     string
+	TargetID       uuid.UUID
+	TargetUserID   uuid.UUID
+	Content        string
+	Status         string
+	ClaimedBy      uuid.NullUUID
+	ClaimedAt      sql.NullTime
+	ResolvedBy     uuid.NullUUID
+	ResolvedAt     sql.NullTime
+	Action         string
+	ResolutionNote string
+}
+
+type ReportSubmission struct {
+	ReportID   uuid.UUID
+	ReporterID uuid.UUID
+	Reason     string
+	Details    string
+	CreatedAt  time.Time
+	NotifiedAt sql.NullTime
+}
+
+type UsedToken struct {
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+type User struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Email            string
+	HashedPassword   string
+	TokenVersion     int32
+	EmailVerifiedAt  sql.NullTime
+	PendingEmail     sql.NullString
+	TotpSecret       sql.NullString
+	TotpEnabledAt    sql.NullTime
+	TotpLastStep     int64
+	Role             string
+	SuspendedAt      sql.NullTime
+	SuspensionReason string
+	DeletionDueAt    sql.NullTime
+}
+
+type UserIdentity struct {
+	Issuer      string
+	Subject     string
+	UserID      uuid.UUID
+	Email       string
+	CreatedAt   time.Time
+	LastLoginAt time.Time
+}
+
+type WebauthnCeremony struct {
+	ID        uuid.UUID
+	Kind      string
+	UserID    uuid.NullUUID
+	State     []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
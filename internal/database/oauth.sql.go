@@ -0,0 +1,325 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oauth.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createOAuthAuthorizationCode = `-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateOAuthAuthorizationCodeParams struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+func (q *Queries) CreateOAuthAuthorizationCode(ctx context.Context, arg CreateOAuthAuthorizationCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthAuthorizationCode,
+		arg.CodeHash,
+		arg.ClientID,
+		arg.UserID,
+		arg.RedirectUri,
+		pq.Array(arg.Scopes),
+		arg.CodeChallenge,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createOAuthClient = `-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+RETURNING id, owner_id, name, secret_hash, redirect_uris, scopes, created_at
+`
+
+type CreateOAuthClientParams struct {
+	ID           string
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectUris []string
+	Scopes       []string
+}
+
+func (q *Queries) CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) (OauthClient, error) {
+	row := q.db.QueryRowContext(ctx, createOAuthClient,
+		arg.ID,
+		arg.OwnerID,
+		arg.Name,
+		arg.SecretHash,
+		pq.Array(arg.RedirectUris),
+		pq.Array(arg.Scopes),
+	)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.Name,
+		&i.SecretHash,
+		pq.Array(&i.RedirectUris),
+		pq.Array(&i.Scopes),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createOAuthGrant = `-- name: CreateOAuthGrant :exec
+INSERT INTO oauth_grants (id, client_id, user_id, scopes, refresh_token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, now(), $6)
+`
+
+type CreateOAuthGrantParams struct {
+	ID               uuid.UUID
+	ClientID         string
+	UserID           uuid.UUID
+	Scopes           []string
+	RefreshTokenHash string
+	ExpiresAt        time.Time
+}
+
+func (q *Queries) CreateOAuthGrant(ctx context.Context, arg CreateOAuthGrantParams) error {
+	_, err := q.db.ExecContext(ctx, createOAuthGrant,
+		arg.ID,
+		arg.ClientID,
+		arg.UserID,
+		pq.Array(arg.Scopes),
+		arg.RefreshTokenHash,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const deleteOAuthClient = `-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE owner_id = $1 AND id = $2
+`
+
+type DeleteOAuthClientParams struct {
+	OwnerID uuid.UUID
+	ID      string
+}
+
+func (q *Queries) DeleteOAuthClient(ctx context.Context, arg DeleteOAuthClientParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOAuthClient, arg.OwnerID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getOAuthClient = `-- name: GetOAuthClient :one
+SELECT id, owner_id, name, secret_hash, redirect_uris, scopes, created_at
+FROM oauth_clients
+WHERE id = $1
+`
+
+func (q *Queries) GetOAuthClient(ctx context.Context, id string) (OauthClient, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthClient, id)
+	var i OauthClient
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.Name,
+		&i.SecretHash,
+		pq.Array(&i.RedirectUris),
+		pq.Array(&i.Scopes),
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getOAuthGrant = `-- name: GetOAuthGrant :one
+SELECT id, client_id, user_id, scopes, refresh_token_hash, previous_refresh_token_hash, created_at, expires_at, revoked_at
+FROM oauth_grants
+WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+`
+
+func (q *Queries) GetOAuthGrant(ctx context.Context, id uuid.UUID) (OauthGrant, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthGrant, id)
+	var i OauthGrant
+	err := row.Scan(
+		&i.ID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getOAuthGrantByRefreshToken = `-- name: GetOAuthGrantByRefreshToken :one
+SELECT id, client_id, user_id, scopes, refresh_token_hash, previous_refresh_token_hash, created_at, expires_at, revoked_at
+FROM oauth_grants
+WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > now()
+`
+
+func (q *Queries) GetOAuthGrantByRefreshToken(ctx context.Context, refreshTokenHash string) (OauthGrant, error) {
+	row := q.db.QueryRowContext(ctx, getOAuthGrantByRefreshToken, refreshTokenHash)
+	var i OauthGrant
+	err := row.Scan(
+		&i.ID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const listOAuthClients = `-- name: ListOAuthClients :many
+SELECT id, owner_id, name, secret_hash, redirect_uris, scopes, created_at
+FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC
+`
+
+func (q *Queries) ListOAuthClients(ctx context.Context, ownerID uuid.UUID) ([]OauthClient, error) {
+	rows, err := q.db.QueryContext(ctx, listOAuthClients, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OauthClient
+	for rows.Next() {
+		var i OauthClient
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.Name,
+			&i.SecretHash,
+			pq.Array(&i.RedirectUris),
+			pq.Array(&i.Scopes),
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeAllOAuthGrants = `-- name: RevokeAllOAuthGrants :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllOAuthGrants(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllOAuthGrants, userID)
+	return err
+}
+
+const revokeOAuthGrant = `-- name: RevokeOAuthGrant :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeOAuthGrant(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeOAuthGrant, id)
+	return err
+}
+
+const revokeOAuthGrantByPreviousRefreshToken = `-- name: RevokeOAuthGrantByPreviousRefreshToken :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeOAuthGrantByPreviousRefreshToken(ctx context.Context, previousRefreshTokenHash sql.NullString) error {
+	_, err := q.db.ExecContext(ctx, revokeOAuthGrantByPreviousRefreshToken, previousRefreshTokenHash)
+	return err
+}
+
+const rotateOAuthRefreshToken = `-- name: RotateOAuthRefreshToken :one
+UPDATE oauth_grants
+SET previous_refresh_token_hash = refresh_token_hash,
+    refresh_token_hash = $1,
+    expires_at = $2
+WHERE refresh_token_hash = $3 AND revoked_at IS NULL AND expires_at > now()
+RETURNING id, client_id, user_id, scopes, refresh_token_hash, previous_refresh_token_hash, created_at, expires_at, revoked_at
+`
+
+type RotateOAuthRefreshTokenParams struct {
+	NewHash   string
+	ExpiresAt time.Time
+	OldHash   string
+}
+
+func (q *Queries) RotateOAuthRefreshToken(ctx context.Context, arg RotateOAuthRefreshTokenParams) (OauthGrant, error) {
+	row := q.db.QueryRowContext(ctx, rotateOAuthRefreshToken, arg.NewHash, arg.ExpiresAt, arg.OldHash)
+	var i OauthGrant
+	err := row.Scan(
+		&i.ID,
+		&i.ClientID,
+		&i.UserID,
+		pq.Array(&i.Scopes),
+		&i.RefreshTokenHash,
+		&i.PreviousRefreshTokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const useOAuthAuthorizationCode = `-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = now()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at
+`
+
+func (q *Queries) UseOAuthAuthorizationCode(ctx context.Context, codeHash string) (OauthAuthorizationCode, error) {
+	row := q.db.QueryRowContext(ctx, useOAuthAuthorizationCode, codeHash)
+	var i OauthAuthorizationCode
+	err := row.Scan(
+		&i.CodeHash,
+		&i.ClientID,
+		&i.UserID,
+		&i.RedirectUri,
+		pq.Array(&i.Scopes),
+		&i.CodeChallenge,
+		&i.ExpiresAt,
+		&i.UsedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,219 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oidc.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createExternalUser = `-- name: CreateExternalUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified_at)
+VALUES (
+    gen_random_uuid(),
+    now(),
+    now(),
+    $1,
+    '',
+    now()
+)
+RETURNING id, created_at, updated_at, email, hashed_password, token_version, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, suspended_at, suspension_reason, deletion_due_at
+`
+
+func (q *Queries) CreateExternalUser(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, createExternalUser, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TokenVersion,
+		&i.EmailVerifiedAt,
+		&i.PendingEmail,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.Role,
+		&i.SuspendedAt,
+		&i.SuspensionReason,
+		&i.DeletionDueAt,
+	)
+	return i, err
+}
+
+const createOIDCLoginCode = `-- name: CreateOIDCLoginCode :exec
+INSERT INTO oidc_login_codes(code_hash, user_id, device_name, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    now(),
+    $4
+)
+`
+
+type CreateOIDCLoginCodeParams struct {
+	CodeHash   string
+	UserID     uuid.UUID
+	DeviceName string
+	ExpiresAt  time.Time
+}
+
+func (q *Queries) CreateOIDCLoginCode(ctx context.Context, arg CreateOIDCLoginCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCLoginCode,
+		arg.CodeHash,
+		arg.UserID,
+		arg.DeviceName,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createOIDCLoginState = `-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states(state_hash, nonce, code_verifier, device_name, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    $5
+)
+`
+
+type CreateOIDCLoginStateParams struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	ExpiresAt    time.Time
+}
+
+func (q *Queries) CreateOIDCLoginState(ctx context.Context, arg CreateOIDCLoginStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCLoginState,
+		arg.StateHash,
+		arg.Nonce,
+		arg.CodeVerifier,
+		arg.DeviceName,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO user_identities(issuer, subject, user_id, email, created_at, last_login_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    now()
+)
+`
+
+type CreateUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  uuid.UUID
+	Email   string
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, createUserIdentity,
+		arg.Issuer,
+		arg.Subject,
+		arg.UserID,
+		arg.Email,
+	)
+	return err
+}
+
+const deleteExpiredOIDCLoginCodes = `-- name: DeleteExpiredOIDCLoginCodes :exec
+DELETE FROM oidc_login_codes
+WHERE expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredOIDCLoginCodes(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCLoginCodes)
+	return err
+}
+
+const deleteExpiredOIDCLoginStates = `-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredOIDCLoginStates(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCLoginStates)
+	return err
+}
+
+const getUserByIdentity = `-- name: GetUserByIdentity :one
+UPDATE user_identities
+SET last_login_at = now()
+WHERE issuer = $1 AND subject = $2
+RETURNING user_id
+`
+
+type GetUserByIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserByIdentity(ctx context.Context, arg GetUserByIdentityParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getUserByIdentity, arg.Issuer, arg.Subject)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const useOIDCLoginCode = `-- name: UseOIDCLoginCode :one
+DELETE FROM oidc_login_codes
+WHERE code_hash = $1 AND expires_at > now()
+RETURNING user_id, device_name
+`
+
+type UseOIDCLoginCodeRow struct {
+	UserID     uuid.UUID
+	DeviceName string
+}
+
+func (q *Queries) UseOIDCLoginCode(ctx context.Context, codeHash string) (UseOIDCLoginCodeRow, error) {
+	row := q.db.QueryRowContext(ctx, useOIDCLoginCode, codeHash)
+	var i UseOIDCLoginCodeRow
+	err := row.Scan(&i.UserID, &i.DeviceName)
+	return i, err
+}
+
+const useOIDCLoginState = `-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1
+RETURNING nonce, code_verifier, device_name, expires_at
+`
+
+type UseOIDCLoginStateRow struct {
+	Nonce        string
+	CodeVerifier string
+	DeviceName   string
+	ExpiresAt    time.Time
+}
+
+func (q *Queries) UseOIDCLoginState(ctx context.Context, stateHash string) (UseOIDCLoginStateRow, error) {
+	row := q.db.QueryRowContext(ctx, useOIDCLoginState, stateHash)
+	var i UseOIDCLoginStateRow
+	err := row.Scan(
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.DeviceName,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
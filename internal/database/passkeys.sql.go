@@ -0,0 +1,232 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: passkeys.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createPasskey = `-- name: CreatePasskey :one
+INSERT INTO passkeys(id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at)
+VALUES(
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    now()
+)
+RETURNING id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at, last_used_at
+`
+
+type CreatePasskeyParams struct {
+	UserID          uuid.UUID
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	Aaguid          []byte
+	SignCount       int64
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+}
+
+func (q *Queries) CreatePasskey(ctx context.Context, arg CreatePasskeyParams) (Passkey, error) {
+	row := q.db.QueryRowContext(ctx, createPasskey,
+		arg.UserID,
+		arg.Name,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.AttestationType,
+		arg.Aaguid,
+		arg.SignCount,
+		pq.Array(arg.Transports),
+		arg.BackupEligible,
+		arg.BackupState,
+	)
+	var i Passkey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.CredentialID,
+		&i.PublicKey,
+		&i.AttestationType,
+		&i.Aaguid,
+		&i.SignCount,
+		pq.Array(&i.Transports),
+		&i.BackupEligible,
+		&i.BackupState,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createWebAuthnCeremony = `-- name: CreateWebAuthnCeremony :exec
+INSERT INTO webauthn_ceremonies(id, kind, user_id, state, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    $5
+)
+`
+
+type CreateWebAuthnCeremonyParams struct {
+	ID        uuid.UUID
+	Kind      string
+	UserID    uuid.NullUUID
+	State     []byte
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreateWebAuthnCeremony(ctx context.Context, arg CreateWebAuthnCeremonyParams) error {
+	_, err := q.db.ExecContext(ctx, createWebAuthnCeremony,
+		arg.ID,
+		arg.Kind,
+		arg.UserID,
+		arg.State,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const deleteExpiredWebAuthnCeremonies = `-- name: DeleteExpiredWebAuthnCeremonies :exec
+DELETE FROM webauthn_ceremonies
+WHERE expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredWebAuthnCeremonies(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredWebAuthnCeremonies)
+	return err
+}
+
+const deletePasskey = `-- name: DeletePasskey :execrows
+DELETE FROM passkeys
+WHERE id = $1 AND user_id = $2
+`
+
+type DeletePasskeyParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) DeletePasskey(ctx context.Context, arg DeletePasskeyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deletePasskey, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const hasPasskeys = `-- name: HasPasskeys :one
+SELECT EXISTS (
+    SELECT 1
+    FROM passkeys
+    WHERE user_id = $1
+)
+`
+
+func (q *Queries) HasPasskeys(ctx context.Context, userID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, hasPasskeys, userID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listPasskeys = `-- name: ListPasskeys :many
+SELECT id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at, last_used_at
+FROM passkeys
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) ListPasskeys(ctx context.Context, userID uuid.UUID) ([]Passkey, error) {
+	rows, err := q.db.QueryContext(ctx, listPasskeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Passkey
+	for rows.Next() {
+		var i Passkey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.AttestationType,
+			&i.Aaguid,
+			&i.SignCount,
+			pq.Array(&i.Transports),
+			&i.BackupEligible,
+			&i.BackupState,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const usePasskey = `-- name: UsePasskey :exec
+UPDATE passkeys
+SET sign_count = $2, backup_state = $3, last_used_at = now()
+WHERE credential_id = $1
+`
+
+type UsePasskeyParams struct {
+	CredentialID []byte
+	SignCount    int64
+	BackupState  bool
+}
+
+func (q *Queries) UsePasskey(ctx context.Context, arg UsePasskeyParams) error {
+	_, err := q.db.ExecContext(ctx, usePasskey, arg.CredentialID, arg.SignCount, arg.BackupState)
+	return err
+}
+
+const useWebAuthnCeremony = `-- name: UseWebAuthnCeremony :one
+DELETE FROM webauthn_ceremonies
+WHERE id = $1 AND kind = $2 AND user_id IS NOT DISTINCT FROM $3 AND expires_at > now()
+RETURNING state
+`
+
+type UseWebAuthnCeremonyParams struct {
+	ID     uuid.UUID
+	Kind   string
+	UserID uuid.NullUUID
+}
+
+func (q *Queries) UseWebAuthnCeremony(ctx context.Context, arg UseWebAuthnCeremonyParams) ([]byte, error) {
+	row := q.db.QueryRowContext(ctx, useWebAuthnCeremony, arg.ID, arg.Kind, arg.UserID)
+	var state []byte
+	err := row.Scan(&state)
+	return state, err
+}
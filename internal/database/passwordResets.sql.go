@@ -0,0 +1,79 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: passwordResets.sql
+
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const createPasswordResetToken = `-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens(token_hash, created_at, user_id, expires_at)
+VALUES(
+    $1,
+    now(),
+    $2,
+    $3
+)
+`
+
+type CreatePasswordResetTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+}
+
+func (q *Queries) CreatePasswordResetToken(ctx context.Context, arg CreatePasswordResetTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createPasswordResetToken, arg.TokenHash, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const hasRecentPasswordReset = `-- name: HasRecentPasswordReset :one
+SELECT EXISTS (
+    SELECT 1
+    FROM password_reset_tokens
+    WHERE user_id = $1 AND created_at > now() - make_interval(secs => $2::float8)
+)
+`
+
+type HasRecentPasswordResetParams struct {
+	UserID        uuid.UUID
+	WithinSeconds float64
+}
+
+func (q *Queries) HasRecentPasswordReset(ctx context.Context, arg HasRecentPasswordResetParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, hasRecentPasswordReset, arg.UserID, arg.WithinSeconds)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const invalidatePasswordResetTokens = `-- name: InvalidatePasswordResetTokens :exec
+UPDATE password_reset_tokens
+SET used_at = now()
+WHERE user_id = $1 AND used_at IS NULL
+`
+
+func (q *Queries) InvalidatePasswordResetTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, invalidatePasswordResetTokens, userID)
+	return err
+}
+
+const usePasswordResetToken = `-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING user_id
+`
+
+func (q *Queries) UsePasswordResetToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, usePasswordResetToken, tokenHash)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
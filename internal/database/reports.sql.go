@@ -0,0 +1,333 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: reports.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const addReportSubmission = `-- name: AddReportSubmission :execrows
+INSERT INTO report_submissions (report_id, reporter_id, reason, details, created_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (report_id, reporter_id) DO NOTHING
+`
+
+type AddReportSubmissionParams struct {
+	ReportID   uuid.UUID
+	ReporterID uuid.UUID
+	Reason     string
+	Details    string
+}
+
+func (q *Queries) AddReportSubmission(ctx context.Context, arg AddReportSubmissionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, addReportSubmission,
+		arg.ReportID,
+		arg.ReporterID,
+		arg.Reason,
+		arg.Details,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimReport = `-- name: ClaimReport :execrows
+UPDATE reports
+SET status = 'claimed', claimed_by = $1::UUID, claimed_at = now(), updated_at = now()
+WHERE id = $2 AND (status = 'open' OR (status = 'claimed' AND claimed_by = $1::UUID))
+`
+
+type ClaimReportParams struct {
+	ModeratorID uuid.UUID
+	ID          uuid.UUID
+}
+
+func (q *Queries) ClaimReport(ctx context.Context, arg ClaimReportParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, claimReport, arg.ModeratorID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getReport = `-- name: GetReport :one
+SELECT id, created_at, updated_at, target_type, target_id, target_user_id, content, status, claimed_by, claimed_at, resolved_by, resolved_at, action, resolution_note
+FROM reports
+WHERE id = $1
+`
+
+func (q *Queries) GetReport(ctx context.Context, id uuid.UUID) (Report, error) {
+	row := q.db.QueryRowContext(ctx, getReport, id)
+	var i Report
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.TargetType,
+		&i.TargetID,
+		&i.TargetUserID,
+		&i.Content,
+		&i.Status,
+		&i.ClaimedBy,
+		&i.ClaimedAt,
+		&i.ResolvedBy,
+		&i.ResolvedAt,
+		&i.Action,
+		&i.ResolutionNote,
+	)
+	return i, err
+}
+
+const hideChirp = `-- name: HideChirp :execrows
+UPDATE chirps
+SET hidden_at = now(), updated_at = now()
+WHERE id = $1 AND hidden_at IS NULL
+`
+
+func (q *Queries) HideChirp(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, hideChirp, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const listReportSubmissions = `-- name: ListReportSubmissions :many
+SELECT report_id, reporter_id, reason, details, created_at, notified_at
+FROM report_submissions
+WHERE report_id = $1
+ORDER BY created_at ASC
+`
+
+func (q *Queries) ListReportSubmissions(ctx context.Context, reportID uuid.UUID) ([]ReportSubmission, error) {
+	rows, err := q.db.QueryContext(ctx, listReportSubmissions, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReportSubmission
+	for rows.Next() {
+		var i ReportSubmission
+		if err := rows.Scan(
+			&i.ReportID,
+			&i.ReporterID,
+			&i.Reason,
+			&i.Details,
+			&i.CreatedAt,
+			&i.NotifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReportersToNotify = `-- name: ListReportersToNotify :many
+SELECT users.id, users.email
+FROM report_submissions
+JOIN users ON users.id = report_submissions.reporter_id
+WHERE report_submissions.report_id = $1 AND report_submissions.notified_at IS NULL
+`
+
+type ListReportersToNotifyRow struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) ListReportersToNotify(ctx context.Context, reportID uuid.UUID) ([]ListReportersToNotifyRow, error) {
+	rows, err := q.db.QueryContext(ctx, listReportersToNotify, reportID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReportersToNotifyRow
+	for rows.Next() {
+		var i ListReportersToNotifyRow
+		if err := rows.Scan(&i.ID, &i.Email); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listReports = `-- name: ListReports :many
+SELECT reports.id, reports.created_at, reports.updated_at, reports.target_type, reports.target_id, reports.target_user_id, reports.content, reports.status, reports.claimed_by, reports.claimed_at, reports.resolved_by, reports.resolved_at, reports.action, reports.resolution_note,
+    count(report_submissions.reporter_id) AS reporters,
+    array_remove(array_agg(DISTINCT report_submissions.reason), NULL)::TEXT[] AS reasons
+FROM reports
+LEFT JOIN report_submissions ON report_submissions.report_id = reports.id
+WHERE reports.status = ANY($1::TEXT[])
+GROUP BY reports.id
+ORDER BY count(report_submissions.reporter_id) DESC, reports.created_at ASC
+LIMIT $3 OFFSET $2
+`
+
+type ListReportsParams struct {
+	Statuses   []string
+	Skip       int32
+	MaxResults int32
+}
+
+type ListReportsRow struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	TargetType     string
+	TargetID       uuid.UUID
+	TargetUserID   uuid.UUID
+	Content        string
+	Status         string
+	ClaimedBy      uuid.NullUUID
+	ClaimedAt      sql.NullTime
+	ResolvedBy     uuid.NullUUID
+	ResolvedAt     sql.NullTime
+	Action         string
+	ResolutionNote string
+	Reporters      int64
+	Reasons        []string
+}
+
+func (q *Queries) ListReports(ctx context.Context, arg ListReportsParams) ([]ListReportsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listReports, pq.Array(arg.Statuses), arg.Skip, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListReportsRow
+	for rows.Next() {
+		var i ListReportsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TargetType,
+			&i.TargetID,
+			&i.TargetUserID,
+			&i.Content,
+			&i.Status,
+			&i.ClaimedBy,
+			&i.ClaimedAt,
+			&i.ResolvedBy,
+			&i.ResolvedAt,
+			&i.Action,
+			&i.ResolutionNote,
+			&i.Reporters,
+			pq.Array(&i.Reasons),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markReporterNotified = `-- name: MarkReporterNotified :exec
+UPDATE report_submissions
+SET notified_at = now()
+WHERE report_id = $1 AND reporter_id = $2
+`
+
+type MarkReporterNotifiedParams struct {
+	ReportID   uuid.UUID
+	ReporterID uuid.UUID
+}
+
+func (q *Queries) MarkReporterNotified(ctx context.Context, arg MarkReporterNotifiedParams) error {
+	_, err := q.db.ExecContext(ctx, markReporterNotified, arg.ReportID, arg.ReporterID)
+	return err
+}
+
+const openReport = `-- name: OpenReport :one
+INSERT INTO reports (id, created_at, updated_at, target_type, target_id, target_user_id, content)
+VALUES (
+    gen_random_uuid(),
+    now(),
+    now(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (target_type, target_id) WHERE status <> 'resolved'
+DO UPDATE SET updated_at = now()
+RETURNING id
+`
+
+type OpenReportParams struct {
+	TargetType   string
+	TargetID     uuid.UUID
+	TargetUserID uuid.UUID
+	Content      string
+}
+
+func (q *Queries) OpenReport(ctx context.Context, arg OpenReportParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, openReport,
+		arg.TargetType,
+		arg.TargetID,
+		arg.TargetUserID,
+		arg.Content,
+	)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
+}
+
+const resolveReport = `-- name: ResolveReport :execrows
+UPDATE reports
+SET status = 'resolved',
+    resolved_by = $1::UUID,
+    resolved_at = now(),
+    action = $2,
+    resolution_note = $3,
+    updated_at = now()
+WHERE id = $4 AND status = 'claimed' AND claimed_by = $1::UUID
+`
+
+type ResolveReportParams struct {
+	ModeratorID uuid.UUID
+	Action      string
+	Note        string
+	ID          uuid.UUID
+}
+
+func (q *Queries) ResolveReport(ctx context.Context, arg ResolveReportParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, resolveReport,
+		arg.ModeratorID,
+		arg.Action,
+		arg.Note,
+		arg.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -7,15 +7,25 @@ package database
 
 import (
 	"context"
+
+	"github.com/google/uuid"
 )
 
-const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+const revokeRefreshToken = `-- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET updated_at = now(), revoked_at = now()
-WHERE token = $1
+WHERE token_hash = $1 AND revoked_at IS NULL
+RETURNING user_id, family_id
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
-	return err
+type RevokeRefreshTokenRow struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeRefreshToken(ctx context.Context, tokenHash string) (RevokeRefreshTokenRow, error) {
+	row := q.db.QueryRowContext(ctx, revokeRefreshToken, tokenHash)
+	var i RevokeRefreshTokenRow
+	err := row.Scan(&i.UserID, &i.FamilyID)
+	return i, err
 }
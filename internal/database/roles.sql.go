@@ -0,0 +1,50 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: roles.sql
+
+package database
+
+import (
+	"context"
+)
+
+const bootstrapAdmin = `-- name: BootstrapAdmin :execrows
+UPDATE users
+SET role = 'admin', token_version = token_version + 1, updated_at = now()
+WHERE users.email = $1 AND NOT EXISTS (
+    SELECT 1
+    FROM users AS admins
+    WHERE admins.role = 'admin'
+)
+`
+
+func (q *Queries) BootstrapAdmin(ctx context.Context, email string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, bootstrapAdmin, email)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const countActiveAdmins = `-- name: CountActiveAdmins :one
+SELECT count(*)
+FROM users
+WHERE role = 'admin' AND suspended_at IS NULL AND deletion_due_at IS NULL
+`
+
+func (q *Queries) CountActiveAdmins(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countActiveAdmins)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const lockAdmins = `-- name: LockAdmins :exec
+SELECT pg_advisory_xact_lock(hashtext('chirpy.admins'))
+`
+
+func (q *Queries) LockAdmins(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, lockAdmins)
+	return err
+}
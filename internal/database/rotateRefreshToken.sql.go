@@ -0,0 +1,43 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: rotateRefreshToken.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+UPDATE refresh_tokens
+SET updated_at = now(), revoked_at = now(), replaced_by_hash = $2
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now()
+RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, replaced_by_hash, user_agent, ip_address, device_name, last_used_at
+`
+
+type RotateRefreshTokenParams struct {
+	TokenHash      string
+	ReplacedByHash sql.NullString
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken, arg.TokenHash, arg.ReplacedByHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ReplacedByHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.DeviceName,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
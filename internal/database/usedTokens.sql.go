@@ -0,0 +1,40 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: usedTokens.sql
+
+package database
+
+import (
+	"context"
+	"time"
+)
+
+const deleteExpiredUsedTokens = `-- name: DeleteExpiredUsedTokens :exec
+DELETE FROM used_tokens
+WHERE expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredUsedTokens(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredUsedTokens)
+	return err
+}
+
+const useToken = `-- name: UseToken :execrows
+INSERT INTO used_tokens(token_hash, expires_at)
+VALUES($1, $2)
+ON CONFLICT (token_hash) DO NOTHING
+`
+
+type UseTokenParams struct {
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) UseToken(ctx context.Context, arg UseTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useToken, arg.TokenHash, arg.ExpiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
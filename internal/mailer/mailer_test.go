@@ -0,0 +1,85 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := LogMailer{Dir: dir, From: "chirpy@localhost"}
+
+	err := m.Send(context.Background(), Message{
+		To:      "walt@example.com",
+		Subject: "Hello",
+		Body:    "first line\nsecond line",
+	})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Didn't get one message: Got %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	for _, want := range []string{
+		"From: chirpy@localhost\r\n",
+		"To: walt@example.com\r\n",
+		"Subject: Hello\r\n",
+		"\r\n\r\nfirst line\r\nsecond line",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Message is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := LogMailer{From: "chirpy@localhost"}
+	err := m.Send(context.Background(), Message{
+		To:      "walt@example.com\r\nBcc: everyone@example.com",
+		Subject: "Hello",
+	})
+	if err != ErrHeaderInjection {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrHeaderInjection)
+	}
+}
+
+// TestSMTPTimeout sends to a server that never says hello, which has to give
+// up once the context is done rather than wait for it.
+func TestSMTPTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	m := SMTPMailer{Host: host, Port: port, From: "chirpy@localhost"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = m.Send(ctx, Message{To: "walt@example.com", Subject: "Hello"})
+	if err == nil {
+		t.Errorf("Error generated: Got nil, expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Didn't give up in time: Took %s", elapsed)
+	}
+}
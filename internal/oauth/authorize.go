@@ -0,0 +1,282 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// authorizeRequest is a validated request to the authorization endpoint.
+type authorizeRequest struct {
+	Client        Client
+	RedirectURI   string
+	State         string
+	CodeChallenge string
+	Scopes        []string
+}
+
+// authorizeError is a rejected authorization request. Errors found before
+// the redirect URI is known to belong to the client are shown to the user;
+// redirecting them would make the server an open redirector. The rest are
+// sent back to the client.
+type authorizeError struct {
+	redirect    bool
+	code        string
+	description string
+}
+
+// A PKCE code challenge is an unpadded base64url SHA-256, 43 characters.
+var codeChallengePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+
+func (s *Server) parseAuthorizeRequest(ctx context.Context, params url.Values) (authorizeRequest, *authorizeError, error) {
+	client, err := s.Store.GetClient(ctx, params.Get("client_id"))
+	if errors.Is(err, ErrNotFound) {
+		return authorizeRequest{}, &authorizeError{code: "invalid_client", description: "Unknown client"}, nil
+	}
+	if err != nil {
+		return authorizeRequest{}, nil, err
+	}
+
+	redirectURI := params.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return authorizeRequest{}, &authorizeError{code: "invalid_request", description: "The redirect URI isn't registered for this client"}, nil
+	}
+
+	request := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         params.Get("state"),
+		CodeChallenge: params.Get("code_challenge"),
+		Scopes:        strings.Fields(params.Get("scope")),
+	}
+	if params.Get("response_type") != "code" {
+		return request, &authorizeError{redirect: true, code: "unsupported_response_type", description: "Only the code response type is supported"}, nil
+	}
+	if !codeChallengePattern.MatchString(request.CodeChallenge) {
+		return request, &authorizeError{redirect: true, code: "invalid_request", description: "A PKCE code_challenge is required"}, nil
+	}
+	if params.Get("code_challenge_method") != "S256" {
+		return request, &authorizeError{redirect: true, code: "invalid_request", description: "code_challenge_method must be S256"}, nil
+	}
+	if len(request.Scopes) == 0 {
+		request.Scopes = client.Scopes
+	}
+	for _, scope := range request.Scopes {
+		if _, ok := s.Scopes[scope]; !ok || !slices.Contains(client.Scopes, scope) {
+			return request, &authorizeError{redirect: true, code: "invalid_scope", description: "Scope " + scope + " isn't available to this client"}, nil
+		}
+	}
+	return request, nil, nil
+}
+
+// handleAuthorize shows the consent page.
+func (s *Server) handleAuthorize(w http.ResponseWriter, req *http.Request) {
+	request, authErr, err := s.parseAuthorizeRequest(req.Context(), req.URL.Query())
+	if err != nil {
+		log.Printf("Error handling authorization request: %s", err)
+		http.Error(w, "Couldn't handle the authorization request", 500)
+		return
+	}
+	if authErr != nil {
+		s.rejectAuthorization(w, req, request, authErr)
+		return
+	}
+	s.renderConsent(w, 200, request, "")
+}
+
+// handleConsent takes the answer from the consent page. Approving needs the
+// user's credentials, which keeps a page that can't see the user's session
+// from approving on their behalf.
+func (s *Server) handleConsent(w http.ResponseWriter, req *http.Request) {
+	err := req.ParseForm()
+	if err != nil {
+		http.Error(w, "Couldn't read the form", 400)
+		return
+	}
+	request, authErr, err := s.parseAuthorizeRequest(req.Context(), req.PostForm)
+	if err != nil {
+		log.Printf("Error handling authorization request: %s", err)
+		http.Error(w, "Couldn't handle the authorization request", 500)
+		return
+	}
+	if authErr != nil {
+		s.rejectAuthorization(w, req, request, authErr)
+		return
+	}
+
+	if req.PostForm.Get("decision") != "allow" {
+		s.rejectAuthorization(w, req, request, &authorizeError{redirect: true, code: "access_denied", description: "The user denied access"})
+		return
+	}
+
+	userID, err := s.Users.Authenticate(req, req.PostForm.Get("email"), req.PostForm.Get("password"), req.PostForm.Get("code"))
+	var loginErr *LoginError
+	if errors.As(err, &loginErr) {
+		s.renderConsent(w, 401, request, loginErr.Message)
+		return
+	}
+	if err != nil {
+		log.Printf("Error authenticating on the consent page: %s", err)
+		http.Error(w, "Couldn't sign you in", 500)
+		return
+	}
+
+	code, err := s.issueCode(req.Context(), request, userID)
+	if err != nil {
+		log.Printf("Error issuing authorization code: %s", err)
+		http.Error(w, "Couldn't complete the authorization", 500)
+		return
+	}
+	redirectToClient(w, req, request, url.Values{"code": {code}})
+}
+
+func (s *Server) issueCode(ctx context.Context, request authorizeRequest, userID uuid.UUID) (string, error) {
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	err = s.Store.CreateAuthorizationCode(ctx, AuthorizationCode{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      request.Client.ID,
+		UserID:        userID,
+		RedirectURI:   request.RedirectURI,
+		Scopes:        request.Scopes,
+		CodeChallenge: request.CodeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(s.CodeTTL),
+	})
+	return code, err
+}
+
+func (s *Server) rejectAuthorization(w http.ResponseWriter, req *http.Request, request authorizeRequest, authErr *authorizeError) {
+	if !authErr.redirect {
+		setPageHeaders(w)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(400)
+		err := errorPage.Execute(w, authErr.description)
+		if err != nil {
+			log.Printf("Error rendering the authorization error page: %s", err)
+		}
+		return
+	}
+	redirectToClient(w, req, request, url.Values{
+		"error":             {authErr.code},
+		"error_description": {authErr.description},
+	})
+}
+
+// redirectToClient sends the browser back to the client with params and the
+// client's state added to the redirect URI.
+func redirectToClient(w http.ResponseWriter, req *http.Request, request authorizeRequest, params url.Values) {
+	target, err := url.Parse(request.RedirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect URI", 500)
+		return
+	}
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if request.State != "" {
+		query.Set("state", request.State)
+	}
+	target.RawQuery = query.Encode()
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, req, target.String(), 303)
+}
+
+// setPageHeaders stops the pages from being cached or framed. A framed
+// consent page could be clickjacked into approving.
+func setPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+}
+
+type consentScope struct {
+	Name        string
+	Description string
+}
+
+type consentPage struct {
+	Request authorizeRequest
+	Scope   string
+	Scopes  []consentScope
+	Error   string
+}
+
+func (s *Server) renderConsent(w http.ResponseWriter, status int, request authorizeRequest, message string) {
+	page := consentPage{
+		Request: request,
+		Scope:   strings.Join(request.Scopes, " "),
+		Error:   message,
+	}
+	for _, scope := range request.Scopes {
+		page.Scopes = append(page.Scopes, consentScope{Name: scope, Description: s.Scopes[scope]})
+	}
+	setPageHeaders(w)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := consentTemplate.Execute(w, page)
+	if err != nil {
+		log.Printf("Error rendering the consent page: %s", err)
+	}
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.Request.Client.Name}}</title>
+<style>body { font-family: sans-serif; max-width: 28rem; margin: 2rem auto; } label { display: block; margin: 0.5rem 0; } .error { color: #b00; }</style>
+</head>
+<body>
+<h1>Authorize {{.Request.Client.Name}}</h1>
+<p>{{.Request.Client.Name}} wants to access your Chirpy account. It will be able to:</p>
+<ul>
+{{range .Scopes}}<li><strong>{{.Name}}</strong>: {{.Description}}</li>
+{{end}}</ul>
+<p>You will be sent back to {{.Request.RedirectURI}}.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.Request.Client.ID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<label>Email <input type="email" name="email" autocomplete="username"></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+<label>Authenticator code, if you use one <input type="text" name="code" autocomplete="one-time-code" inputmode="numeric"></label>
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Authorization failed</title>
+</head>
+<body>
+<h1>Authorization failed</h1>
+<p>{{.}}</p>
+</body>
+</html>
+`))
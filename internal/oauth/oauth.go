@@ -0,0 +1,186 @@
+// Package oauth is an OAuth 2.0 authorization server for third-party apps:
+// the authorization code grant with mandatory PKCE (RFC 6749, RFC 7636),
+// refresh tokens, revocation (RFC 7009) and introspection (RFC 7662).
+//
+// Access tokens are scoped JWTs from the auth package. Every authorization
+// a user gives creates a grant; tokens carry the grant ID, so revoking the
+// grant cuts off the client at once.
+package oauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// ErrNotFound is returned by a Store when there is no matching client, code
+// or active grant.
+var ErrNotFound = errors.New("not found")
+
+// Client is a registered third-party app. Public clients, such as mobile and
+// single page apps, can't keep a secret and have an empty SecretHash.
+type Client struct {
+	ID           string
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   string
+	RedirectURIs []string
+	Scopes       []string
+}
+
+// Public reports whether the client authenticates without a secret.
+func (c Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// AuthorizationCode is the short-lived code the authorization endpoint hands
+// the client through the user's browser.
+type AuthorizationCode struct {
+	CodeHash      string
+	ClientID      string
+	UserID        uuid.UUID
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// Grant is one authorization of a client by a user, and the refresh token
+// that currently belongs to it.
+type Grant struct {
+	ID               uuid.UUID
+	ClientID         string
+	UserID           uuid.UUID
+	Scopes           []string
+	RefreshTokenHash string
+	ExpiresAt        time.Time
+}
+
+// Store keeps clients, codes and grants. Only hashes of codes and tokens are
+// handed to it.
+type Store interface {
+	GetClient(ctx context.Context, clientID string) (Client, error)
+	CreateAuthorizationCode(ctx context.Context, code AuthorizationCode) error
+	// UseAuthorizationCode returns an unexpired code and marks it used, so
+	// it can only be exchanged once.
+	UseAuthorizationCode(ctx context.Context, codeHash string) (AuthorizationCode, error)
+	CreateGrant(ctx context.Context, grant Grant) error
+	// GetGrant returns a grant that hasn't been revoked or expired.
+	GetGrant(ctx context.Context, id uuid.UUID) (Grant, error)
+	GetGrantByRefreshToken(ctx context.Context, tokenHash string) (Grant, error)
+	// RotateRefreshToken replaces the refresh token of an active grant,
+	// extending it to expiresAt. It fails with ErrNotFound unless oldHash
+	// is the grant's current token.
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (Grant, error)
+	// RevokeGrantByPreviousRefreshToken revokes the grant whose refresh
+	// token was oldHash before its last rotation. Seeing a rotated token
+	// again means it leaked.
+	RevokeGrantByPreviousRefreshToken(ctx context.Context, oldHash string) error
+	RevokeGrant(ctx context.Context, id uuid.UUID) error
+}
+
+// Users is how the server reaches the rest of the app's accounts.
+type Users interface {
+	// Authenticate checks the credentials typed into the consent page,
+	// including a second factor if the account has one. Failures the user
+	// should see are returned as a *LoginError.
+	Authenticate(req *http.Request, email, password, code string) (uuid.UUID, error)
+	TokenVersion(ctx context.Context, userID uuid.UUID) (int32, error)
+}
+
+// LoginError is a failed sign-in on the consent page. Message is shown to
+// the user.
+type LoginError struct {
+	Message string
+}
+
+func (e *LoginError) Error() string {
+	return e.Message
+}
+
+// Server is the authorization server. JWT should have an audience of its
+// own, see auth.MakeScopedJWT.
+type Server struct {
+	Store           Store
+	Users           Users
+	JWT             auth.JWTConfig
+	Scopes          map[string]string
+	CodeTTL         time.Duration
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// Register adds the server's endpoints to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /oauth/authorize", s.handleAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", s.handleConsent)
+	mux.HandleFunc("POST /oauth/token", s.handleToken)
+	mux.HandleFunc("POST /oauth/revoke", s.handleRevoke)
+	mux.HandleFunc("POST /oauth/introspect", s.handleIntrospect)
+}
+
+// ValidateAccessToken checks an access token the server issued and that its
+// grant is still active. Resource endpoints use it to accept OAuth tokens.
+func (s *Server) ValidateAccessToken(ctx context.Context, token string) (auth.ScopedClaims, error) {
+	claims, err := auth.ValidateScopedJWT(token, s.JWT, func(userID uuid.UUID) (int32, error) {
+		return s.Users.TokenVersion(ctx, userID)
+	})
+	if err != nil {
+		return auth.ScopedClaims{}, err
+	}
+	grant, err := s.Store.GetGrant(ctx, uuid.MustParse(claims.ID))
+	if errors.Is(err, ErrNotFound) {
+		return auth.ScopedClaims{}, auth.ErrTokenRevoked
+	}
+	if err != nil {
+		return auth.ScopedClaims{}, err
+	}
+	if grant.ClientID != claims.ClientID || grant.UserID.String() != claims.Subject {
+		return auth.ScopedClaims{}, auth.ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// ValidRedirectURI reports whether uri may be registered as a redirect URI:
+// an absolute https URL without a fragment, or plain http to the loopback
+// interface for native apps (RFC 8252).
+func ValidRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Fragment != "" || parsed.Host == "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// checkSecret compares a presented client secret with the stored hash.
+func checkSecret(client Client, secret string) bool {
+	if client.Public() {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.SecretHash)) == 1
+}
+
+// subset reports whether every scope in requested is in allowed.
+func subset(requested, allowed []string) bool {
+	for _, scope := range requested {
+		if !slices.Contains(allowed, scope) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,548 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// memStore is a Store in memory, enough to run the whole flow without a
+// database.
+type memStore struct {
+	mu      sync.Mutex
+	clients map[string]Client
+	codes   map[string]AuthorizationCode
+	grants  map[uuid.UUID]*memGrant
+}
+
+type memGrant struct {
+	Grant
+	previousHash string
+	revoked      bool
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		clients: map[string]Client{},
+		codes:   map[string]AuthorizationCode{},
+		grants:  map[uuid.UUID]*memGrant{},
+	}
+}
+
+func (m *memStore) GetClient(_ context.Context, clientID string) (Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	client, ok := m.clients[clientID]
+	if !ok {
+		return Client{}, ErrNotFound
+	}
+	return client, nil
+}
+
+func (m *memStore) CreateAuthorizationCode(_ context.Context, code AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes[code.CodeHash] = code
+	return nil
+}
+
+func (m *memStore) UseAuthorizationCode(_ context.Context, codeHash string) (AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.codes[codeHash]
+	delete(m.codes, codeHash)
+	if !ok || time.Now().After(code.ExpiresAt) {
+		return AuthorizationCode{}, ErrNotFound
+	}
+	return code, nil
+}
+
+func (m *memStore) CreateGrant(_ context.Context, grant Grant) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grants[grant.ID] = &memGrant{Grant: grant}
+	return nil
+}
+
+func (m *memStore) active(g *memGrant) bool {
+	return !g.revoked && time.Now().Before(g.ExpiresAt)
+}
+
+func (m *memStore) GetGrant(_ context.Context, id uuid.UUID) (Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	g, ok := m.grants[id]
+	if !ok || !m.active(g) {
+		return Grant{}, ErrNotFound
+	}
+	return g.Grant, nil
+}
+
+func (m *memStore) GetGrantByRefreshToken(_ context.Context, tokenHash string) (Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.grants {
+		if g.RefreshTokenHash == tokenHash && m.active(g) {
+			return g.Grant, nil
+		}
+	}
+	return Grant{}, ErrNotFound
+}
+
+func (m *memStore) RotateRefreshToken(_ context.Context, oldHash, newHash string, expiresAt time.Time) (Grant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.grants {
+		if g.RefreshTokenHash == oldHash && m.active(g) {
+			g.previousHash = oldHash
+			g.RefreshTokenHash = newHash
+			g.ExpiresAt = expiresAt
+			return g.Grant, nil
+		}
+	}
+	return Grant{}, ErrNotFound
+}
+
+func (m *memStore) RevokeGrantByPreviousRefreshToken(_ context.Context, oldHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, g := range m.grants {
+		if g.previousHash == oldHash {
+			g.revoked = true
+		}
+	}
+	return nil
+}
+
+func (m *memStore) RevokeGrant(_ context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if g, ok := m.grants[id]; ok {
+		g.revoked = true
+	}
+	return nil
+}
+
+// fakeUsers knows a single user.
+type fakeUsers struct {
+	id       uuid.UUID
+	email    string
+	password string
+}
+
+func (f fakeUsers) Authenticate(_ *http.Request, email, password, _ string) (uuid.UUID, error) {
+	if email != f.email || password != f.password {
+		return uuid.Nil, &LoginError{Message: "Incorrect email or password"}
+	}
+	return f.id, nil
+}
+
+func (f fakeUsers) TokenVersion(context.Context, uuid.UUID) (int32, error) {
+	return 0, nil
+}
+
+const (
+	testRedirectURI = "https://app.example.com/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testSecret      = "s3cret-client-secret"
+)
+
+type testEnv struct {
+	server *httptest.Server
+	oauth  *Server
+	store  *memStore
+	user   fakeUsers
+	// browser doesn't follow redirects, so tests can read the code.
+	browser *http.Client
+}
+
+func newTestEnv(t *testing.T) testEnv {
+	t.Helper()
+	store := newMemStore()
+	store.clients["confidential"] = Client{
+		ID:           "confidential",
+		Name:         "Chirp Scheduler",
+		SecretHash:   auth.HashToken(testSecret),
+		RedirectURIs: []string{testRedirectURI},
+		Scopes:       []string{auth.ScopeChirpsRead, auth.ScopeChirpsWrite},
+	}
+	store.clients["public"] = Client{
+		ID:           "public",
+		Name:         "Chirp Mobile",
+		RedirectURIs: []string{"http://127.0.0.1:8765/callback"},
+		Scopes:       []string{auth.ScopeChirpsRead},
+	}
+	user := fakeUsers{id: uuid.New(), email: "walt@example.com", password: "gravel orbit lantern"}
+	config := auth.JWTConfig{
+		Keys:     auth.NewHMACKeyring("omgsecret"),
+		Issuer:   "chirpy",
+		Audience: "chirpy/oauth",
+		Leeway:   5 * time.Second,
+	}
+	server := &Server{
+		Store: store,
+		Users: user,
+		JWT:   config,
+		Scopes: map[string]string{
+			auth.ScopeChirpsRead:  "Read chirps",
+			auth.ScopeChirpsWrite: "Post and delete chirps",
+		},
+		CodeTTL:         time.Minute,
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 24 * time.Hour,
+	}
+	mux := http.NewServeMux()
+	server.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	return testEnv{server: ts, oauth: server, store: store, user: user, browser: browser}
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorizeParams(clientID, redirectURI string) url.Values {
+	return url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {auth.ScopeChirpsRead},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge(testVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+}
+
+// consent posts the consent form and returns the redirect it answers with.
+func (env testEnv) consent(t *testing.T, params url.Values, decision, password string) (*http.Response, *url.URL) {
+	t.Helper()
+	form := url.Values{}
+	for key, values := range params {
+		form[key] = values
+	}
+	form.Set("decision", decision)
+	form.Set("email", env.user.email)
+	form.Set("password", password)
+	resp, err := env.browser.PostForm(env.server.URL+"/oauth/authorize", form)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 303 {
+		return resp, nil
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return resp, location
+}
+
+func (env testEnv) authorize(t *testing.T) string {
+	t.Helper()
+	_, location := env.consent(t, authorizeParams("confidential", testRedirectURI), "allow", env.user.password)
+	if location == nil {
+		t.Fatalf("Didn't get redirected back to the client")
+	}
+	if location.Query().Get("state") != "xyz" {
+		t.Errorf("Didn't get correct state: Got %q, expected %q", location.Query().Get("state"), "xyz")
+	}
+	return location.Query().Get("code")
+}
+
+func (env testEnv) post(t *testing.T, path string, form url.Values, basic bool) (int, map[string]any) {
+	t.Helper()
+	req, err := http.NewRequest("POST", env.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if basic {
+		req.SetBasicAuth("confidential", testSecret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	defer resp.Body.Close()
+	body := map[string]any{}
+	data, _ := io.ReadAll(resp.Body)
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &body)
+		if err != nil {
+			t.Fatalf("Couldn't decode %s: %v", data, err)
+		}
+	}
+	return resp.StatusCode, body
+}
+
+func (env testEnv) exchange(t *testing.T, code string) (int, map[string]any) {
+	t.Helper()
+	return env.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}, true)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp, err := http.Get(env.server.URL + "/oauth/authorize?" + authorizeParams("confidential", testRedirectURI).Encode())
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 200 || !strings.Contains(string(page), "Chirp Scheduler") {
+		t.Fatalf("Didn't get the consent page: Got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Errorf("Consent page can be framed")
+	}
+
+	code := env.authorize(t)
+	status, tokens := env.exchange(t, code)
+	if status != 200 {
+		t.Fatalf("Didn't get correct status: Got %d, expected 200: %v", status, tokens)
+	}
+	if tokens["token_type"] != "Bearer" || tokens["scope"] != auth.ScopeChirpsRead {
+		t.Errorf("Didn't get correct token response: Got %v", tokens)
+	}
+
+	accessToken := tokens["access_token"].(string)
+	claims, err := env.oauth.ValidateAccessToken(context.Background(), accessToken)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if claims.Subject != env.user.id.String() || claims.ClientID != "confidential" {
+		t.Errorf("Didn't get correct claims: Got %+v", claims)
+	}
+
+	status, body := env.post(t, "/oauth/introspect", url.Values{"token": {accessToken}}, true)
+	if status != 200 || body["active"] != true || body["sub"] != env.user.id.String() || body["scope"] != auth.ScopeChirpsRead {
+		t.Errorf("Didn't get correct introspection: Got %d %v", status, body)
+	}
+
+	// A code is only good once.
+	status, _ = env.exchange(t, code)
+	if status != 400 {
+		t.Errorf("Didn't get correct status for a reused code: Got %d, expected 400", status)
+	}
+
+	// Refreshing rotates the refresh token, and the old one is then dead.
+	refreshToken := tokens["refresh_token"].(string)
+	status, refreshed := env.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	}, true)
+	if status != 200 || refreshed["refresh_token"] == refreshToken {
+		t.Fatalf("Didn't rotate the refresh token: Got %d %v", status, refreshed)
+	}
+
+	// Revoking the grant kills its access tokens too.
+	status, _ = env.post(t, "/oauth/revoke", url.Values{"token": {refreshed["refresh_token"].(string)}}, true)
+	if status != 200 {
+		t.Errorf("Didn't get correct status: Got %d, expected 200", status)
+	}
+	status, body = env.post(t, "/oauth/introspect", url.Values{"token": {refreshed["access_token"].(string)}}, true)
+	if status != 200 || body["active"] != false {
+		t.Errorf("Didn't get correct introspection after revoking: Got %d %v", status, body)
+	}
+	_, err = env.oauth.ValidateAccessToken(context.Background(), accessToken)
+	if !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Errorf("Error generated: Got %v, expected %v", err, auth.ErrTokenRevoked)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	env := newTestEnv(t)
+	_, tokens := env.exchange(t, env.authorize(t))
+	refreshToken := tokens["refresh_token"].(string)
+
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	status, refreshed := env.post(t, "/oauth/token", form, true)
+	if status != 200 {
+		t.Fatalf("Didn't get correct status: Got %d, expected 200", status)
+	}
+	status, _ = env.post(t, "/oauth/token", form, true)
+	if status != 400 {
+		t.Errorf("Didn't get correct status for a reused refresh token: Got %d, expected 400", status)
+	}
+	// The reuse revoked the grant, so the newest token is dead as well.
+	status, _ = env.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshed["refresh_token"].(string)},
+	}, true)
+	if status != 400 {
+		t.Errorf("Didn't get correct status after reuse: Got %d, expected 400", status)
+	}
+}
+
+func TestTokenEndpointErrors(t *testing.T) {
+	env := newTestEnv(t)
+
+	cases := []struct {
+		name   string
+		form   url.Values
+		basic  bool
+		status int
+		error  string
+	}{
+		{
+			name:   "wrong verifier",
+			form:   url.Values{"grant_type": {"authorization_code"}, "redirect_uri": {testRedirectURI}, "code_verifier": {strings.Repeat("a", 43)}},
+			basic:  true,
+			status: 400,
+			error:  "invalid_grant",
+		},
+		{
+			name:   "wrong redirect URI",
+			form:   url.Values{"grant_type": {"authorization_code"}, "redirect_uri": {"https://evil.example.com/"}, "code_verifier": {testVerifier}},
+			basic:  true,
+			status: 400,
+			error:  "invalid_grant",
+		},
+		{
+			name:   "no client authentication",
+			form:   url.Values{"grant_type": {"authorization_code"}, "client_id": {"confidential"}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}},
+			status: 401,
+			error:  "invalid_client",
+		},
+		{
+			name:   "another client",
+			form:   url.Values{"grant_type": {"authorization_code"}, "client_id": {"public"}, "redirect_uri": {testRedirectURI}, "code_verifier": {testVerifier}},
+			status: 400,
+			error:  "invalid_grant",
+		},
+		{
+			name:   "unsupported grant",
+			form:   url.Values{"grant_type": {"password"}},
+			basic:  true,
+			status: 400,
+			error:  "unsupported_grant_type",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.form.Get("grant_type") == "authorization_code" {
+				c.form.Set("code", env.authorize(t))
+			}
+			status, body := env.post(t, "/oauth/token", c.form, c.basic)
+			if status != c.status || body["error"] != c.error {
+				t.Errorf("Didn't get correct error: Got %d %v, expected %d %s", status, body, c.status, c.error)
+			}
+		})
+	}
+}
+
+func TestPublicClient(t *testing.T) {
+	env := newTestEnv(t)
+	redirectURI := "http://127.0.0.1:8765/callback"
+	_, location := env.consent(t, authorizeParams("public", redirectURI), "allow", env.user.password)
+	if location == nil {
+		t.Fatalf("Didn't get redirected back to the client")
+	}
+
+	status, tokens := env.post(t, "/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"public"},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {testVerifier},
+	}, false)
+	if status != 200 || tokens["access_token"] == nil {
+		t.Errorf("Didn't get tokens: Got %d %v", status, tokens)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	env := newTestEnv(t)
+
+	// Errors before the redirect URI is trusted are shown, not redirected.
+	for _, params := range []url.Values{
+		authorizeParams("unknown", testRedirectURI),
+		authorizeParams("confidential", "https://evil.example.com/callback"),
+	} {
+		resp, err := env.browser.Get(env.server.URL + "/oauth/authorize?" + params.Encode())
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 || resp.Header.Get("Location") != "" {
+			t.Errorf("Didn't get correct response: Got %d to %q, expected 400", resp.StatusCode, resp.Header.Get("Location"))
+		}
+	}
+
+	cases := []struct {
+		name  string
+		edit  func(url.Values)
+		error string
+	}{
+		{name: "no PKCE", edit: func(v url.Values) { v.Del("code_challenge") }, error: "invalid_request"},
+		{name: "plain PKCE", edit: func(v url.Values) { v.Set("code_challenge_method", "plain") }, error: "invalid_request"},
+		{name: "token response", edit: func(v url.Values) { v.Set("response_type", "token") }, error: "unsupported_response_type"},
+		{name: "unknown scope", edit: func(v url.Values) { v.Set("scope", "admin") }, error: "invalid_scope"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			params := authorizeParams("confidential", testRedirectURI)
+			c.edit(params)
+			resp, err := env.browser.Get(env.server.URL + "/oauth/authorize?" + params.Encode())
+			if err != nil {
+				t.Fatalf("Error generated: Got %v, expected nil", err)
+			}
+			resp.Body.Close()
+			location, _ := url.Parse(resp.Header.Get("Location"))
+			if resp.StatusCode != 303 || location.Query().Get("error") != c.error || location.Query().Get("state") != "xyz" {
+				t.Errorf("Didn't get correct redirect: Got %d to %s, expected error %s", resp.StatusCode, location, c.error)
+			}
+		})
+	}
+
+	_, location := env.consent(t, authorizeParams("confidential", testRedirectURI), "deny", "")
+	if location == nil || location.Query().Get("error") != "access_denied" || location.Query().Get("code") != "" {
+		t.Errorf("Didn't get correct redirect after denying: Got %v", location)
+	}
+
+	resp, location := env.consent(t, authorizeParams("confidential", testRedirectURI), "allow", "wrong password")
+	if resp.StatusCode != 401 || location != nil {
+		t.Errorf("Didn't get correct status for wrong credentials: Got %d, expected 401", resp.StatusCode)
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	cases := map[string]bool{
+		"https://app.example.com/callback": true,
+		"http://127.0.0.1:8765/callback":   true,
+		"http://localhost/callback":        true,
+		"http://app.example.com/callback":  false,
+		"https://app.example.com/cb#frag":  false,
+		"javascript:alert(1)":              false,
+		"/relative/callback":               false,
+	}
+	for uri, want := range cases {
+		if got := ValidRedirectURI(uri); got != want {
+			t.Errorf("Didn't get correct result for %s: Got %v, expected %v", uri, got, want)
+		}
+	}
+}
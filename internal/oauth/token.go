@@ -0,0 +1,372 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// tokenError is an error response from the token, revocation and
+// introspection endpoints, in the form of RFC 6749 section 5.2.
+type tokenError struct {
+	status      int
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// introspectionResponse is the answer of RFC 7662. Everything but Active is
+// left out for inactive tokens.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(payload)
+	if err != nil {
+		log.Printf("Error writing OAuth response: %s", err)
+	}
+}
+
+func writeTokenError(w http.ResponseWriter, err *tokenError) {
+	if err.status == 401 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	writeJSON(w, err.status, err)
+}
+
+func serverError(w http.ResponseWriter, err error) {
+	log.Printf("Error in the OAuth token endpoints: %s", err)
+	writeTokenError(w, &tokenError{status: 500, Code: "server_error"})
+}
+
+// authenticateClient identifies the client calling a back-channel endpoint.
+// Confidential clients send their secret with HTTP Basic or in the form;
+// public clients only send their ID.
+func (s *Server) authenticateClient(req *http.Request) (Client, *tokenError, error) {
+	clientID, secret, basic := req.BasicAuth()
+	if basic {
+		// Basic credentials are form-encoded first, RFC 6749 section 2.3.1.
+		var err error
+		clientID, err = url.QueryUnescape(clientID)
+		if err != nil {
+			return Client{}, &tokenError{status: 401, Code: "invalid_client"}, nil
+		}
+		secret, err = url.QueryUnescape(secret)
+		if err != nil {
+			return Client{}, &tokenError{status: 401, Code: "invalid_client"}, nil
+		}
+	} else {
+		clientID = req.PostForm.Get("client_id")
+		secret = req.PostForm.Get("client_secret")
+	}
+
+	client, err := s.Store.GetClient(req.Context(), clientID)
+	if errors.Is(err, ErrNotFound) {
+		return Client{}, &tokenError{status: 401, Code: "invalid_client", Description: "Unknown client"}, nil
+	}
+	if err != nil {
+		return Client{}, nil, err
+	}
+	if client.Public() {
+		if secret != "" {
+			return Client{}, &tokenError{status: 401, Code: "invalid_client", Description: "Public clients have no secret"}, nil
+		}
+		return client, nil, nil
+	}
+	if !checkSecret(client, secret) {
+		return Client{}, &tokenError{status: 401, Code: "invalid_client", Description: "Client authentication failed"}, nil
+	}
+	return client, nil, nil
+}
+
+// parseClientRequest reads the form and authenticates the client, writing
+// the error response and returning false when either fails.
+func (s *Server) parseClientRequest(w http.ResponseWriter, req *http.Request) (Client, bool) {
+	err := req.ParseForm()
+	if err != nil {
+		writeTokenError(w, &tokenError{status: 400, Code: "invalid_request", Description: "Couldn't read the form"})
+		return Client{}, false
+	}
+	client, tokenErr, err := s.authenticateClient(req)
+	if err != nil {
+		serverError(w, err)
+		return Client{}, false
+	}
+	if tokenErr != nil {
+		writeTokenError(w, tokenErr)
+		return Client{}, false
+	}
+	return client, true
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, req *http.Request) {
+	client, ok := s.parseClientRequest(w, req)
+	if !ok {
+		return
+	}
+
+	var grant Grant
+	var refreshToken string
+	var tokenErr *tokenError
+	var err error
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		grant, refreshToken, tokenErr, err = s.exchangeCode(req, client)
+	case "refresh_token":
+		grant, refreshToken, tokenErr, err = s.refresh(req, client)
+	default:
+		tokenErr = &tokenError{status: 400, Code: "unsupported_grant_type"}
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if tokenErr != nil {
+		writeTokenError(w, tokenErr)
+		return
+	}
+
+	version, err := s.Users.TokenVersion(req.Context(), grant.UserID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	accessToken, err := auth.MakeScopedJWT(grant.UserID, version, grant.ID, client.ID, grant.Scopes, s.JWT, s.AccessTokenTTL)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	writeJSON(w, 200, tokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.AccessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(grant.Scopes, " "),
+	})
+}
+
+// exchangeCode redeems an authorization code for a new grant. The code has
+// to come from the same client with the same redirect URI, and the PKCE
+// verifier has to match the challenge it was issued for.
+func (s *Server) exchangeCode(req *http.Request, client Client) (Grant, string, *tokenError, error) {
+	invalidGrant := &tokenError{status: 400, Code: "invalid_grant", Description: "Invalid or expired authorization code"}
+
+	code, err := s.Store.UseAuthorizationCode(req.Context(), auth.HashToken(req.PostForm.Get("code")))
+	if errors.Is(err, ErrNotFound) {
+		return Grant{}, "", invalidGrant, nil
+	}
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	if code.ClientID != client.ID || code.RedirectURI != req.PostForm.Get("redirect_uri") {
+		return Grant{}, "", invalidGrant, nil
+	}
+	if !verifyCodeChallenge(req.PostForm.Get("code_verifier"), code.CodeChallenge) {
+		return Grant{}, "", &tokenError{status: 400, Code: "invalid_grant", Description: "PKCE verification failed"}, nil
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	grant := Grant{
+		ID:               uuid.New(),
+		ClientID:         client.ID,
+		UserID:           code.UserID,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		ExpiresAt:        time.Now().UTC().Add(s.RefreshTokenTTL),
+	}
+	err = s.Store.CreateGrant(req.Context(), grant)
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	return grant, refreshToken, nil, nil
+}
+
+// refresh rotates a grant's refresh token. A token that has already been
+// rotated away means it leaked, so the whole grant is revoked.
+func (s *Server) refresh(req *http.Request, client Client) (Grant, string, *tokenError, error) {
+	invalidGrant := &tokenError{status: 400, Code: "invalid_grant", Description: "Invalid or expired refresh token"}
+	oldHash := auth.HashToken(req.PostForm.Get("refresh_token"))
+
+	grant, err := s.Store.GetGrantByRefreshToken(req.Context(), oldHash)
+	if errors.Is(err, ErrNotFound) {
+		err = s.Store.RevokeGrantByPreviousRefreshToken(req.Context(), oldHash)
+		if err != nil {
+			return Grant{}, "", nil, err
+		}
+		return Grant{}, "", invalidGrant, nil
+	}
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	if grant.ClientID != client.ID {
+		return Grant{}, "", invalidGrant, nil
+	}
+	scopes := strings.Fields(req.PostForm.Get("scope"))
+	if len(scopes) > 0 && !subset(scopes, grant.Scopes) {
+		return Grant{}, "", &tokenError{status: 400, Code: "invalid_scope", Description: "Can't widen the scope of a grant"}, nil
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	rotated, err := s.Store.RotateRefreshToken(req.Context(), oldHash, auth.HashToken(refreshToken), time.Now().UTC().Add(s.RefreshTokenTTL))
+	if errors.Is(err, ErrNotFound) {
+		// Lost a race with another refresh using the same token.
+		err = s.Store.RevokeGrant(req.Context(), grant.ID)
+		if err != nil {
+			return Grant{}, "", nil, err
+		}
+		return Grant{}, "", invalidGrant, nil
+	}
+	if err != nil {
+		return Grant{}, "", nil, err
+	}
+	if len(scopes) > 0 {
+		rotated.Scopes = scopes
+	}
+	return rotated, refreshToken, nil, nil
+}
+
+// verifyCodeChallenge checks a PKCE verifier against an S256 challenge.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:]) == challenge
+}
+
+// findGrant resolves a refresh or access token to its grant, as long as the
+// grant belongs to client. The token hint isn't needed to tell them apart.
+func (s *Server) findGrant(req *http.Request, client Client, token string) (Grant, *auth.ScopedClaims, error) {
+	grant, err := s.Store.GetGrantByRefreshToken(req.Context(), auth.HashToken(token))
+	if err == nil && grant.ClientID == client.ID {
+		return grant, nil, nil
+	}
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Grant{}, nil, err
+	}
+
+	claims, err := s.ValidateAccessToken(req.Context(), token)
+	if err != nil || claims.ClientID != client.ID {
+		// Invalid tokens are just inactive, but a failed lookup isn't.
+		if err != nil && !isTokenError(err) {
+			return Grant{}, nil, err
+		}
+		return Grant{}, nil, ErrNotFound
+	}
+	grant, err = s.Store.GetGrant(req.Context(), uuid.MustParse(claims.ID))
+	if err != nil {
+		return Grant{}, nil, err
+	}
+	return grant, &claims, nil
+}
+
+func isTokenError(err error) bool {
+	for _, target := range []error{auth.ErrTokenMalformed, auth.ErrTokenSignature, auth.ErrTokenExpired,
+		auth.ErrTokenNotYetValid, auth.ErrTokenClaims, auth.ErrTokenRevoked} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleRevoke implements RFC 7009. Unknown tokens, and tokens of other
+// clients, get the same 200 as real ones.
+func (s *Server) handleRevoke(w http.ResponseWriter, req *http.Request) {
+	client, ok := s.parseClientRequest(w, req)
+	if !ok {
+		return
+	}
+
+	grant, _, err := s.findGrant(req, client, req.PostForm.Get("token"))
+	if errors.Is(err, ErrNotFound) {
+		w.WriteHeader(200)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	err = s.Store.RevokeGrant(req.Context(), grant.ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// handleIntrospect implements RFC 7662. A client can only introspect its own
+// tokens; everything else is reported inactive.
+func (s *Server) handleIntrospect(w http.ResponseWriter, req *http.Request) {
+	client, ok := s.parseClientRequest(w, req)
+	if !ok {
+		return
+	}
+
+	grant, claims, err := s.findGrant(req, client, req.PostForm.Get("token"))
+	if errors.Is(err, ErrNotFound) {
+		writeJSON(w, 200, introspectionResponse{Active: false})
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	if claims == nil {
+		writeJSON(w, 200, introspectionResponse{
+			Active:    true,
+			Scope:     strings.Join(grant.Scopes, " "),
+			ClientID:  grant.ClientID,
+			Subject:   grant.UserID.String(),
+			ExpiresAt: grant.ExpiresAt.Unix(),
+		})
+		return
+	}
+	writeJSON(w, 200, introspectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Subject:   claims.Subject,
+		TokenType: "Bearer",
+		ExpiresAt: claims.ExpiresAt.Unix(),
+		IssuedAt:  claims.IssuedAt.Unix(),
+		Issuer:    claims.Issuer,
+		Audience:  s.JWT.Audience,
+	})
+}
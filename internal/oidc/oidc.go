@@ -0,0 +1,271 @@
+// Package oidc signs users in with an external OpenID Connect provider: it
+// discovers the provider, builds authorization requests with PKCE, redeems
+// the code and verifies the ID token against the provider's JWKS.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrDiscovery    = errors.New("OIDC discovery failed")
+	ErrExchange     = errors.New("OIDC code exchange failed")
+	ErrInvalidToken = errors.New("ID token is invalid")
+)
+
+// Config describes this app as a client of the provider.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to openid.
+	Scopes []string
+	// Leeway is the clock skew tolerated when checking exp and iat.
+	Leeway     time.Duration
+	HTTPClient *http.Client
+}
+
+// Provider is a discovered OpenID provider.
+type Provider struct {
+	config                Config
+	authorizationEndpoint string
+	tokenEndpoint         string
+	algorithms            []string
+	keys                  *keySet
+}
+
+type discoveryDocument struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// supportedAlgorithms are the ID token algorithms we verify. HMAC and none
+// are never accepted.
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// Discover fetches the provider's metadata from its well-known URL. The
+// issuer in the document has to match the configured one exactly, as OpenID
+// Connect Discovery section 4.3 requires.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	wellKnown := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	doc := discoveryDocument{}
+	err := getJSON(ctx, config.HTTPClient, wellKnown, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	if doc.Issuer != config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q doesn't match %q", ErrDiscovery, doc.Issuer, config.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: metadata is missing endpoints", ErrDiscovery)
+	}
+
+	algorithms := []string{}
+	for _, alg := range doc.SigningAlgorithms {
+		if slices.Contains(supportedAlgorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	if len(doc.SigningAlgorithms) == 0 {
+		// RS256 is the default, OpenID Connect Discovery section 3.
+		algorithms = []string{"RS256"}
+	}
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("%w: no supported ID token algorithm in %v", ErrDiscovery, doc.SigningAlgorithms)
+	}
+
+	return &Provider{
+		config:                config,
+		authorizationEndpoint: doc.AuthorizationEndpoint,
+		tokenEndpoint:         doc.TokenEndpoint,
+		algorithms:            algorithms,
+		keys:                  newKeySet(config.HTTPClient, doc.JWKSURI),
+	}, nil
+}
+
+// AuthCodeURL returns where to send the user to sign in. state and nonce
+// should be random and remembered for the callback, as should the verifier
+// behind codeChallenge.
+func (p *Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(p.authorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.authorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange redeems an authorization code and returns the verified ID token
+// that came with it.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (IDToken, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.config.HTTPClient.Do(req)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	if resp.StatusCode != 200 {
+		return IDToken{}, fmt.Errorf("%w: token endpoint answered %d: %s", ErrExchange, resp.StatusCode, body)
+	}
+
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %w", ErrExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return IDToken{}, fmt.Errorf("%w: no id_token in the response", ErrExchange)
+	}
+	return p.Verify(ctx, tokens.IDToken, nonce)
+}
+
+// IDToken is what we use of a verified ID token.
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// flexibleBool accepts email_verified as a JSON boolean or as the string
+// "true", which some providers send.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string       `json:"nonce"`
+	AuthorizedParty string       `json:"azp"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+}
+
+// Verify checks an ID token as OpenID Connect Core section 3.1.3.7 asks:
+// signature from the provider's JWKS, issuer, audience, authorized party,
+// expiry, issue time and nonce.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (IDToken, error) {
+	claims := idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.keys.key(ctx, kid)
+	},
+		jwt.WithValidMethods(p.algorithms),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(p.config.Leeway),
+	)
+	if err != nil {
+		return IDToken{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return IDToken{}, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return IDToken{}, fmt.Errorf("%w: azp %q isn't this client", ErrInvalidToken, claims.AuthorizedParty)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return IDToken{}, fmt.Errorf("%w: nonce doesn't match", ErrInvalidToken)
+	}
+	return IDToken{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// RandomString returns a random URL-safe string, for state and nonce.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge of a verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %s answered %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(dest)
+}
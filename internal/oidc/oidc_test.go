@@ -0,0 +1,409 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockProvider is a minimal OpenID provider: discovery, a JWKS, and a token
+// endpoint that redeems codes handed out with issueCode.
+type mockProvider struct {
+	server       *httptest.Server
+	clientID     string
+	clientSecret string
+
+	mu         sync.Mutex
+	keys       map[string]any
+	signingKID string
+	codes      map[string]mockCode
+	jwksHits   int
+}
+
+type mockCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	m := &mockProvider{
+		clientID:     "chirpy",
+		clientSecret: "s3cret",
+		keys:         map[string]any{},
+		codes:        map[string]mockCode{},
+	}
+	m.addRSAKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256", "HS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, req *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.jwksHits++
+		keys := []map[string]string{}
+		for kid, key := range m.keys {
+			keys = append(keys, publicJWK(kid, key))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("POST /token", m.handleToken)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) addRSAKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+	m.signingKID = kid
+}
+
+func (m *mockProvider) addECKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[kid] = key
+	m.signingKID = kid
+}
+
+func publicJWK(kid string, key any) map[string]string {
+	b64 := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": b64(key.N), "e": b64(big.NewInt(int64(key.E)))}
+	case *ecdsa.PrivateKey:
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256", "x": b64(key.X), "y": b64(key.Y)}
+	}
+	return nil
+}
+
+// sign makes an ID token with the current signing key.
+func (m *mockProvider) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	m.mu.Lock()
+	kid := m.signingKID
+	key := m.keys[kid]
+	m.mu.Unlock()
+
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockProvider) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "external-user-1",
+		"aud":            m.clientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "sso@example.com",
+		"email_verified": true,
+		"name":           "SSO User",
+	}
+}
+
+func (m *mockProvider) issueCode(codeChallenge string, claims jwt.MapClaims) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := rand.Text()
+	m.codes[code] = mockCode{challenge: codeChallenge, claims: claims}
+	return code
+}
+
+func (m *mockProvider) handleToken(w http.ResponseWriter, req *http.Request) {
+	clientID, secret, ok := req.BasicAuth()
+	if !ok || clientID != m.clientID || secret != m.clientSecret {
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	m.mu.Lock()
+	code, ok := m.codes[req.PostFormValue("code")]
+	delete(m.codes, req.PostFormValue("code"))
+	m.mu.Unlock()
+	if !ok || req.PostFormValue("grant_type") != "authorization_code" || CodeChallenge(req.PostFormValue("code_verifier")) != code.challenge {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	m.mu.Lock()
+	kid := m.signingKID
+	key := m.keys[kid]
+	m.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, code.claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+	})
+}
+
+func (m *mockProvider) discover(t *testing.T) *Provider {
+	t.Helper()
+	provider, err := Discover(context.Background(), Config{
+		Issuer:       m.server.URL,
+		ClientID:     m.clientID,
+		ClientSecret: m.clientSecret,
+		RedirectURL:  "http://localhost:8080/api/login/oidc/callback",
+		Scopes:       []string{"email", "profile"},
+	})
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	return provider
+}
+
+func TestDiscover(t *testing.T) {
+	m := newMockProvider(t)
+	provider := m.discover(t)
+	if provider.authorizationEndpoint != m.server.URL+"/authorize" || provider.tokenEndpoint != m.server.URL+"/token" {
+		t.Errorf("Didn't get correct endpoints: Got %s and %s", provider.authorizationEndpoint, provider.tokenEndpoint)
+	}
+	if len(provider.algorithms) != 2 {
+		t.Errorf("Didn't get correct algorithms: Got %v, expected %v", provider.algorithms, []string{"RS256", "ES256"})
+	}
+
+	_, err := Discover(context.Background(), Config{Issuer: m.server.URL + "/other", ClientID: m.clientID})
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrDiscovery)
+	}
+
+	// The document names an issuer that isn't the one we asked.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	}))
+	defer other.Close()
+	_, err = Discover(context.Background(), Config{Issuer: other.URL, ClientID: m.clientID})
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrDiscovery)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	m := newMockProvider(t)
+	provider := m.discover(t)
+
+	authURL, err := url.Parse(provider.AuthCodeURL("the-state", "the-nonce", CodeChallenge("verifier")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             m.clientID,
+		"redirect_uri":          "http://localhost:8080/api/login/oidc/callback",
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        CodeChallenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := authURL.Query().Get(key); got != value {
+			t.Errorf("Didn't get correct %s: Got %q, expected %q", key, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	provider := m.discover(t)
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := m.issueCode(CodeChallenge(verifier), m.claims("nonce-1"))
+	idToken, err := provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	want := IDToken{
+		Issuer:        m.server.URL,
+		Subject:       "external-user-1",
+		Email:         "sso@example.com",
+		EmailVerified: true,
+		Name:          "SSO User",
+	}
+	if idToken != want {
+		t.Errorf("Didn't get correct ID token: Got %+v, expected %+v", idToken, want)
+	}
+
+	// Codes are single use.
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce-1")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrExchange)
+	}
+
+	// The verifier has to match the challenge sent with the authorization
+	// request.
+	code = m.issueCode(CodeChallenge(verifier), m.claims("nonce-1"))
+	_, err = provider.Exchange(context.Background(), code, "some-other-verifier", "nonce-1")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrExchange)
+	}
+
+	// A token for another login attempt is refused.
+	code = m.issueCode(CodeChallenge(verifier), m.claims("nonce-1"))
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce-2")
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrInvalidToken)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := newMockProvider(t)
+	provider := m.discover(t)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, m.claims("n"))
+	forged.Header["kid"] = "key-1"
+	forgedToken, err := forged.SignedString(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, m.claims("n")).SignedString([]byte("key-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		token  string
+	}{
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no expiry", modify: func(c jwt.MapClaims) { delete(c, "exp") }},
+		{name: "issued in the future", modify: func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "no subject", modify: func(c jwt.MapClaims) { delete(c, "sub") }},
+		{name: "no nonce", modify: func(c jwt.MapClaims) { delete(c, "nonce") }},
+		{name: "other authorized party", modify: func(c jwt.MapClaims) {
+			c["aud"] = []string{m.clientID, "someone-else"}
+			c["azp"] = "someone-else"
+		}},
+		{name: "forged signature", token: forgedToken},
+		{name: "HMAC", token: hmacToken},
+		{name: "garbage", token: "not.a.token"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			token := tc.token
+			if token == "" {
+				claims := m.claims("n")
+				tc.modify(claims)
+				token = m.sign(t, claims)
+			}
+			_, err := provider.Verify(context.Background(), token, "n")
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Error generated: Got %v, expected %v", err, ErrInvalidToken)
+			}
+		})
+	}
+
+	claims := m.claims("n")
+	claims["aud"] = []string{m.clientID, "someone-else"}
+	claims["azp"] = m.clientID
+	claims["email_verified"] = "true"
+	idToken, err := provider.Verify(context.Background(), m.sign(t, claims), "n")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	if !idToken.EmailVerified {
+		t.Errorf("Didn't get correct email_verified: Got %v, expected %v", idToken.EmailVerified, true)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := newMockProvider(t)
+	provider := m.discover(t)
+
+	_, err := provider.Verify(context.Background(), m.sign(t, m.claims("n")), "n")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+
+	// The provider starts signing with a new key; we don't have it yet and
+	// have to fetch the set again. The last fetch was too recent, so pretend
+	// it was a while ago.
+	m.addECKey(t, "key-2")
+	provider.keys.mu.Lock()
+	provider.keys.fetchedAt = time.Now().Add(-2 * minRefreshInterval)
+	provider.keys.mu.Unlock()
+	_, err = provider.Verify(context.Background(), m.sign(t, m.claims("n")), "n")
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+
+	// Unknown key IDs don't get to trigger a fetch each.
+	hits := m.jwksHits
+	m.addRSAKey(t, "key-3")
+	for range 3 {
+		_, err = provider.Verify(context.Background(), m.sign(t, m.claims("n")), "n")
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Error generated: Got %v, expected %v", err, ErrInvalidToken)
+		}
+	}
+	if m.jwksHits != hits {
+		t.Errorf("Didn't get correct JWKS fetches: Got %d, expected %d", m.jwksHits, hits)
+	}
+}
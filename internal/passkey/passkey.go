@@ -0,0 +1,275 @@
+// Package passkey runs the WebAuthn registration and authentication
+// ceremonies. Passkeys sign users in on their own, with user verification
+// standing in for the password, or serve as a second factor after one.
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrExpired        = errors.New("passkey ceremony has expired")
+	ErrVerification   = errors.New("passkey couldn't be verified")
+	ErrCloned         = errors.New("passkey signature counter went backwards; the authenticator may have been cloned")
+	ErrNoCredentials  = errors.New("user has no passkeys")
+	ErrUnknownPasskey = errors.New("unknown passkey")
+)
+
+// Config describes the relying party, which is us.
+type Config struct {
+	// RPID is the domain passkeys are bound to, such as example.com.
+	RPID          string
+	RPDisplayName string
+	// Origins are the exact origins ceremonies may come from, such as
+	// https://example.com.
+	Origins []string
+	// Timeout is how long the user has to complete a ceremony.
+	Timeout time.Duration
+}
+
+// Credential is a registered passkey as it's stored.
+type Credential struct {
+	ID              []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+}
+
+// User is an account with the passkeys registered to it. Its WebAuthn user
+// handle is the user ID.
+type User struct {
+	ID          uuid.UUID
+	Name        string
+	Credentials []Credential
+}
+
+func (u User) WebAuthnID() []byte          { return u.ID[:] }
+func (u User) WebAuthnName() string        { return u.Name }
+func (u User) WebAuthnDisplayName() string { return u.Name }
+
+func (u User) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.Credentials))
+	for _, c := range u.Credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.ID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: c.SignCount,
+			},
+		})
+	}
+	return credentials
+}
+
+func newCredential(c *webauthn.Credential) Credential {
+	transports := make([]string, 0, len(c.Transport))
+	for _, t := range c.Transport {
+		transports = append(transports, string(t))
+	}
+	return Credential{
+		ID:              c.ID,
+		PublicKey:       c.PublicKey,
+		AttestationType: c.AttestationType,
+		AAGUID:          c.Authenticator.AAGUID,
+		SignCount:       c.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  c.Flags.BackupEligible,
+		BackupState:     c.Flags.BackupState,
+	}
+}
+
+// RelyingParty starts and finishes ceremonies. Begin methods return the
+// options for navigator.credentials and the ceremony's state, which the
+// caller keeps until the matching Finish call.
+type RelyingParty struct {
+	webauthn *webauthn.WebAuthn
+}
+
+func New(config Config) (*RelyingParty, error) {
+	timeout := webauthn.TimeoutConfig{
+		Enforce:    true,
+		Timeout:    config.Timeout,
+		TimeoutUVD: config.Timeout,
+	}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RPID,
+		RPDisplayName: config.RPDisplayName,
+		RPOrigins:     config.Origins,
+		// We don't check attestations against authenticator metadata, so
+		// asking for them would only cost users a privacy prompt.
+		AttestationPreference: protocol.PreferNoAttestation,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RelyingParty{webauthn: w}, nil
+}
+
+// BeginRegistration starts adding a passkey to user. Passkeys they already
+// have are excluded so the same authenticator isn't registered twice.
+func (rp *RelyingParty) BeginRegistration(user User) (*protocol.CredentialCreation, []byte, error) {
+	creation, session, err := rp.webauthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			UserVerification:   protocol.VerificationRequired,
+		}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	return creation, state, err
+}
+
+// FinishRegistration checks the authenticator's response and returns the
+// new passkey.
+func (rp *RelyingParty) FinishRegistration(user User, state, response []byte) (Credential, error) {
+	session, err := decodeSession(state)
+	if err != nil {
+		return Credential{}, err
+	}
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return Credential{}, verificationError(err)
+	}
+	credential, err := rp.webauthn.CreateCredential(user, session, parsed)
+	if err != nil {
+		return Credential{}, verificationError(err)
+	}
+	return newCredential(credential), nil
+}
+
+// BeginLogin starts a passwordless sign-in. The user isn't known yet: the
+// browser offers whichever passkeys it has for us, and the one chosen tells
+// us who the user is. User verification is required, as it replaces the
+// password.
+func (rp *RelyingParty) BeginLogin() (*protocol.CredentialAssertion, []byte, error) {
+	assertion, session, err := rp.webauthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	return assertion, state, err
+}
+
+// FinishLogin checks a passwordless sign-in. lookup finds the user a
+// passkey belongs to, from the user handle the authenticator returned.
+// The passkey comes back with its new signature counter.
+func (rp *RelyingParty) FinishLogin(state, response []byte, lookup func(userID uuid.UUID) (User, error)) (User, Credential, error) {
+	session, err := decodeSession(state)
+	if err != nil {
+		return User{}, Credential{}, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return User{}, Credential{}, verificationError(err)
+	}
+
+	var user User
+	_, credential, err := rp.webauthn.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, ErrUnknownPasskey
+		}
+		user, err = lookup(userID)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	}, session, parsed)
+	if err != nil {
+		return User{}, Credential{}, verificationError(err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return User{}, Credential{}, ErrCloned
+	}
+	return user, newCredential(credential), nil
+}
+
+// BeginSecondFactor starts checking a passkey of a user who has already
+// given their password.
+func (rp *RelyingParty) BeginSecondFactor(user User) (*protocol.CredentialAssertion, []byte, error) {
+	if len(user.Credentials) == 0 {
+		return nil, nil, ErrNoCredentials
+	}
+	assertion, session, err := rp.webauthn.BeginLogin(user,
+		webauthn.WithUserVerification(protocol.VerificationPreferred),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	state, err := json.Marshal(session)
+	return assertion, state, err
+}
+
+// FinishSecondFactor checks the passkey's response for user. The passkey
+// comes back with its new signature counter.
+func (rp *RelyingParty) FinishSecondFactor(user User, state, response []byte) (Credential, error) {
+	session, err := decodeSession(state)
+	if err != nil {
+		return Credential{}, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return Credential{}, verificationError(err)
+	}
+	credential, err := rp.webauthn.ValidateLogin(user, session, parsed)
+	if err != nil {
+		return Credential{}, verificationError(err)
+	}
+	if credential.Authenticator.CloneWarning {
+		return Credential{}, ErrCloned
+	}
+	return newCredential(credential), nil
+}
+
+func decodeSession(state []byte) (webauthn.SessionData, error) {
+	session := webauthn.SessionData{}
+	err := json.Unmarshal(state, &session)
+	if err != nil {
+		return session, err
+	}
+	// Not every validation path checks the expiry, so it's checked here for
+	// all of them.
+	if !session.Expires.IsZero() && time.Now().After(session.Expires) {
+		return session, ErrExpired
+	}
+	return session, nil
+}
+
+// verificationError marks err as a failed check. The library's errors say
+// which check failed and wrap whatever a lookup returned, which is for logs;
+// callers should tell users no more than that it's ErrVerification.
+func verificationError(err error) error {
+	return fmt.Errorf("%w: %w", ErrVerification, err)
+}
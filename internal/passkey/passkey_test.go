@@ -0,0 +1,386 @@
+package passkey
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/google/uuid"
+)
+
+const testOrigin = "https://chirpy.example.com"
+
+// Authenticator data flags, WebAuthn section 6.1.
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagAttestedData   = 0x40
+)
+
+// softAuthenticator is a platform authenticator in software: it makes P-256
+// passkeys with "none" attestation and signs assertions with them.
+type softAuthenticator struct {
+	origin       string
+	userVerified bool
+	credentials  []*softCredential
+}
+
+type softCredential struct {
+	id         []byte
+	key        *ecdsa.PrivateKey
+	userHandle []byte
+	signCount  uint32
+}
+
+func newSoftAuthenticator() *softAuthenticator {
+	return &softAuthenticator{origin: testOrigin, userVerified: true}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) flags() byte {
+	flags := byte(flagUserPresent | flagBackupEligible)
+	if a.userVerified {
+		flags |= flagUserVerified
+	}
+	return flags
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony string, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": b64(challenge),
+		"origin":    a.origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// create answers navigator.credentials.create().
+func (a *softAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) ([]byte, *softCredential) {
+	t.Helper()
+	options := creation.Response
+	for _, excluded := range options.CredentialExcludeList {
+		for _, c := range a.credentials {
+			if bytes.Equal(excluded.CredentialID, c.id) {
+				t.Fatalf("Authenticator already holds an excluded credential")
+			}
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userHandle, ok := options.User.ID.(protocol.URLEncodedBase64)
+	if !ok {
+		t.Fatalf("Didn't get correct user ID type: Got %T", options.User.ID)
+	}
+	credential := &softCredential{id: []byte(rand.Text()), key: key, userHandle: userHandle}
+
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: key.X.FillBytes(make([]byte, 32)),
+		-3: key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpIDHash := sha256.Sum256([]byte(options.RelyingParty.ID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, a.flags()|flagAttestedData)
+	authData = binary.BigEndian.AppendUint32(authData, 0)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(credential.id)))
+	authData = append(authData, credential.id...)
+	authData = append(authData, coseKey...)
+
+	attestationObject, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := json.Marshal(map[string]any{
+		"id":    b64(credential.id),
+		"rawId": b64(credential.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(a.clientData(t, "webauthn.create", options.Challenge)),
+			"attestationObject": b64(attestationObject),
+			"transports":        []string{"internal", "hybrid"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.credentials = append(a.credentials, credential)
+	return response, credential
+}
+
+// get answers navigator.credentials.get() with the given passkey.
+func (a *softAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion, credential *softCredential) []byte {
+	t.Helper()
+	credential.signCount++
+	rpIDHash := sha256.Sum256([]byte(assertion.Response.RelyingPartyID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, a.flags())
+	authData = binary.BigEndian.AppendUint32(authData, credential.signCount)
+
+	clientData := a.clientData(t, "webauthn.get", assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, credential.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := json.Marshal(map[string]any{
+		"id":    b64(credential.id),
+		"rawId": b64(credential.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(credential.userHandle),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func newTestRelyingParty(t *testing.T, timeout time.Duration) *RelyingParty {
+	t.Helper()
+	rp, err := New(Config{
+		RPID:          "chirpy.example.com",
+		RPDisplayName: "Chirpy",
+		Origins:       []string{testOrigin},
+		Timeout:       timeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+// register adds a passkey from authenticator to user.
+func register(t *testing.T, rp *RelyingParty, authenticator *softAuthenticator, user *User) *softCredential {
+	t.Helper()
+	creation, state, err := rp.BeginRegistration(*user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, soft := authenticator.create(t, creation)
+	credential, err := rp.FinishRegistration(*user, state, response)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	user.Credentials = append(user.Credentials, credential)
+	return soft
+}
+
+func TestRegistration(t *testing.T) {
+	rp := newTestRelyingParty(t, time.Minute)
+	authenticator := newSoftAuthenticator()
+	user := User{ID: uuid.New(), Name: "user@example.com"}
+
+	soft := register(t, rp, authenticator, &user)
+	credential := user.Credentials[0]
+	if !bytes.Equal(credential.ID, soft.id) {
+		t.Errorf("Didn't get correct credential ID: Got %x, expected %x", credential.ID, soft.id)
+	}
+	if credential.SignCount != 0 || !credential.BackupEligible || credential.AttestationType != "none" {
+		t.Errorf("Didn't get correct credential: Got %+v", credential)
+	}
+	if len(credential.Transports) != 2 || credential.Transports[0] != "internal" {
+		t.Errorf("Didn't get correct transports: Got %v, expected %v", credential.Transports, []string{"internal", "hybrid"})
+	}
+
+	// A second registration excludes the passkey already held.
+	creation, _, err := rp.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creation.Response.CredentialExcludeList) != 1 || !bytes.Equal(creation.Response.CredentialExcludeList[0].CredentialID, soft.id) {
+		t.Errorf("Didn't get correct exclude list: Got %v", creation.Response.CredentialExcludeList)
+	}
+	if creation.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Errorf("Didn't get correct resident key requirement: Got %v, expected %v", creation.Response.AuthenticatorSelection.ResidentKey, protocol.ResidentKeyRequirementRequired)
+	}
+
+	// The response has to come from one of our origins.
+	other := newSoftAuthenticator()
+	other.origin = "https://evil.example.com"
+	creation, state, err := rp.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := other.create(t, creation)
+	_, err = rp.FinishRegistration(user, state, response)
+	if !errors.Is(err, ErrVerification) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrVerification)
+	}
+}
+
+func TestPasswordlessLogin(t *testing.T) {
+	rp := newTestRelyingParty(t, time.Minute)
+	authenticator := newSoftAuthenticator()
+	user := User{ID: uuid.New(), Name: "user@example.com"}
+	soft := register(t, rp, authenticator, &user)
+
+	lookup := func(userID uuid.UUID) (User, error) {
+		if userID != user.ID {
+			return User{}, ErrUnknownPasskey
+		}
+		return user, nil
+	}
+
+	assertion, state, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 0 {
+		t.Errorf("Didn't get correct allowed credentials: Got %v, expected none", assertion.Response.AllowedCredentials)
+	}
+	response := authenticator.get(t, assertion, soft)
+	gotUser, credential, err := rp.FinishLogin(state, response, lookup)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	if gotUser.ID != user.ID {
+		t.Errorf("Didn't get correct user: Got %v, expected %v", gotUser.ID, user.ID)
+	}
+	if credential.SignCount != 1 {
+		t.Errorf("Didn't get correct sign count: Got %d, expected %d", credential.SignCount, 1)
+	}
+	user.Credentials[0] = credential
+
+	// Replaying the response carries a counter we've already seen.
+	_, _, err = rp.FinishLogin(state, response, lookup)
+	if !errors.Is(err, ErrCloned) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrCloned)
+	}
+
+	// Passwordless sign-in needs user verification.
+	authenticator.userVerified = false
+	assertion, state, err = rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(state, authenticator.get(t, assertion, soft), lookup)
+	if !errors.Is(err, ErrVerification) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrVerification)
+	}
+	authenticator.userVerified = true
+
+	// A passkey for someone we don't know.
+	stranger := register(t, rp, newSoftAuthenticator(), &User{ID: uuid.New(), Name: "stranger@example.com"})
+	assertion, state, err = rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(state, authenticator.get(t, assertion, stranger), lookup)
+	if !errors.Is(err, ErrUnknownPasskey) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrUnknownPasskey)
+	}
+
+	// A signature from the wrong key.
+	forged := *soft
+	forged.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.signCount = 10
+	assertion, state, err = rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rp.FinishLogin(state, authenticator.get(t, assertion, &forged), lookup)
+	if !errors.Is(err, ErrVerification) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrVerification)
+	}
+}
+
+func TestSecondFactor(t *testing.T) {
+	rp := newTestRelyingParty(t, time.Minute)
+	authenticator := newSoftAuthenticator()
+	user := User{ID: uuid.New(), Name: "user@example.com"}
+
+	_, _, err := rp.BeginSecondFactor(user)
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrNoCredentials)
+	}
+
+	soft := register(t, rp, authenticator, &user)
+	assertion, state, err := rp.BeginSecondFactor(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 1 {
+		t.Errorf("Didn't get correct allowed credentials: Got %v, expected 1", assertion.Response.AllowedCredentials)
+	}
+	credential, err := rp.FinishSecondFactor(user, state, authenticator.get(t, assertion, soft))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected %v", err, nil)
+	}
+	if credential.SignCount != 1 {
+		t.Errorf("Didn't get correct sign count: Got %d, expected %d", credential.SignCount, 1)
+	}
+	user.Credentials[0] = credential
+
+	// Another user's passkey doesn't count for this one.
+	other := User{ID: uuid.New(), Name: "other@example.com"}
+	otherSoft := register(t, rp, newSoftAuthenticator(), &other)
+	assertion, state, err = rp.BeginSecondFactor(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rp.FinishSecondFactor(user, state, authenticator.get(t, assertion, otherSoft))
+	if !errors.Is(err, ErrVerification) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrVerification)
+	}
+}
+
+func TestCeremonyExpiry(t *testing.T) {
+	authenticator := newSoftAuthenticator()
+	user := User{ID: uuid.New(), Name: "user@example.com"}
+	soft := register(t, newTestRelyingParty(t, time.Minute), authenticator, &user)
+
+	rp := newTestRelyingParty(t, 10*time.Millisecond)
+
+	assertion, state, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, _, err = rp.FinishLogin(state, authenticator.get(t, assertion, soft), func(uuid.UUID) (User, error) {
+		return user, nil
+	})
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("Error generated: Got %v, expected %v", err, ErrExpired)
+	}
+}
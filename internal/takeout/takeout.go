@@ -0,0 +1,128 @@
+// Package takeout builds the archive users download when they ask for a
+// copy of their data, and signs the links it's downloaded from.
+package takeout
+
+import (
+	"archive/zip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrLinkExpired   = errors.New("download link has expired")
+	ErrLinkSignature = errors.New("download link signature is invalid")
+)
+
+// Chirp is a chirp as it appears in the archive.
+type Chirp struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+	Hidden    bool      `json:"hidden_by_moderator,omitempty"`
+}
+
+// Archive writes a zip file. Files are added in the order they should be
+// read in.
+type Archive struct {
+	zip *zip.Writer
+}
+
+func NewArchive(w io.Writer) *Archive {
+	return &Archive{zip: zip.NewWriter(w)}
+}
+
+// AddJSON adds v as an indented JSON file.
+func (a *Archive) AddJSON(name string, v any) error {
+	f, err := a.create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// AddChirpsHTML adds a page listing the chirps, for people rather than
+// programs to read.
+func (a *Archive) AddChirpsHTML(name, owner string, chirps []Chirp) error {
+	f, err := a.create(name)
+	if err != nil {
+		return err
+	}
+	return chirpsPage.Execute(f, struct {
+		Owner  string
+		Chirps []Chirp
+	}{owner, chirps})
+}
+
+// Close finishes the zip file. The archive is incomplete until it's called.
+func (a *Archive) Close() error {
+	return a.zip.Close()
+}
+
+func (a *Archive) create(name string) (io.Writer, error) {
+	return a.zip.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+var chirpsPage = template.Must(template.New("chirps").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Chirps by {{.Owner}}</title>
+</head>
+<body>
+<h1>Chirps by {{.Owner}}</h1>
+{{range .Chirps}}<article>
+<p>{{.Body}}</p>
+<time datetime="{{.CreatedAt.Format "2006-01-02T15:04:05Z07:00"}}">{{.CreatedAt.Format "2 January 2006 15:04"}}</time>{{if .Hidden}} (hidden by a moderator){{end}}
+</article>
+{{else}}<p>No chirps yet.</p>
+{{end}}</body>
+</html>
+`))
+
+// SignLink returns the query parameters that let anyone holding the link
+// download archive id until expires.
+func SignLink(secret []byte, id string, expires time.Time) url.Values {
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		"expires":   {unix},
+		"signature": {signature(secret, id, unix)},
+	}
+}
+
+// VerifyLink checks the query parameters of a download link for archive id.
+func VerifyLink(secret []byte, id string, query url.Values, now time.Time) error {
+	unix := query.Get("expires")
+	expires, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return ErrLinkSignature
+	}
+	if !hmac.Equal([]byte(signature(secret, id, unix)), []byte(query.Get("signature"))) {
+		return ErrLinkSignature
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return ErrLinkExpired
+	}
+	return nil
+}
+
+func signature(secret []byte, id, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package takeout
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	chirps := []Chirp{
+		{ID: "1", CreatedAt: time.Now(), Body: "<script>alert(1)</script>"},
+		{ID: "2", CreatedAt: time.Now(), Body: "hello", Hidden: true},
+	}
+
+	buf := bytes.Buffer{}
+	archive := NewArchive(&buf)
+	err := archive.AddJSON("chirps.json", chirps)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = archive.AddChirpsHTML("chirps.html", "walt@example.com", chirps)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	err = archive.Close()
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	files := map[string]string{}
+	for _, f := range reader.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Error generated: Got %v, expected nil", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	got := []Chirp{}
+	err = json.Unmarshal([]byte(files["chirps.json"]), &got)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	if len(got) != 2 || got[0].Body != chirps[0].Body || !got[1].Hidden {
+		t.Errorf("Didn't get correct chirps back: Got %v, expected %v", got, chirps)
+	}
+
+	page := files["chirps.html"]
+	if strings.Contains(page, "<script>") {
+		t.Errorf("Didn't escape chirp bodies: Got %q", page)
+	}
+	if !strings.Contains(page, "walt@example.com") || !strings.Contains(page, "hidden by a moderator") {
+		t.Errorf("Didn't get correct page: Got %q", page)
+	}
+}
+
+func TestVerifyLink(t *testing.T) {
+	secret := []byte("omgsecret")
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	link := SignLink(secret, "job", expires)
+
+	// with returns the link's parameters with one of them changed.
+	with := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range link {
+			query[k] = v
+		}
+		query.Set(key, value)
+		return query
+	}
+
+	cases := []struct {
+		name   string
+		id     string
+		query  url.Values
+		secret []byte
+		now    time.Time
+		want   error
+	}{
+		{name: "valid", id: "job", query: link, secret: secret, now: now, want: nil},
+		{name: "expired", id: "job", query: link, secret: secret, now: expires.Add(time.Second), want: ErrLinkExpired},
+		{name: "other archive", id: "other", query: link, secret: secret, now: now, want: ErrLinkSignature},
+		{name: "extended expiry", id: "job", query: with("expires", strconv.FormatInt(expires.Add(time.Hour).Unix(), 10)), secret: secret, now: now, want: ErrLinkSignature},
+		{name: "malformed expiry", id: "job", query: with("expires", "soon"), secret: secret, now: now, want: ErrLinkSignature},
+		{name: "missing signature", id: "job", query: with("signature", ""), secret: secret, now: now, want: ErrLinkSignature},
+		{name: "other secret", id: "job", query: link, secret: []byte("other"), now: now, want: ErrLinkSignature},
+	}
+	for _, c := range cases {
+		err := VerifyLink(c.secret, c.id, c.query, c.now)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%s: Error generated: Got %v, expected %v", c.name, err, c.want)
+		}
+	}
+}
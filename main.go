@@ -1,23 +1,67 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/wjseele/chirpy/internal/auth"
 	"github.com/wjseele/chirpy/internal/database"
+	"github.com/wjseele/chirpy/internal/mailer"
+	"github.com/wjseele/chirpy/internal/oauth"
+	"github.com/wjseele/chirpy/internal/oidc"
+	"github.com/wjseele/chirpy/internal/passkey"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	platform       string
-	tokenSecret    string
+	fileserverHits   atomic.Int32
+	db               *sql.DB
+	dbQueries        *database.Queries
+	platform         string
+	jwt              auth.JWTConfig
+	accessTokenTTL   time.Duration
+	refreshTokenTTL  time.Duration
+	mailer           mailer.Mailer
+	baseURL          string
+	passwordResetTTL time.Duration
+
+	accountDeletionGrace time.Duration
+
+	exportTTL        time.Duration
+	exportLinkTTL    time.Duration
+	exportLinkSecret []byte
+	exportWake       chan struct{}
+
+	importMaxBytes int64
+	importWake     chan struct{}
+
+	emailVerificationTTL   time.Duration
+	unverifiedRestrictions map[string]bool
+
+	accountLockout     auth.LockoutPolicy
+	ipLockout          auth.LockoutPolicy
+	loginFailureWindow time.Duration
+
+	passwordPolicy auth.PasswordPolicy
+	argon2         auth.Argon2Params
+
+	oauth *oauth.Server
+	oidc  *oidc.Provider
+	// oidcFrontendURL is the page a single sign-on sends the browser back
+	// to, with a code for tokens.
+	oidcFrontendURL *url.URL
+
+	passkeys *passkey.RelyingParty
 }
 
 func main() {
@@ -27,8 +71,229 @@ func main() {
 		os.Exit(1)
 	}
 	dbURL := os.Getenv("DB_URL")
+	if len(os.Args) > 1 {
+		db, err := sql.Open("postgres", dbURL)
+		if err != nil {
+			log.Printf("Error connnecting to database: %s", err)
+			os.Exit(1)
+		}
+		err = runCommand(context.Background(), db, os.Args[1:])
+		db.Close()
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
 	platform := os.Getenv("PLATFORM")
-	tokenSecret := os.Getenv("SECRET")
+	jwtKeys := auth.NewHMACKeyring(os.Getenv("SECRET"))
+	if keyringPath := os.Getenv("JWT_KEYRING"); keyringPath != "" {
+		jwtKeys, err = auth.LoadKeyring(keyringPath)
+		if err != nil {
+			log.Printf("Error loading JWT keyring: %s", err)
+			os.Exit(1)
+		}
+	}
+	accessTokenTTL, err := durationFromEnv("ACCESS_TOKEN_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	refreshTokenTTL, err := durationFromEnv("REFRESH_TOKEN_TTL", 60*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	jwtLeeway, err := leewayFromEnv("JWT_LEEWAY", 30*time.Second)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	passwordResetTTL, err := durationFromEnv("PASSWORD_RESET_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	emailVerificationTTL, err := durationFromEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	accountDeletionGrace, err := durationFromEnv("ACCOUNT_DELETION_GRACE", 30*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	accountPurgeInterval, err := durationFromEnv("ACCOUNT_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	exportTTL, err := durationFromEnv("EXPORT_TTL", 7*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	exportLinkTTL, err := durationFromEnv("EXPORT_LINK_TTL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	exportPollInterval, err := durationFromEnv("EXPORT_POLL_INTERVAL", time.Minute)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	importMaxBytes, err := intFromEnv("IMPORT_MAX_BYTES", 10<<20)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	importPollInterval, err := durationFromEnv("IMPORT_POLL_INTERVAL", time.Minute)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	// Download links are signed with a key of their own, derived from SECRET
+	// unless one is given. Without either, links stop working on restart.
+	exportLinkSecret := []byte(os.Getenv("EXPORT_LINK_SECRET"))
+	if len(exportLinkSecret) == 0 && os.Getenv("SECRET") != "" {
+		exportLinkSecret = []byte(auth.HashToken("chirpy export links\n" + os.Getenv("SECRET")))
+	}
+	if len(exportLinkSecret) == 0 {
+		log.Println("EXPORT_LINK_SECRET isn't set, download links won't survive a restart")
+		randomSecret, err := auth.MakeRefreshToken()
+		if err != nil {
+			log.Printf("Error generating export link secret: %s", err)
+			os.Exit(1)
+		}
+		exportLinkSecret = []byte(randomSecret)
+	}
+	// Set but empty means no restrictions, so the default only applies when
+	// the variable is unset.
+	restrictions, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !ok {
+		restrictions = restrictPostChirps
+	}
+	unverifiedRestrictions, err := parseRestrictions(restrictions)
+	if err != nil {
+		log.Printf("Invalid UNVERIFIED_RESTRICTIONS: %s", err)
+		os.Exit(1)
+	}
+	lockoutBase, err := durationFromEnv("LOGIN_LOCKOUT_BASE", 30*time.Second)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	lockoutMax, err := durationFromEnv("LOGIN_LOCKOUT_MAX", 15*time.Minute)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	loginFailureWindow, err := durationFromEnv("LOGIN_FAILURE_WINDOW", 24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	accountFreeAttempts, err := intFromEnv("LOGIN_MAX_FAILURES", 5)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	ipFreeAttempts, err := intFromEnv("LOGIN_IP_MAX_FAILURES", 20)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	passwordMinLength, err := intFromEnv("PASSWORD_MIN_LENGTH", 10)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	passwordMinStrength, err := intFromEnv("PASSWORD_MIN_STRENGTH", 3)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	argon2Memory, err := intFromEnv("ARGON2_MEMORY", int(auth.DefaultArgon2Params.Memory))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	argon2Iterations, err := intFromEnv("ARGON2_ITERATIONS", int(auth.DefaultArgon2Params.Iterations))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	argon2Parallelism, err := intFromEnv("ARGON2_PARALLELISM", int(auth.DefaultArgon2Params.Parallelism))
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if argon2Memory < 8*argon2Parallelism || argon2Iterations < 1 || argon2Parallelism < 1 || argon2Parallelism > 255 {
+		log.Println("invalid ARGON2_* settings: need 1-255 lanes, at least 1 iteration and 8 KiB of memory per lane")
+		os.Exit(1)
+	}
+	var breachedPasswords *auth.BreachedPasswords
+	if breachedPath := os.Getenv("BREACHED_PASSWORDS"); breachedPath != "" {
+		breachedPasswords, err = auth.LoadBreachedPasswords(breachedPath)
+		if err != nil {
+			log.Printf("Error loading breached passwords: %s", err)
+			os.Exit(1)
+		}
+	}
+	baseURL := stringFromEnv("APP_BASE_URL", "http://localhost:8080")
+	var oidcProvider *oidc.Provider
+	if oidcIssuer := os.Getenv("OIDC_ISSUER"); oidcIssuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		oidcProvider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       oidcIssuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  stringFromEnv("OIDC_REDIRECT_URL", baseURL+"/api/login/oidc/callback"),
+			Scopes:       []string{"email", "profile"},
+			Leeway:       jwtLeeway,
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Error setting up OIDC login: %s", err)
+			os.Exit(1)
+		}
+	}
+	parsedBaseURL, err := url.Parse(baseURL)
+	if err != nil {
+		log.Printf("Error parsing APP_BASE_URL: %s", err)
+		os.Exit(1)
+	}
+	oidcFrontendURL, err := url.Parse(stringFromEnv("OIDC_FRONTEND_URL", baseURL+"/login/oidc"))
+	if err != nil {
+		log.Printf("Error parsing OIDC_FRONTEND_URL: %s", err)
+		os.Exit(1)
+	}
+	passkeys, err := passkey.New(passkey.Config{
+		RPID:          stringFromEnv("WEBAUTHN_RP_ID", parsedBaseURL.Hostname()),
+		RPDisplayName: stringFromEnv("WEBAUTHN_RP_NAME", "Chirpy"),
+		Origins:       strings.Split(stringFromEnv("WEBAUTHN_ORIGINS", parsedBaseURL.Scheme+"://"+parsedBaseURL.Host), ","),
+		Timeout:       webauthnCeremonyTTL,
+	})
+	if err != nil {
+		log.Printf("Error setting up passkeys: %s", err)
+		os.Exit(1)
+	}
+	mailFrom := stringFromEnv("MAIL_FROM", "chirpy@localhost")
+	var appMailer mailer.Mailer = &mailer.LogMailer{
+		Dir:  os.Getenv("MAIL_DIR"),
+		From: mailFrom,
+	}
+	if os.Getenv("MAILER") == "smtp" {
+		appMailer = &mailer.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     stringFromEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     mailFrom,
+		}
+	}
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Printf("Error connnecting to database: %s", err)
@@ -40,27 +305,155 @@ func main() {
 	serveMux := http.NewServeMux()
 	server := http.Server{
 		Addr:    ":8080",
-		Handler: serveMux,
+		Handler: middlewareRequestID(serveMux),
 	}
 	apiCfg := apiConfig{
-		dbQueries:   dbQueries,
-		platform:    platform,
-		tokenSecret: tokenSecret,
+		db:        db,
+		dbQueries: dbQueries,
+		platform:  platform,
+		jwt: auth.JWTConfig{
+			Keys:     jwtKeys,
+			Issuer:   stringFromEnv("JWT_ISSUER", "chirpy"),
+			Audience: stringFromEnv("JWT_AUDIENCE", "chirpy"),
+			Leeway:   jwtLeeway,
+		},
+		accessTokenTTL:   accessTokenTTL,
+		refreshTokenTTL:  refreshTokenTTL,
+		mailer:           appMailer,
+		baseURL:          baseURL,
+		passwordResetTTL: passwordResetTTL,
+
+		accountDeletionGrace: accountDeletionGrace,
+
+		exportTTL:        exportTTL,
+		exportLinkTTL:    exportLinkTTL,
+		exportLinkSecret: exportLinkSecret,
+		exportWake:       make(chan struct{}, 1),
+
+		importMaxBytes: int64(importMaxBytes),
+		importWake:     make(chan struct{}, 1),
+
+		emailVerificationTTL:   emailVerificationTTL,
+		unverifiedRestrictions: unverifiedRestrictions,
+
+		accountLockout: auth.LockoutPolicy{
+			FreeAttempts: int32(accountFreeAttempts),
+			BaseDelay:    lockoutBase,
+			MaxDelay:     lockoutMax,
+		},
+		ipLockout: auth.LockoutPolicy{
+			FreeAttempts: int32(ipFreeAttempts),
+			BaseDelay:    lockoutBase,
+			MaxDelay:     lockoutMax,
+		},
+		loginFailureWindow: loginFailureWindow,
+
+		passwordPolicy: auth.PasswordPolicy{
+			MinLength:   passwordMinLength,
+			MaxLength:   256,
+			MinStrength: passwordMinStrength,
+			Breached:    breachedPasswords,
+		},
+		argon2: auth.Argon2Params{
+			Memory:      uint32(argon2Memory),
+			Iterations:  uint32(argon2Iterations),
+			Parallelism: uint8(argon2Parallelism),
+			SaltLength:  auth.DefaultArgon2Params.SaltLength,
+			KeyLength:   auth.DefaultArgon2Params.KeyLength,
+		},
+
+		oidc:            oidcProvider,
+		oidcFrontendURL: oidcFrontendURL,
+		passkeys:        passkeys,
+	}
+
+	apiCfg.oauth = &oauth.Server{
+		Store:           oauthStore{q: dbQueries},
+		Users:           oauthUsers{cfg: &apiCfg},
+		JWT:             apiCfg.oauthJWT(),
+		Scopes:          oauthScopes,
+		CodeTTL:         oauthCodeTTL,
+		AccessTokenTTL:  accessTokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
 	}
 
 	serveMux.Handle("/app/", http.StripPrefix("/app/", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
 	serveMux.HandleFunc("GET /api/healthz", handlerHealthz)
-	serveMux.HandleFunc("GET /admin/metrics", apiCfg.handlerCounter)
-	serveMux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
+	serveMux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+	serveMux.HandleFunc("GET /admin/metrics", apiCfg.requirePermission(auth.PermissionViewMetrics, apiCfg.handlerCounter))
+	serveMux.HandleFunc("POST /admin/reset", apiCfg.requirePermission(auth.PermissionResetDatabase, apiCfg.handlerReset))
+	serveMux.HandleFunc("GET /admin/lockouts", apiCfg.requirePermission(auth.PermissionManageLockouts, apiCfg.handlerListLockouts))
+	serveMux.HandleFunc("DELETE /admin/lockouts/{subject}", apiCfg.requirePermission(auth.PermissionManageLockouts, apiCfg.handlerClearLockout))
+	serveMux.HandleFunc("GET /admin/users", apiCfg.requirePermission(auth.PermissionViewUsers, apiCfg.handlerListUsers))
+	serveMux.HandleFunc("GET /admin/users/{userID}", apiCfg.requirePermission(auth.PermissionViewUsers, apiCfg.handlerGetUser))
+	serveMux.HandleFunc("GET /admin/users/{userID}/sessions", apiCfg.requirePermission(auth.PermissionViewUsers, apiCfg.handlerListUserSessions))
+	serveMux.HandleFunc("POST /admin/users/{userID}/suspend", apiCfg.requirePermission(auth.PermissionSuspendUsers, apiCfg.handlerSuspendUser))
+	serveMux.HandleFunc("POST /admin/users/{userID}/unsuspend", apiCfg.requirePermission(auth.PermissionSuspendUsers, apiCfg.handlerUnsuspendUser))
+	serveMux.HandleFunc("POST /admin/users/{userID}/password-reset", apiCfg.requirePermission(auth.PermissionManageUsers, apiCfg.handlerForcePasswordReset))
+	serveMux.HandleFunc("PUT /admin/users/{userID}/role", apiCfg.requirePermission(auth.PermissionManageUsers, apiCfg.handlerSetUserRole))
+	serveMux.HandleFunc("DELETE /admin/users/{userID}", apiCfg.requirePermission(auth.PermissionManageUsers, apiCfg.handlerDeleteUser))
+	serveMux.HandleFunc("GET /admin/reports", apiCfg.requirePermission(auth.PermissionModerate, apiCfg.handlerListReports))
+	serveMux.HandleFunc("GET /admin/reports/{reportID}", apiCfg.requirePermission(auth.PermissionModerate, apiCfg.handlerGetReport))
+	serveMux.HandleFunc("POST /admin/reports/{reportID}/claim", apiCfg.requirePermission(auth.PermissionModerate, apiCfg.handlerClaimReport))
+	serveMux.HandleFunc("POST /admin/reports/{reportID}/resolve", apiCfg.requirePermission(auth.PermissionModerate, apiCfg.handlerResolveReport))
+	serveMux.HandleFunc("GET /admin/audit", apiCfg.requirePermission(auth.PermissionViewAudit, apiCfg.handlerListAuditEvents))
 	serveMux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
 	serveMux.HandleFunc("POST /api/users", apiCfg.handlerCreateUser)
-	serveMux.HandleFunc("PUT /api/users", apiCfg.handlerUpdateUser)
+	serveMux.HandleFunc("PUT /api/users", apiCfg.handlerPutUser)
 	serveMux.HandleFunc("POST /api/login", apiCfg.handlerLoginUser)
+	serveMux.HandleFunc("POST /api/login/mfa", apiCfg.handlerLoginMFA)
+	serveMux.HandleFunc("POST /api/login/mfa/passkey/begin", apiCfg.handlerBeginPasskeyMFA)
+	serveMux.HandleFunc("POST /api/login/mfa/passkey", apiCfg.handlerPasskeyMFA)
+	serveMux.HandleFunc("POST /api/login/passkey/begin", apiCfg.handlerBeginPasskeyLogin)
+	serveMux.HandleFunc("POST /api/login/passkey", apiCfg.handlerPasskeyLogin)
+	if apiCfg.oidc != nil {
+		serveMux.HandleFunc("GET /api/login/oidc", apiCfg.handlerOIDCLogin)
+		serveMux.HandleFunc("GET /api/login/oidc/callback", apiCfg.handlerOIDCCallback)
+		serveMux.HandleFunc("POST /api/login/oidc/token", apiCfg.handlerOIDCToken)
+	}
 	serveMux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
 	serveMux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
+	serveMux.HandleFunc("POST /api/password-reset/request", apiCfg.handlerRequestPasswordReset)
+	serveMux.HandleFunc("POST /api/password-reset/confirm", apiCfg.handlerConfirmPasswordReset)
+	serveMux.HandleFunc("POST /api/email-verification/confirm", apiCfg.handlerConfirmEmail)
+	serveMux.HandleFunc("POST /api/email-verification/resend", apiCfg.handlerResendEmailVerification)
+	serveMux.HandleFunc("POST /api/users/me/totp", apiCfg.handlerEnrollTOTP)
+	serveMux.HandleFunc("POST /api/users/me/totp/confirm", apiCfg.handlerConfirmTOTP)
+	serveMux.HandleFunc("DELETE /api/users/me/totp", apiCfg.handlerDisableTOTP)
+	serveMux.HandleFunc("POST /api/users/me/totp/recovery-codes", apiCfg.handlerRegenerateRecoveryCodes)
+	serveMux.HandleFunc("GET /api/users/me/security-events", apiCfg.handlerListSecurityEvents)
+	serveMux.HandleFunc("GET /api/users/me/sessions", apiCfg.handlerListSessions)
+	serveMux.HandleFunc("DELETE /api/users/me/sessions", apiCfg.handlerRevokeAllSessions)
+	serveMux.HandleFunc("DELETE /api/users/me/sessions/{sessionID}", apiCfg.handlerRevokeSession)
+	serveMux.HandleFunc("POST /api/users/me/passkeys/begin", apiCfg.handlerBeginPasskeyRegistration)
+	serveMux.HandleFunc("POST /api/users/me/passkeys", apiCfg.handlerCreatePasskey)
+	serveMux.HandleFunc("GET /api/users/me/passkeys", apiCfg.handlerListPasskeys)
+	serveMux.HandleFunc("DELETE /api/users/me/passkeys/{passkeyID}", apiCfg.handlerDeletePasskey)
+	serveMux.HandleFunc("POST /api/users/me/reauth/passkey/begin", apiCfg.handlerBeginPasskeyReauth)
+	serveMux.HandleFunc("POST /api/users/me/reauth", apiCfg.handlerReauth)
+	serveMux.HandleFunc("POST /api/users/me/api-keys", apiCfg.handlerCreateAPIKey)
+	serveMux.HandleFunc("GET /api/users/me/api-keys", apiCfg.handlerListAPIKeys)
+	serveMux.HandleFunc("DELETE /api/users/me/api-keys/{keyID}", apiCfg.handlerRevokeAPIKey)
+	serveMux.HandleFunc("POST /api/oauth/clients", apiCfg.handlerCreateOAuthClient)
+	serveMux.HandleFunc("GET /api/oauth/clients", apiCfg.handlerListOAuthClients)
+	serveMux.HandleFunc("DELETE /api/oauth/clients/{clientID}", apiCfg.handlerDeleteOAuthClient)
+	serveMux.HandleFunc("PATCH /api/users/me", apiCfg.handlerUpdateUser)
+	serveMux.HandleFunc("DELETE /api/users/me", apiCfg.handlerDeleteAccount)
+	serveMux.HandleFunc("POST /api/users/me/export", apiCfg.handlerRequestExport)
+	serveMux.HandleFunc("GET /api/users/me/export/{exportID}", apiCfg.handlerGetExport)
+	serveMux.HandleFunc("GET /api/exports/{exportID}/download", apiCfg.handlerDownloadExport)
+	serveMux.HandleFunc("POST /api/users/me/imports", apiCfg.handlerRequestImport)
+	serveMux.HandleFunc("GET /api/users/me/imports/{importID}", apiCfg.handlerGetImport)
+	apiCfg.oauth.Register(serveMux)
 	serveMux.HandleFunc("GET /api/chirps", apiCfg.handlerGetAllChirps)
 	serveMux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetSpecificChirp)
 	serveMux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
+	serveMux.HandleFunc("POST /api/chirps/{chirpID}/report", apiCfg.handlerReportChirp)
+	serveMux.HandleFunc("POST /api/users/{userID}/report", apiCfg.handlerReportUser)
+
+	go apiCfg.purgeAccounts(context.Background(), accountPurgeInterval)
+	go apiCfg.runExports(context.Background(), exportPollInterval)
+	go apiCfg.runImports(context.Background(), importPollInterval)
 
 	err = server.ListenAndServe()
 	if err != nil {
@@ -68,3 +461,64 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// stringFromEnv reads a string from the environment, falling back to the
+// given default when the variable is unset.
+func stringFromEnv(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// intFromEnv reads a non-negative integer from the environment, falling back
+// to the given default when the variable is unset.
+func intFromEnv(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return n, nil
+}
+
+// durationFromEnv reads a duration such as "1h" or "1440h" from the
+// environment, falling back to the given default when the variable is unset.
+func durationFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s: must be positive", key)
+	}
+	return d, nil
+}
+
+// leewayFromEnv reads a duration like durationFromEnv does, but also accepts
+// zero, which turns the leeway off.
+func leewayFromEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid %s: must not be negative", key)
+	}
+	return d, nil
+}
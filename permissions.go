@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+// requirePermission lets a request through to next only if its access token
+// carries a role with permission. The role is taken from the token, which
+// can be trusted for its lifetime since changing a role revokes the user's
+// tokens. API keys and OAuth tokens never get through.
+func (cfg *apiConfig) requirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token, err := auth.GetBearerToken(req.Header)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if auth.IsAPIKey(token) {
+			respondWithAuthError(w, auth.ErrAPIKeyNotAllowed)
+			return
+		}
+		claims, err := cfg.accessTokenClaims(req, token)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		if !auth.HasPermission(claims.Role, permission) {
+			respondWithCode(w, 403, "permission_denied", "You don't have permission to do this")
+			return
+		}
+		next(w, req.WithContext(context.WithValue(req.Context(), claimsContextKey, claims)))
+	}
+}
+
+// actorClaims returns the access token claims of a request that passed
+// requirePermission.
+func actorClaims(req *http.Request) (auth.Claims, bool) {
+	claims, ok := req.Context().Value(claimsContextKey).(auth.Claims)
+	return claims, ok
+}
+
+// actorHasPermission reports whether the user behind a request that passed
+// requirePermission also holds permission.
+func actorHasPermission(req *http.Request, permission string) bool {
+	claims, ok := actorClaims(req)
+	return ok && auth.HasPermission(claims.Role, permission)
+}
+
+// actorID returns the user behind a request that passed requirePermission,
+// or uuid.Nil for any other request.
+func actorID(req *http.Request) uuid.UUID {
+	claims, ok := actorClaims(req)
+	if !ok {
+		return uuid.Nil
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil
+	}
+	return userID
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// problem is an error response in the RFC 7807 problem details format.
+// Code is stable, for programs to act on; Title and Detail are for people.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+	// Violations repeats the password policy violations from Errors at the
+	// top level, where clients looked for them before errors were problems.
+	Violations []auth.PolicyViolation `json:"violations,omitempty"`
+}
+
+// statusCodes are the problem codes of responses that don't give their own.
+var statusCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	409: "conflict",
+	410: "gone",
+	413: "payload_too_large",
+	415: "unsupported_media_type",
+	422: "validation_failed",
+	429: "too_many_requests",
+	500: "internal_error",
+	503: "unavailable",
+}
+
+// respondWithError responds with a problem whose code comes from its status.
+func respondWithError(w http.ResponseWriter, status int, detail string) {
+	respondWithProblem(w, problem{Status: status, Detail: detail})
+}
+
+// respondWithCode responds with a problem that has a code of its own, for
+// errors that clients are expected to tell apart.
+func respondWithCode(w http.ResponseWriter, status int, code, detail string) {
+	respondWithProblem(w, problem{Status: status, Code: code, Detail: detail})
+}
+
+// respondWithInternalError responds to an error the client can't do anything
+// about. It's logged with the request ID, which the response carries so the
+// two can be matched up, and its text stays out of the response. A row that
+// wasn't found is the exception and gets a 404.
+func respondWithInternalError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	log.Printf("Internal error in request %s: %s", w.Header().Get(requestIDHeader), err)
+	respondWithError(w, 500, "Something went wrong on our side")
+}
+
+func respondWithProblem(w http.ResponseWriter, p problem) {
+	if p.Code == "" {
+		p.Code = statusCodes[p.Status]
+	}
+	if p.Code == "" {
+		p.Code = "error"
+	}
+	p.Type = "urn:chirpy:problem:" + p.Code
+	p.Title = http.StatusText(p.Status)
+	p.RequestID = w.Header().Get(requestIDHeader)
+
+	dat, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Error in the responder: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(dat)
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID is what a request ID given by the client has to look like
+// to be used, so it's safe to put in logs.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// middlewareRequestID gives every request an ID, taken from its X-Request-ID
+// header when it has a usable one. The ID is sent back in the same header,
+// where respondWithProblem and respondWithInternalError pick it up.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, req)
+	})
+}
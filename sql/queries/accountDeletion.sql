@@ -0,0 +1,14 @@
+-- name: ScheduleAccountDeletion :exec
+UPDATE users
+SET deletion_due_at = $2, updated_at = now()
+WHERE id = $1;
+
+-- name: CancelAccountDeletion :execrows
+UPDATE users
+SET deletion_due_at = NULL, updated_at = now()
+WHERE id = $1 AND deletion_due_at IS NOT NULL;
+
+-- name: PurgeDueAccounts :many
+DELETE FROM users
+WHERE deletion_due_at <= now()
+RETURNING id, email;
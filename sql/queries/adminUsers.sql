@@ -0,0 +1,36 @@
+-- name: SearchUsers :many
+SELECT *
+FROM users
+WHERE (sqlc.narg(email)::text IS NULL OR email ILIKE sqlc.narg(email))
+    AND (sqlc.narg(role)::text IS NULL OR role = sqlc.narg(role))
+    AND (sqlc.narg(suspended)::bool IS NULL OR (suspended_at IS NOT NULL) = sqlc.narg(suspended))
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: GetUserActivity :one
+SELECT
+    (SELECT count(*) FROM chirps WHERE chirps.user_id = $1) AS chirps,
+    (SELECT count(DISTINCT family_id) FROM refresh_tokens
+        WHERE refresh_tokens.user_id = $1 AND revoked_at IS NULL AND expires_at > now()) AS sessions,
+    (SELECT count(*) FROM passkeys WHERE passkeys.user_id = $1) AS passkeys,
+    (SELECT count(*) FROM api_keys
+        WHERE api_keys.user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())) AS api_keys;
+
+-- name: SuspendUser :execrows
+UPDATE users
+SET suspended_at = now(), suspension_reason = $2, updated_at = now()
+WHERE id = $1;
+
+-- name: UnsuspendUser :execrows
+UPDATE users
+SET suspended_at = NULL, suspension_reason = '', updated_at = now()
+WHERE id = $1 AND suspended_at IS NOT NULL;
+
+-- name: SetUserRole :exec
+UPDATE users
+SET role = $2, updated_at = now()
+WHERE id = $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
@@ -0,0 +1,37 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, created_at, expires_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    now(),
+    $6
+)
+RETURNING *;
+
+-- name: ListAPIKeys :many
+SELECT *
+FROM api_keys
+WHERE user_id = $1 AND revoked_at IS NULL
+ORDER BY created_at DESC;
+
+-- name: UseAPIKey :one
+UPDATE api_keys
+SET last_used_at = now()
+WHERE key_hash = $1
+    AND revoked_at IS NULL
+    AND (expires_at IS NULL OR expires_at > now())
+RETURNING user_id, scopes;
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys
+SET revoked_at = now()
+WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllAPIKeys :exec
+UPDATE api_keys
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,30 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events(id, created_at, event_type, actor_id, target_id, ip_address, user_agent, payload)
+VALUES(
+    gen_random_uuid(),
+    now(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: ListAuditEvents :many
+SELECT *
+FROM audit_events
+WHERE (sqlc.narg(event_types)::TEXT[] IS NULL OR event_type = ANY(sqlc.narg(event_types)::TEXT[]))
+    AND (sqlc.narg(actor_id)::UUID IS NULL OR actor_id = sqlc.narg(actor_id))
+    AND (sqlc.narg(target_id)::UUID IS NULL OR target_id = sqlc.narg(target_id))
+    AND (sqlc.narg(since)::TIMESTAMP IS NULL OR created_at >= sqlc.narg(since))
+    AND (sqlc.narg(until)::TIMESTAMP IS NULL OR created_at < sqlc.narg(until))
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: ListSecurityEvents :many
+SELECT *
+FROM audit_events
+WHERE target_id = $1 AND event_type = ANY(sqlc.arg(event_types)::TEXT[])
+ORDER BY created_at DESC, id
+LIMIT sqlc.arg(max_results) OFFSET sqlc.arg(skip);
@@ -1,10 +1,14 @@
--- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at)
+-- name: CreateRefreshToken :exec
+INSERT INTO refresh_tokens(token_hash, created_at, updated_at, user_id, family_id, expires_at, user_agent, ip_address, device_name, last_used_at)
 VALUES(
     $1,
     now(),
     now(),
     $2,
-    now() + INTERVAL '60 days'
-)
-RETURNING token;
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    now()
+);
@@ -0,0 +1,30 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens(token_hash, created_at, user_id, email, expires_at)
+VALUES(
+    $1,
+    now(),
+    $2,
+    $3,
+    $4
+);
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING user_id, email;
+
+-- name: MarkEmailVerified :execrows
+UPDATE users
+SET email_verified_at = now(), updated_at = now()
+WHERE id = $1 AND email = $2;
+
+-- name: ConfirmPendingEmail :execrows
+UPDATE users
+SET email = pending_email, pending_email = NULL, email_verified_at = now(), updated_at = now()
+WHERE id = $1 AND pending_email = $2;
+
+-- name: SetPendingEmail :exec
+UPDATE users
+SET pending_email = $2, updated_at = now()
+WHERE id = $1;
@@ -0,0 +1,56 @@
+-- name: CreateExportJob :one
+INSERT INTO export_jobs (id, user_id, created_at)
+VALUES (gen_random_uuid(), $1, now())
+RETURNING id, user_id, status, created_at, started_at, completed_at, expires_at, error;
+
+-- name: GetActiveExportJob :one
+SELECT id, user_id, status, created_at, started_at, completed_at, expires_at, error
+FROM export_jobs
+WHERE user_id = $1 AND status IN ('pending', 'running')
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: GetExportJob :one
+SELECT id, user_id, status, created_at, started_at, completed_at, expires_at, error
+FROM export_jobs
+WHERE id = $1 AND user_id = $2;
+
+-- name: ClaimExportJob :one
+-- Takes the oldest pending job, or one whose worker seems to have died.
+UPDATE export_jobs
+SET status = 'running', started_at = now(), claim_id = gen_random_uuid()
+WHERE id = (
+    SELECT queued.id
+    FROM export_jobs AS queued
+    WHERE queued.status = 'pending' OR (queued.status = 'running' AND queued.started_at < sqlc.arg(stale_before))
+    ORDER BY queued.created_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING id, user_id, claim_id;
+
+-- name: CompleteExportJob :execrows
+-- Only the worker holding the latest claim can finish a job.
+UPDATE export_jobs
+SET status = 'ready', completed_at = now(), expires_at = $3, archive = $4
+WHERE id = $1 AND status = 'running' AND claim_id = $2;
+
+-- name: FailExportJob :execrows
+UPDATE export_jobs
+SET status = 'failed', completed_at = now(), error = $3
+WHERE id = $1 AND status = 'running' AND claim_id = $2;
+
+-- name: GetExportArchive :one
+SELECT user_id, archive
+FROM export_jobs
+WHERE id = $1 AND status = 'ready' AND expires_at > now();
+
+-- name: DeleteExpiredExports :exec
+DELETE FROM export_jobs
+WHERE expires_at <= now() OR (status = 'failed' AND completed_at < now() - interval '7 days');
+
+-- name: GetChirpsByUser :many
+SELECT *
+FROM chirps
+WHERE user_id = $1
+ORDER BY created_at ASC;
@@ -1,4 +1,5 @@
 -- name: GetAllChirps :many
 SELECT *
 FROM chirps
+WHERE hidden_at IS NULL
 ORDER BY created_at ASC;
@@ -1,4 +1,4 @@
 -- name: GetUserFromRefreshToken :one
 SELECT user_id
 FROM refresh_tokens
-WHERE token = $1 AND revoked_at IS NULL;
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now();
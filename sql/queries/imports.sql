@@ -0,0 +1,58 @@
+-- name: CreateImportJob :one
+-- Jobs the CLI runs itself start out running, so no worker takes them, and
+-- come with the claim the CLI writes to them under.
+INSERT INTO import_jobs (id, user_id, status, format, created_at, heartbeat_at, archive, total_rows, claim_id)
+VALUES (gen_random_uuid(), $1, $2, $3, now(), now(), $4, $5, $6)
+RETURNING id, user_id, status, format, created_at, started_at, completed_at, total_rows, processed_rows, imported_rows, skipped_rows, failed_rows, row_errors, error;
+
+-- name: GetImportJob :one
+SELECT id, user_id, status, format, created_at, started_at, completed_at, total_rows, processed_rows, imported_rows, skipped_rows, failed_rows, row_errors, error
+FROM import_jobs
+WHERE id = $1 AND user_id = $2;
+
+-- name: ClaimImportJob :one
+-- Takes the oldest pending job, or one whose worker stopped reporting
+-- progress, which is then picked up where it left off.
+UPDATE import_jobs
+SET status = 'running', started_at = COALESCE(started_at, now()), heartbeat_at = now(), claim_id = gen_random_uuid()
+WHERE id = (
+    SELECT queued.id
+    FROM import_jobs AS queued
+    WHERE queued.status = 'pending' OR (queued.status = 'running' AND queued.heartbeat_at < sqlc.arg(stale_before))
+    ORDER BY queued.created_at
+    FOR UPDATE SKIP LOCKED
+    LIMIT 1
+)
+RETURNING id, user_id, claim_id, format, archive, processed_rows, imported_rows, skipped_rows, failed_rows;
+
+-- name: ImportChirp :execrows
+-- Rows already imported under the same external ID are left alone.
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, external_id)
+VALUES (gen_random_uuid(), sqlc.arg(created_at), sqlc.arg(created_at), sqlc.arg(body), sqlc.arg(user_id), sqlc.arg(external_id))
+ON CONFLICT (user_id, external_id) WHERE external_id IS NOT NULL DO NOTHING;
+
+-- name: RecordImportProgress :execrows
+-- Only the worker holding the latest claim can write to a job. Its counts
+-- are totals, which it carried on from what the job had when it claimed it.
+UPDATE import_jobs
+SET processed_rows = $3,
+    imported_rows = $4,
+    skipped_rows = $5,
+    failed_rows = $6,
+    row_errors = row_errors || sqlc.arg(new_row_errors)::jsonb,
+    heartbeat_at = now()
+WHERE id = $1 AND status = 'running' AND claim_id = $2;
+
+-- name: CompleteImportJob :execrows
+UPDATE import_jobs
+SET status = 'done', completed_at = now(), archive = NULL
+WHERE id = $1 AND status = 'running' AND claim_id = $2;
+
+-- name: FailImportJob :execrows
+UPDATE import_jobs
+SET status = 'failed', completed_at = now(), archive = NULL, error = $3
+WHERE id = $1 AND status = 'running' AND claim_id = $2;
+
+-- name: DeleteFinishedImports :exec
+DELETE FROM import_jobs
+WHERE completed_at < now() - interval '30 days';
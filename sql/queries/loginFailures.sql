@@ -0,0 +1,34 @@
+-- name: GetActiveLockouts :many
+SELECT subject, locked_until
+FROM login_failures
+WHERE subject = ANY(sqlc.arg(subjects)::text[]) AND locked_until > now();
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_failures(subject, failures, last_failure_at)
+VALUES(
+    $1,
+    1,
+    now()
+)
+ON CONFLICT (subject) DO UPDATE
+SET failures = CASE
+        WHEN login_failures.last_failure_at < sqlc.arg(reset_before) THEN 1
+        ELSE login_failures.failures + 1
+    END,
+    last_failure_at = now()
+RETURNING failures;
+
+-- name: LockLogin :exec
+UPDATE login_failures
+SET locked_until = $2
+WHERE subject = $1;
+
+-- name: ClearLoginFailures :execrows
+DELETE FROM login_failures
+WHERE subject = $1;
+
+-- name: ListActiveLockouts :many
+SELECT *
+FROM login_failures
+WHERE locked_until > now()
+ORDER BY locked_until DESC;
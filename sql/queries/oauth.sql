@@ -0,0 +1,74 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, owner_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    now()
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT *
+FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListOAuthClients :many
+SELECT *
+FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE owner_id = $1 AND id = $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes
+SET used_at = now()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING *;
+
+-- name: CreateOAuthGrant :exec
+INSERT INTO oauth_grants (id, client_id, user_id, scopes, refresh_token_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5, now(), $6);
+
+-- name: GetOAuthGrant :one
+SELECT *
+FROM oauth_grants
+WHERE id = $1 AND revoked_at IS NULL AND expires_at > now();
+
+-- name: GetOAuthGrantByRefreshToken :one
+SELECT *
+FROM oauth_grants
+WHERE refresh_token_hash = $1 AND revoked_at IS NULL AND expires_at > now();
+
+-- name: RotateOAuthRefreshToken :one
+UPDATE oauth_grants
+SET previous_refresh_token_hash = refresh_token_hash,
+    refresh_token_hash = sqlc.arg(new_hash),
+    expires_at = sqlc.arg(expires_at)
+WHERE refresh_token_hash = sqlc.arg(old_hash) AND revoked_at IS NULL AND expires_at > now()
+RETURNING *;
+
+-- name: RevokeOAuthGrantByPreviousRefreshToken :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE previous_refresh_token_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAllOAuthGrants :exec
+UPDATE oauth_grants
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
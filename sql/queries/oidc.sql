@@ -0,0 +1,67 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states(state_hash, nonce, code_verifier, device_name, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    $5
+);
+
+-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE state_hash = $1
+RETURNING nonce, code_verifier, device_name, expires_at;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < now();
+
+-- name: GetUserByIdentity :one
+UPDATE user_identities
+SET last_login_at = now()
+WHERE issuer = $1 AND subject = $2
+RETURNING user_id;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities(issuer, subject, user_id, email, created_at, last_login_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    now()
+);
+
+-- name: CreateExternalUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, email_verified_at)
+VALUES (
+    gen_random_uuid(),
+    now(),
+    now(),
+    $1,
+    '',
+    now()
+)
+RETURNING *;
+
+-- name: CreateOIDCLoginCode :exec
+INSERT INTO oidc_login_codes(code_hash, user_id, device_name, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    now(),
+    $4
+);
+
+-- name: UseOIDCLoginCode :one
+DELETE FROM oidc_login_codes
+WHERE code_hash = $1 AND expires_at > now()
+RETURNING user_id, device_name;
+
+-- name: DeleteExpiredOIDCLoginCodes :exec
+DELETE FROM oidc_login_codes
+WHERE expires_at < now();
@@ -0,0 +1,59 @@
+-- name: CreatePasskey :one
+INSERT INTO passkeys(id, user_id, name, credential_id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created_at)
+VALUES(
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    $10,
+    now()
+)
+RETURNING *;
+
+-- name: ListPasskeys :many
+SELECT *
+FROM passkeys
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: UsePasskey :exec
+UPDATE passkeys
+SET sign_count = $2, backup_state = $3, last_used_at = now()
+WHERE credential_id = $1;
+
+-- name: DeletePasskey :execrows
+DELETE FROM passkeys
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebAuthnCeremony :exec
+INSERT INTO webauthn_ceremonies(id, kind, user_id, state, created_at, expires_at)
+VALUES(
+    $1,
+    $2,
+    $3,
+    $4,
+    now(),
+    $5
+);
+
+-- name: UseWebAuthnCeremony :one
+DELETE FROM webauthn_ceremonies
+WHERE id = $1 AND kind = $2 AND user_id IS NOT DISTINCT FROM sqlc.narg(user_id) AND expires_at > now()
+RETURNING state;
+
+-- name: DeleteExpiredWebAuthnCeremonies :exec
+DELETE FROM webauthn_ceremonies
+WHERE expires_at < now();
+
+-- name: HasPasskeys :one
+SELECT EXISTS (
+    SELECT 1
+    FROM passkeys
+    WHERE user_id = $1
+);
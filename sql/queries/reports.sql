@@ -0,0 +1,72 @@
+-- name: OpenReport :one
+INSERT INTO reports (id, created_at, updated_at, target_type, target_id, target_user_id, content)
+VALUES (
+    gen_random_uuid(),
+    now(),
+    now(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (target_type, target_id) WHERE status <> 'resolved'
+DO UPDATE SET updated_at = now()
+RETURNING id;
+
+-- name: AddReportSubmission :execrows
+INSERT INTO report_submissions (report_id, reporter_id, reason, details, created_at)
+VALUES ($1, $2, $3, $4, now())
+ON CONFLICT (report_id, reporter_id) DO NOTHING;
+
+-- name: ListReports :many
+SELECT reports.*,
+    count(report_submissions.reporter_id) AS reporters,
+    array_remove(array_agg(DISTINCT report_submissions.reason), NULL)::TEXT[] AS reasons
+FROM reports
+LEFT JOIN report_submissions ON report_submissions.report_id = reports.id
+WHERE reports.status = ANY(sqlc.arg(statuses)::TEXT[])
+GROUP BY reports.id
+ORDER BY count(report_submissions.reporter_id) DESC, reports.created_at ASC
+LIMIT sqlc.arg(max_results) OFFSET sqlc.arg(skip);
+
+-- name: GetReport :one
+SELECT *
+FROM reports
+WHERE id = $1;
+
+-- name: ListReportSubmissions :many
+SELECT *
+FROM report_submissions
+WHERE report_id = $1
+ORDER BY created_at ASC;
+
+-- name: ClaimReport :execrows
+UPDATE reports
+SET status = 'claimed', claimed_by = sqlc.arg(moderator_id)::UUID, claimed_at = now(), updated_at = now()
+WHERE id = sqlc.arg(id) AND (status = 'open' OR (status = 'claimed' AND claimed_by = sqlc.arg(moderator_id)::UUID));
+
+-- name: ResolveReport :execrows
+UPDATE reports
+SET status = 'resolved',
+    resolved_by = sqlc.arg(moderator_id)::UUID,
+    resolved_at = now(),
+    action = sqlc.arg(action),
+    resolution_note = sqlc.arg(note),
+    updated_at = now()
+WHERE id = sqlc.arg(id) AND status = 'claimed' AND claimed_by = sqlc.arg(moderator_id)::UUID;
+
+-- name: ListReportersToNotify :many
+SELECT users.id, users.email
+FROM report_submissions
+JOIN users ON users.id = report_submissions.reporter_id
+WHERE report_submissions.report_id = $1 AND report_submissions.notified_at IS NULL;
+
+-- name: MarkReporterNotified :exec
+UPDATE report_submissions
+SET notified_at = now()
+WHERE report_id = $1 AND reporter_id = $2;
+
+-- name: HideChirp :execrows
+UPDATE chirps
+SET hidden_at = now(), updated_at = now()
+WHERE id = $1 AND hidden_at IS NULL;
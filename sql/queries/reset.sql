@@ -1,2 +1,2 @@
 -- name: ResetDB :exec
-TRUNCATE users, chirps, refresh_tokens;
+TRUNCATE users, chirps, refresh_tokens, password_reset_tokens, email_verification_tokens, recovery_codes, login_failures, audit_events, api_keys, oauth_clients, oauth_authorization_codes, oauth_grants, user_identities, oidc_login_states, oidc_login_codes, passkeys, webauthn_ceremonies, reports, report_submissions, export_jobs, import_jobs, used_tokens;
@@ -1,4 +1,5 @@
--- name: RevokeRefreshToken :exec
+-- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET updated_at = now(), revoked_at = now()
-WHERE token = $1;
+WHERE token_hash = $1 AND revoked_at IS NULL
+RETURNING user_id, family_id;
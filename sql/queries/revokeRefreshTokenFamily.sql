@@ -0,0 +1,4 @@
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET updated_at = now(), revoked_at = now()
+WHERE family_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,16 @@
+-- name: BootstrapAdmin :execrows
+UPDATE users
+SET role = 'admin', token_version = token_version + 1, updated_at = now()
+WHERE users.email = $1 AND NOT EXISTS (
+    SELECT 1
+    FROM users AS admins
+    WHERE admins.role = 'admin'
+);
+
+-- name: LockAdmins :exec
+SELECT pg_advisory_xact_lock(hashtext('chirpy.admins'));
+
+-- name: CountActiveAdmins :one
+SELECT count(*)
+FROM users
+WHERE role = 'admin' AND suspended_at IS NULL AND deletion_due_at IS NULL;
@@ -0,0 +1,5 @@
+-- name: RotateRefreshToken :one
+UPDATE refresh_tokens
+SET updated_at = now(), revoked_at = now(), replaced_by_hash = $2
+WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > now()
+RETURNING *;
@@ -0,0 +1,15 @@
+-- name: ListSessions :many
+SELECT family_id, device_name, user_agent, ip_address, last_used_at, expires_at
+FROM refresh_tokens
+WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > now()
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET updated_at = now(), revoked_at = now()
+WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE refresh_tokens
+SET updated_at = now(), revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,8 @@
+-- name: UseToken :execrows
+INSERT INTO used_tokens(token_hash, expires_at)
+VALUES($1, $2)
+ON CONFLICT (token_hash) DO NOTHING;
+
+-- name: DeleteExpiredUsedTokens :exec
+DELETE FROM used_tokens
+WHERE expires_at < now();
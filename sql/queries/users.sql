@@ -8,3 +8,29 @@ VALUES (
     $2
 )
 RETURNING *;
+
+-- name: GetTokenVersion :one
+SELECT token_version
+FROM users
+WHERE id = $1;
+
+-- name: IncrementTokenVersion :one
+UPDATE users
+SET token_version = token_version + 1, updated_at = now()
+WHERE id = $1
+RETURNING token_version;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET hashed_password = $2, updated_at = now()
+WHERE id = $1;
+
+-- name: GetUserByID :one
+SELECT *
+FROM users
+WHERE id = $1;
+
+-- name: RehashPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = sqlc.arg(id) AND hashed_password = sqlc.arg(old_hash);
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Tokens stored in plaintext may already have leaked, so they're deleted
+-- rather than hashed, and everyone logs in again.
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+ALTER TABLE refresh_tokens
+RENAME COLUMN replaced_by TO replaced_by_hash;
+-- +goose Down
+-- Hashes can't be turned back into tokens, so going back logs everyone out.
+DELETE FROM refresh_tokens;
+ALTER TABLE refresh_tokens
+RENAME COLUMN replaced_by_hash TO replaced_by;
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE refresh_tokens
+ADD user_agent TEXT NOT NULL DEFAULT '',
+ADD ip_address TEXT NOT NULL DEFAULT '',
+ADD device_name TEXT NOT NULL DEFAULT '',
+ADD last_used_at TIMESTAMP NOT NULL DEFAULT now();
+ALTER TABLE users
+ADD token_version INTEGER NOT NULL DEFAULT 0;
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN token_version;
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at,
+DROP COLUMN device_name,
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
@@ -0,0 +1,20 @@
+-- +goose Up
+ALTER TABLE users
+ADD email_verified_at TIMESTAMP DEFAULT NULL,
+ADD pending_email TEXT DEFAULT NULL;
+-- Accounts created before verification existed are grandfathered in.
+UPDATE users
+SET email_verified_at = created_at;
+CREATE TABLE email_verification_tokens(
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL
+);
+-- +goose Down
+DROP TABLE email_verification_tokens;
+ALTER TABLE users
+DROP COLUMN pending_email,
+DROP COLUMN email_verified_at;
@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE login_failures(
+    subject TEXT PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ DEFAULT NULL
+);
+CREATE TABLE audit_events(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    event_type TEXT NOT NULL,
+    actor_id UUID DEFAULT NULL,
+    target_id UUID DEFAULT NULL,
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    payload JSONB NOT NULL DEFAULT '{}'
+);
+-- +goose Down
+DROP TABLE audit_events;
+DROP TABLE login_failures;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE api_keys(
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    last_used_at TIMESTAMP DEFAULT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL
+);
+CREATE INDEX api_keys_user_id_idx ON api_keys(user_id);
+-- +goose Down
+DROP TABLE api_keys;
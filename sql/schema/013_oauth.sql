@@ -0,0 +1,37 @@
+-- +goose Up
+CREATE TABLE oauth_clients(
+    id TEXT PRIMARY KEY,
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    secret_hash TEXT NOT NULL DEFAULT '',
+    redirect_uris TEXT[] NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+CREATE TABLE oauth_authorization_codes(
+    code_hash TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMP DEFAULT NULL
+);
+CREATE TABLE oauth_grants(
+    id UUID PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    refresh_token_hash TEXT NOT NULL UNIQUE,
+    previous_refresh_token_hash TEXT DEFAULT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMP DEFAULT NULL
+);
+CREATE INDEX oauth_grants_user_id_idx ON oauth_grants(user_id);
+CREATE INDEX oauth_grants_previous_refresh_token_hash_idx ON oauth_grants(previous_refresh_token_hash);
+-- +goose Down
+DROP TABLE oauth_grants;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
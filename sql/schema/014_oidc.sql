@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE user_identities(
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_login_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+CREATE TABLE oidc_login_states(
+    state_hash TEXT PRIMARY KEY,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    device_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose Down
+DROP TABLE oidc_login_states;
+DROP TABLE user_identities;
@@ -0,0 +1,28 @@
+-- +goose Up
+CREATE TABLE passkeys(
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    public_key BYTEA NOT NULL,
+    attestation_type TEXT NOT NULL,
+    aaguid BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL,
+    transports TEXT[] NOT NULL,
+    backup_eligible BOOLEAN NOT NULL,
+    backup_state BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP DEFAULT NULL
+);
+CREATE INDEX passkeys_user_id_idx ON passkeys(user_id);
+CREATE TABLE webauthn_ceremonies(
+    id UUID PRIMARY KEY,
+    kind TEXT NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    state BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose Down
+DROP TABLE webauthn_ceremonies;
+DROP TABLE passkeys;
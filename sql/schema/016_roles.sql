@@ -0,0 +1,6 @@
+-- +goose Up
+ALTER TABLE users
+ADD role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'moderator', 'admin'));
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN role;
@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users
+ADD suspended_at TIMESTAMP DEFAULT NULL,
+ADD suspension_reason TEXT NOT NULL DEFAULT '';
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN suspension_reason,
+DROP COLUMN suspended_at;
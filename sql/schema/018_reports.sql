@@ -0,0 +1,39 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD hidden_at TIMESTAMP DEFAULT NULL;
+
+-- A report is the moderation case for one chirp or user. Everyone who
+-- flags the target while the case is unresolved joins it as a submission.
+CREATE TABLE reports(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('chirp', 'user')),
+    target_id UUID NOT NULL,
+    target_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'claimed', 'resolved')),
+    claimed_by UUID DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL,
+    claimed_at TIMESTAMP DEFAULT NULL,
+    resolved_by UUID DEFAULT NULL REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP DEFAULT NULL,
+    action TEXT NOT NULL DEFAULT '',
+    resolution_note TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX reports_unresolved_target ON reports(target_type, target_id) WHERE status <> 'resolved';
+CREATE INDEX reports_status ON reports(status, created_at);
+
+CREATE TABLE report_submissions(
+    report_id UUID NOT NULL REFERENCES reports(id) ON DELETE CASCADE,
+    reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    notified_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (report_id, reporter_id)
+);
+-- +goose Down
+DROP TABLE report_submissions;
+DROP TABLE reports;
+ALTER TABLE chirps
+DROP COLUMN hidden_at;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- The audit log is append-only. Rows can't be changed or deleted, and
+-- TRUNCATE, which skips row triggers, is left to the dev reset.
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+CREATE TRIGGER audit_events_immutable
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_immutable();
+
+CREATE INDEX audit_events_created_at ON audit_events(created_at);
+CREATE INDEX audit_events_actor ON audit_events(actor_id, created_at);
+CREATE INDEX audit_events_target ON audit_events(target_id, created_at);
+CREATE INDEX audit_events_type ON audit_events(event_type, created_at);
+-- +goose Down
+DROP INDEX audit_events_type;
+DROP INDEX audit_events_target;
+DROP INDEX audit_events_actor;
+DROP INDEX audit_events_created_at;
+DROP TRIGGER audit_events_immutable ON audit_events;
+DROP FUNCTION audit_events_immutable();
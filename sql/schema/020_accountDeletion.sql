@@ -0,0 +1,8 @@
+-- +goose Up
+ALTER TABLE users
+ADD deletion_due_at TIMESTAMPTZ DEFAULT NULL;
+CREATE INDEX users_deletion_due_at ON users(deletion_due_at) WHERE deletion_due_at IS NOT NULL;
+-- +goose Down
+DROP INDEX users_deletion_due_at;
+ALTER TABLE users
+DROP COLUMN deletion_due_at;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE export_jobs(
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMPTZ DEFAULT NULL,
+    completed_at TIMESTAMP DEFAULT NULL,
+    expires_at TIMESTAMPTZ DEFAULT NULL,
+    archive BYTEA DEFAULT NULL,
+    error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX export_jobs_user ON export_jobs(user_id, created_at);
+CREATE INDEX export_jobs_queue ON export_jobs(status, created_at) WHERE status IN ('pending', 'running');
+-- +goose Down
+DROP TABLE export_jobs;
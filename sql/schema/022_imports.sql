@@ -0,0 +1,27 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN external_id TEXT DEFAULT NULL;
+CREATE UNIQUE INDEX chirps_external_id ON chirps(user_id, external_id) WHERE external_id IS NOT NULL;
+CREATE TABLE import_jobs(
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'done', 'failed')),
+    format TEXT NOT NULL CHECK (format IN ('jsonl', 'csv')),
+    created_at TIMESTAMP NOT NULL,
+    started_at TIMESTAMP DEFAULT NULL,
+    heartbeat_at TIMESTAMPTZ DEFAULT NULL,
+    completed_at TIMESTAMP DEFAULT NULL,
+    archive BYTEA DEFAULT NULL,
+    total_rows INTEGER NOT NULL,
+    processed_rows INTEGER NOT NULL DEFAULT 0,
+    imported_rows INTEGER NOT NULL DEFAULT 0,
+    skipped_rows INTEGER NOT NULL DEFAULT 0,
+    failed_rows INTEGER NOT NULL DEFAULT 0,
+    row_errors JSONB NOT NULL DEFAULT '[]',
+    error TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX import_jobs_user ON import_jobs(user_id, created_at);
+CREATE INDEX import_jobs_queue ON import_jobs(status, created_at) WHERE status IN ('pending', 'running');
+-- +goose Down
+DROP TABLE import_jobs;
+DROP INDEX chirps_external_id;
+ALTER TABLE chirps DROP COLUMN external_id;
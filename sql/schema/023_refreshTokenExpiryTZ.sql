@@ -0,0 +1,9 @@
+-- +goose Up
+-- Refresh token expiry is written by the server and compared with now() in
+-- the database. As TIMESTAMPTZ it's an instant, so that works whatever time
+-- zone either side is in. The server wrote its times in UTC.
+ALTER TABLE refresh_tokens
+ALTER COLUMN expires_at TYPE TIMESTAMPTZ USING expires_at AT TIME ZONE 'UTC';
+-- +goose Down
+ALTER TABLE refresh_tokens
+ALTER COLUMN expires_at TYPE TIMESTAMP USING expires_at AT TIME ZONE 'UTC';
@@ -0,0 +1,10 @@
+-- +goose Up
+CREATE TABLE oidc_login_codes(
+    code_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    device_name TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose Down
+DROP TABLE oidc_login_codes;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Short-lived tokens that may only be used once, such as MFA challenge
+-- tokens, are remembered here until they expire.
+CREATE TABLE used_tokens(
+    token_hash TEXT PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+-- +goose Down
+DROP TABLE used_tokens;
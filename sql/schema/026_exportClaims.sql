@@ -0,0 +1,7 @@
+-- +goose Up
+-- Each claim of an export gets a new claim ID, and a worker can only finish
+-- the job while its claim is the latest, so one that was thought dead and
+-- overtaken can't overwrite the worker that took over.
+ALTER TABLE export_jobs ADD COLUMN claim_id UUID DEFAULT NULL;
+-- +goose Down
+ALTER TABLE export_jobs DROP COLUMN claim_id;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Imports are claimed the same way as exports, and a worker can only record
+-- progress on, finish or fail an import while its claim is the latest.
+ALTER TABLE import_jobs ADD COLUMN claim_id UUID DEFAULT NULL;
+-- +goose Down
+ALTER TABLE import_jobs DROP COLUMN claim_id;
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+// maxJSONBodySize caps request bodies that are decoded as JSON. Nothing the
+// API takes comes anywhere near it.
+const maxJSONBodySize = 64 << 10
+
+// fieldError is one thing wrong with one field of a request body.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// Violations are the password policy rules a password broke.
+	Violations []auth.PolicyViolation `json:"violations,omitempty"`
+}
+
+// fieldErrors collects what's wrong with a request body, so it can all be
+// reported at once.
+type fieldErrors []fieldError
+
+func (e *fieldErrors) add(field, message string) {
+	*e = append(*e, fieldError{Field: field, Message: message})
+}
+
+// check responds with a 422 listing the errors and returns false when there
+// are any. Password policy violations are also given at the top level, as
+// they were when a weak password got a 400.
+func (e fieldErrors) check(w http.ResponseWriter) bool {
+	if len(e) == 0 {
+		return true
+	}
+	violations := []auth.PolicyViolation{}
+	for _, fieldErr := range e {
+		violations = append(violations, fieldErr.Violations...)
+	}
+	respondWithProblem(w, problem{
+		Status:     422,
+		Detail:     "Request has invalid fields",
+		Errors:     e,
+		Violations: violations,
+	})
+	return false
+}
+
+// decodeJSON decodes a JSON request body into dst, which has to account for
+// every field the body has. When it can't, it responds with a 415 for a body
+// that isn't JSON, a 413 for one that's too large, a 422 for a field of the
+// wrong type and a 400 for anything else, and returns false.
+func decodeJSON(w http.ResponseWriter, req *http.Request, dst any) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		respondWithError(w, 415, "Content-Type must be application/json")
+		return false
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxJSONBodySize))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(dst)
+	if err == nil {
+		if decoder.Decode(&struct{}{}) != io.EOF {
+			respondWithError(w, 400, "Request body must hold a single JSON object")
+			return false
+		}
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		respondWithError(w, 413, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+	case errors.Is(err, io.EOF):
+		respondWithError(w, 400, "Request body is empty")
+	case errors.As(err, &syntaxErr):
+		respondWithError(w, 400, fmt.Sprintf("Request body is malformed JSON at byte %d", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		respondWithError(w, 400, "Request body is malformed JSON")
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		errs := fieldErrors{}
+		errs.add(typeErr.Field, fmt.Sprintf("%s must be a %s", typeErr.Field, jsonTypeName(typeErr.Type.Kind().String())))
+		errs.check(w)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		respondWithError(w, 400, fmt.Sprintf("Request body has an unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field ")))
+	default:
+		respondWithError(w, 400, "Request body must be a JSON object")
+	}
+	return false
+}
+
+// jsonTypeName names a Go kind the way JSON would.
+func jsonTypeName(kind string) string {
+	switch {
+	case kind == "bool":
+		return "boolean"
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "number"
+	case kind == "slice" || kind == "array":
+		return "list"
+	case kind == "map" || kind == "struct":
+		return "object"
+	}
+	return kind
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wjseele/chirpy/internal/auth"
+)
+
+type decodeTarget struct {
+	Email string   `json:"email"`
+	Age   int      `json:"age"`
+	Tags  []string `json:"tags"`
+}
+
+// decodeProblem reads the problem a handler responded with.
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem {
+	t.Helper()
+	p := problem{}
+	err := json.Unmarshal(rec.Body.Bytes(), &p)
+	if err != nil {
+		t.Fatalf("Error generated: Got %v, expected nil", err)
+	}
+	return p
+}
+
+func TestDecodeJSON(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		detail      string
+		field       string
+	}{
+		{"valid", "application/json", `{"email": "user@example.com", "age": 3}`, 0, "", ""},
+		{"charset", "application/json; charset=utf-8", `{"email": "user@example.com"}`, 0, "", ""},
+		{"not json", "text/plain", `{}`, 415, "Content-Type must be application/json", ""},
+		{"no content type", "", `{}`, 415, "Content-Type must be application/json", ""},
+		{"oversize", "application/json", `{"email": "` + strings.Repeat("a", maxJSONBodySize) + `"}`, 413, "Request body is larger than 65536 bytes", ""},
+		{"empty", "application/json", ``, 400, "Request body is empty", ""},
+		{"malformed", "application/json", `{"email" "user@example.com"}`, 400, "Request body is malformed JSON at byte 10", ""},
+		{"truncated", "application/json", `{"email": "user@`, 400, "Request body is malformed JSON", ""},
+		{"wrong type", "application/json", `{"age": "three"}`, 422, "Request has invalid fields", "age"},
+		{"wrong type list", "application/json", `{"tags": "a"}`, 422, "Request has invalid fields", "tags"},
+		{"unknown field", "application/json", `{"email": "user@example.com", "admin": true}`, 400, `Request body has an unknown field "admin"`, ""},
+		{"trailing data", "application/json", `{"email": "user@example.com"} {}`, 400, "Request body must hold a single JSON object", ""},
+		{"not an object", "application/json", `["user@example.com"]`, 400, "Request body must be a JSON object", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(c.body))
+			if c.contentType != "" {
+				req.Header.Set("Content-Type", c.contentType)
+			}
+			rec := httptest.NewRecorder()
+			dst := decodeTarget{}
+			ok := decodeJSON(rec, req, &dst)
+
+			if c.status == 0 {
+				if !ok {
+					t.Fatalf("Didn't decode: Got %d %s", rec.Code, rec.Body)
+				}
+				if dst.Email != "user@example.com" {
+					t.Errorf("Didn't get correct email: Got %q, expected user@example.com", dst.Email)
+				}
+				return
+			}
+			if ok {
+				t.Fatalf("Decoded a bad body: Got %+v", dst)
+			}
+			if rec.Code != c.status {
+				t.Errorf("Didn't get correct status: Got %d, expected %d", rec.Code, c.status)
+			}
+			p := decodeProblem(t, rec)
+			if p.Detail != c.detail {
+				t.Errorf("Didn't get correct detail: Got %q, expected %q", p.Detail, c.detail)
+			}
+			if c.field != "" && (len(p.Errors) != 1 || p.Errors[0].Field != c.field) {
+				t.Errorf("Didn't get correct field errors: Got %+v, expected one on %s", p.Errors, c.field)
+			}
+		})
+	}
+}
+
+// TestUnknownFieldError pins the text decodeJSON picks unknown fields out by,
+// which encoding/json doesn't give a type of its own.
+func TestUnknownFieldError(t *testing.T) {
+	decoder := json.NewDecoder(strings.NewReader(`{"admin": true}`))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&decodeTarget{})
+	if err == nil || err.Error() != `json: unknown field "admin"` {
+		t.Errorf("Didn't get correct error: Got %v, expected json: unknown field \"admin\"", err)
+	}
+}
+
+func TestFieldErrorsCheck(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if !(fieldErrors{}).check(rec) {
+		t.Errorf("Didn't pass with no errors")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("Responded with no errors: Got %s", rec.Body)
+	}
+
+	violation := auth.PolicyViolation{Rule: "min_length", Message: "Too short"}
+	errs := fieldErrors{}
+	errs.add("email", "Email address is invalid")
+	errs = append(errs, fieldError{Field: "password", Message: "Weak", Violations: []auth.PolicyViolation{violation}})
+	rec = httptest.NewRecorder()
+	if errs.check(rec) {
+		t.Fatalf("Passed with errors")
+	}
+	if rec.Code != 422 {
+		t.Errorf("Didn't get correct status: Got %d, expected 422", rec.Code)
+	}
+	if rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Didn't get correct content type: Got %q", rec.Header().Get("Content-Type"))
+	}
+	p := decodeProblem(t, rec)
+	if p.Code != "validation_failed" || len(p.Errors) != 2 || p.Errors[0].Field != "email" || p.Errors[1].Field != "password" {
+		t.Errorf("Didn't get correct problem: Got %+v", p)
+	}
+	if len(p.Violations) != 1 || p.Violations[0] != violation {
+		t.Errorf("Didn't get correct violations: Got %+v, expected [%+v]", p.Violations, violation)
+	}
+}
+
+func TestJSONTypeName(t *testing.T) {
+	cases := []struct {
+		kind string
+		want string
+	}{
+		{"bool", "boolean"},
+		{"int", "number"},
+		{"int32", "number"},
+		{"uint8", "number"},
+		{"float64", "number"},
+		{"string", "string"},
+		{"slice", "list"},
+		{"array", "list"},
+		{"map", "object"},
+		{"struct", "object"},
+	}
+	for _, c := range cases {
+		got := jsonTypeName(c.kind)
+		if got != c.want {
+			t.Errorf("jsonTypeName(%q): Got %q, expected %q", c.kind, got, c.want)
+		}
+	}
+}
+
+func TestCleanChirp(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+		err  error
+	}{
+		{"hello world", "hello world", nil},
+		{"what a Kerfuffle today", "what a **** today", nil},
+		{"sharbert! stays", "sharbert! stays", nil},
+		{"", "", errChirpEmpty},
+		{"  \n ", "", errChirpEmpty},
+		{strings.Repeat("a", 140), strings.Repeat("a", 140), nil},
+		{strings.Repeat("a", 141), "", errChirpTooLong},
+	}
+	for _, c := range cases {
+		got, err := cleanChirp(c.body)
+		if !errors.Is(err, c.err) {
+			t.Errorf("Error generated for %q: Got %v, expected %v", c.body, err, c.err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("cleanChirp(%q): Got %q, expected %q", c.body, got, c.want)
+		}
+	}
+}